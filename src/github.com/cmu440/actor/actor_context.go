@@ -24,15 +24,23 @@ type ActorContext struct {
 	// goroutine. (Note writes always come from the same goroutine.)
 	sendsMux  *sync.Mutex
 	startTime time.Time
+	// Trace context of the message currently being handled, or nil.
+	// Only accessed while the actor is processing a message, which the
+	// dispatcher does on one goroutine at a time.
+	trace *TraceContext
+	// Per-actor metrics, shared with the system's actorRefInfo.
+	metrics *actorMetrics
 }
 
-func newActorContext(system *ActorSystem, self *ActorRef) *ActorContext {
+func newActorContext(system *ActorSystem, self *ActorRef, metrics *actorMetrics) *ActorContext {
 	return &ActorContext{
 		self,
 		system,
 		make(map[ActorRef]int),
 		&sync.Mutex{},
 		time.Now(),
+		nil,
+		metrics,
 	}
 }
 
@@ -50,14 +58,18 @@ func (context *ActorContext) IsLocal(ref *ActorRef) bool {
 // all messages you send to the same ref will be delivered in the order you
 // sent them (if at all).
 //
+// If the message being handled is traced, the sent message is traced as its
+// child (see tracing.go).
+//
 // See ActorSystem.Tell for more info.
 func (context *ActorContext) Tell(ref *ActorRef, message any) {
 	// Record the send for stats.
 	context.sendsMux.Lock()
 	context.sends[*ref] = context.sends[*ref] + 1
 	context.sendsMux.Unlock()
+	context.metrics.messagesOut.Add(1)
 
-	context.system.tellInternal(ref, message, true)
+	context.system.tellInternal(ref, message, true, context.trace.child())
 }
 
 // Calls Tell after duration d (non-blocking).
@@ -66,10 +78,17 @@ func (context *ActorContext) Tell(ref *ActorRef, message any) {
 // with TellAfter, then when processing that message, send it again with
 // TellAfter, etc.
 func (context *ActorContext) TellAfter(ref *ActorRef, message any, d time.Duration) {
-	context.system.tellAfterInternal(ref, message, d, true)
+	context.system.tellAfterInternal(ref, message, d, true, context.trace.child())
 
 	// Record the send for stats.
 	context.sendsMux.Lock()
 	context.sends[*ref] = context.sends[*ref] + 1
 	context.sendsMux.Unlock()
+	context.metrics.messagesOut.Add(1)
+}
+
+// Returns the trace context of the message currently being handled, or nil
+// if it is untraced.
+func (context *ActorContext) Trace() *TraceContext {
+	return context.trace
 }
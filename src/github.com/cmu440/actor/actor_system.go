@@ -21,20 +21,32 @@ import (
 // Immutable, so access doesn't need a mutex.
 type actorRefInfo struct {
 	// Non-nil if a local actor.
-	// Message type: []byte
+	// Message type: envelope
 	mailbox *Mailbox
 	// Non-nil if a local actor.
 	// We have a separate context per actor so we can track per-actor
 	// stats in it.
 	context *ActorContext
+	// Non-nil if a local actor.
+	metrics *actorMetrics
+	// Non-nil if a local actor.
+	cell *actorCell
 	// Non-nil if a response channel (from NewChannelRef).
 	respCh chan any
 }
 
+// Stores local messages in actors' mailboxes.
+type envelope struct {
+	mars []byte
+	// Nil if the message is untraced.
+	trace *TraceContext
+}
+
 // Stores remote messages in ActorSystem.remotes' mailboxes.
 type remoteMessage struct {
-	mars []byte
-	ref  *ActorRef
+	mars  []byte
+	ref   *ActorRef
+	trace *TraceContext
 }
 
 // An actor system that manages a group of actors.
@@ -64,12 +76,23 @@ type ActorSystem struct {
 	remotes    map[string]*Mailbox
 	remotesMux *sync.Mutex
 	closed     bool
-	// Atomic int32s for Stats().
-	messagesSentActor    int32
-	messagesSentExternal int32
-	bytesSent            int32
-	remoteBytesReceived  int32
-	channelRefsUsed      int32
+	// Pool dispatchers created by NewPoolDispatcher, closed in Close().
+	// Guarded by newActorMux.
+	pools []*poolDispatcher
+	// Receives spans of traced messages; see tracing.go.
+	traceExporter    TraceExporter
+	traceExporterMux *sync.Mutex
+	// Counters for Stats() and Metrics(). 64-bit so they don't overflow
+	// under stress.
+	messagesSentActor    atomic.Int64
+	messagesSentExternal atomic.Int64
+	bytesSent            atomic.Int64
+	remoteBytesReceived  atomic.Int64
+	channelRefsUsed      atomic.Int64
+	// Per-remote-link metrics, keyed by address. Guarded by remotesMux.
+	remoteLinks map[string]*linkMetrics
+	// Set by SetDropFilter; see faults.go.
+	dropFilter atomic.Pointer[func(ref *ActorRef, message any) bool]
 }
 
 // A reference to an Actor, either local or remote, that can be used to
@@ -97,6 +120,9 @@ func (ref *ActorRef) Uid() string {
 // The system listens for messages from remote ActorSystems with an rpc.Server
 // on the given port, which is started before returning. If there is an error
 // starting the server, (nil, the error) is returned instead.
+//
+// If port is 0, an unused port is chosen by the OS; ActorRefs for the
+// system's actors use the actual port.
 func NewActorSystem(port int) (*ActorSystem, error) {
 	// In a real implementation, address would be an external IP address
 	// instead of "localhost". For this assignment, it's okay because all
@@ -104,16 +130,18 @@ func NewActorSystem(port int) (*ActorSystem, error) {
 	address := fmt.Sprintf("localhost:%d", port)
 
 	system := &ActorSystem{
-		address:         address,
-		ln:              nil,
-		newActorMux:     &sync.Mutex{},
-		nextCounter:     0,
-		infos:           &sync.Map{},
-		errorHandler:    nil,
-		errorHandlerMux: &sync.Mutex{},
-		remotes:         make(map[string]*Mailbox),
-		remotesMux:      &sync.Mutex{},
-		closed:          false,
+		address:          address,
+		ln:               nil,
+		newActorMux:      &sync.Mutex{},
+		nextCounter:      0,
+		infos:            &sync.Map{},
+		errorHandler:     nil,
+		errorHandlerMux:  &sync.Mutex{},
+		remotes:          make(map[string]*Mailbox),
+		remoteLinks:      make(map[string]*linkMetrics),
+		remotesMux:       &sync.Mutex{},
+		closed:           false,
+		traceExporterMux: &sync.Mutex{},
 	}
 
 	// Listen for remote Tell calls (as RPCs).
@@ -127,6 +155,9 @@ func NewActorSystem(port int) (*ActorSystem, error) {
 		return nil, err
 	}
 	system.ln = ln
+	if port == 0 {
+		system.address = fmt.Sprintf("localhost:%d", ln.Addr().(*net.TCPAddr).Port)
+	}
 	go func() {
 		for {
 			conn, err := ln.Accept()
@@ -193,6 +224,10 @@ func (system *ActorSystem) Close() {
 		}
 		return true
 	})
+	// Stop pool dispatchers' workers.
+	for _, pool := range system.pools {
+		pool.runQueue.Close()
+	}
 	// Close remote RPC clients.
 	for _, mailbox := range system.remotes {
 		mailbox.Close()
@@ -201,6 +236,12 @@ func (system *ActorSystem) Close() {
 	}
 }
 
+// Returns the address remote systems use to reach this system, as in its
+// actors' ActorRefs.
+func (system *ActorSystem) Address() string {
+	return system.address
+}
+
 // Returns whether ref points to a local actor, i.e., an
 // actor in this ActorSystem.
 func (system *ActorSystem) IsLocal(ref *ActorRef) bool {
@@ -239,6 +280,12 @@ func (system *ActorSystem) reportError(err error) {
 // mutable global variables or closure variables inside an actor or its
 // constructor. To pass initial data to an actor, instead send it a message.
 func (system *ActorSystem) StartActor(newActor func(context *ActorContext) Actor) *ActorRef {
+	return system.StartActorWith(newActor, goroutineDispatcherInstance)
+}
+
+// Like StartActor, but runs the actor with the given Dispatcher instead of
+// on its own goroutine. See dispatcher.go.
+func (system *ActorSystem) StartActorWith(newActor func(context *ActorContext) Actor, dispatcher Dispatcher) *ActorRef {
 	system.newActorMux.Lock()
 	defer system.newActorMux.Unlock()
 
@@ -251,33 +298,22 @@ func (system *ActorSystem) StartActor(newActor func(context *ActorContext) Actor
 	system.nextCounter++
 	ref := &ActorRef{system.address, id}
 	mailbox := NewMailbox()
-	context := newActorContext(system, ref)
-	system.infos.Store(id, &actorRefInfo{mailbox: mailbox, context: context})
+	metrics := newActorMetrics()
+	context := newActorContext(system, ref, metrics)
+	cell := &actorCell{
+		system:     system,
+		context:    context,
+		mailbox:    mailbox,
+		metrics:    metrics,
+		dispatcher: dispatcher,
+	}
+	system.infos.Store(id, &actorRefInfo{mailbox: mailbox, context: context, metrics: metrics, cell: cell})
 
-	actor := newActor(context)
-	go system.runActor(actor, mailbox)
+	cell.actor = newActor(context)
+	dispatcher.start(cell)
 	return ref
 }
 
-func (system *ActorSystem) runActor(actor Actor, mailbox *Mailbox) {
-	for {
-		mars, ok := mailbox.Pop()
-		if !ok {
-			return
-		}
-		message, err := unmarshal(mars.([]byte))
-		if err != nil {
-			system.reportError(err)
-			continue
-		}
-		err = actor.OnMessage(message)
-		if err != nil {
-			system.reportError(err)
-			continue
-		}
-	}
-}
-
 // Returns a synthetic ActorRef and a channel corresponding to that ActorRef.
 // The first message sent to the returned ActorRef (either from a local or
 // remote actor) is delivered on the returned channel.
@@ -334,15 +370,20 @@ func (system *ActorSystem) NewChannelRef() (*ActorRef, <-chan any) {
 // recommend doing this in an init function in the same file where the target
 // actor is defined (example in example/counter actor.go).
 func (system *ActorSystem) Tell(ref *ActorRef, message any) {
-	system.tellInternal(ref, message, false)
+	system.tellInternal(ref, message, false, nil)
 }
 
-// Implements tell and additionally inputs fromActor.
+// Implements tell and additionally inputs fromActor and trace.
 //
 // fromActor is true if the message comes from an actor (including
 // a remote actor), false if it comes from an external Tell
 // call. It is used for Stats.
-func (system *ActorSystem) tellInternal(ref *ActorRef, message any, fromActor bool) {
+//
+// trace is the message's own trace context, or nil if untraced.
+func (system *ActorSystem) tellInternal(ref *ActorRef, message any, fromActor bool, trace *TraceContext) {
+	if system.dropped(ref, message) {
+		return
+	}
 	// Marshal here so that if it's expensive, the caller (usually an actor
 	// pays for it.
 	// We marshal even for local message tells, to prevent
@@ -353,20 +394,25 @@ func (system *ActorSystem) tellInternal(ref *ActorRef, message any, fromActor bo
 		system.reportError(err)
 		return
 	}
-	system.tellMarshalled(ref, mars, fromActor, false)
+	system.tellMarshalled(ref, mars, trace, fromActor, false)
 }
 
 // Calls Tell after duration d (non-blocking).
 func (system *ActorSystem) TellAfter(ref *ActorRef, message any, d time.Duration) {
-	system.tellAfterInternal(ref, message, d, false)
+	system.tellAfterInternal(ref, message, d, false, nil)
 }
 
-// Implements tellAfter and additionally inputs fromActor.
+// Implements tellAfter and additionally inputs fromActor and trace.
 //
 // fromActor is true if the message comes from an actor (including
 // a remote actor), false if it comes from an external TellAfter
 // call. It is used for Stats.
-func (system *ActorSystem) tellAfterInternal(ref *ActorRef, message any, d time.Duration, fromActor bool) {
+//
+// trace is the message's own trace context, or nil if untraced.
+func (system *ActorSystem) tellAfterInternal(ref *ActorRef, message any, d time.Duration, fromActor bool, trace *TraceContext) {
+	if system.dropped(ref, message) {
+		return
+	}
 	// Marshal here so that if it's expensive, the caller pays for it.
 	// We marshal even for local message tells, to prevent
 	// sharing disallowed data (e.g. pointers or channels)
@@ -380,35 +426,37 @@ func (system *ActorSystem) tellAfterInternal(ref *ActorRef, message any, d time.
 	// critical paths.
 	go func() {
 		time.Sleep(d)
-		system.tellMarshalled(ref, mars, fromActor, false)
+		system.tellMarshalled(ref, mars, trace, fromActor, false)
 	}()
 }
 
 // Handler for messages received from remote ActorSystems, via ./remote_tell.go.
 //
-// ref and mars are as in the remote ActorSystem's remoteTell call
+// ref, mars and trace are as in the remote ActorSystem's remoteTell call
 // (in ./remote_tell.go).
-func (system *ActorSystem) tellFromRemote(ref *ActorRef, mars []byte) {
+func (system *ActorSystem) tellFromRemote(ref *ActorRef, mars []byte, trace *TraceContext) {
 	// Stats
-	atomic.AddInt32(&system.remoteBytesReceived, int32(len(mars)))
+	system.remoteBytesReceived.Add(int64(len(mars)))
 
-	system.tellMarshalled(ref, mars, true, true)
+	system.tellMarshalled(ref, mars, trace, true, true)
 }
 
 // Sends a marshalled message to the given ref.
 //
+// trace is the message's trace context, or nil if untraced.
+//
 // fromActor is true if the message comes from an actor (including
 // a remote actor), false if it comes from an external Tell or TellAfter
 // call. It is used for Stats.
-func (system *ActorSystem) tellMarshalled(ref *ActorRef, mars []byte, fromActor bool, fromRemote bool) {
+func (system *ActorSystem) tellMarshalled(ref *ActorRef, mars []byte, trace *TraceContext, fromActor bool, fromRemote bool) {
 	// Stats
 	if !fromRemote {
 		if fromActor {
-			atomic.AddInt32(&system.messagesSentActor, 1)
+			system.messagesSentActor.Add(1)
 		} else {
-			atomic.AddInt32(&system.messagesSentExternal, 1)
+			system.messagesSentExternal.Add(1)
 		}
-		atomic.AddInt32(&system.bytesSent, int32(len(mars)))
+		system.bytesSent.Add(int64(len(mars)))
 	}
 
 	if ref.Address == system.address {
@@ -423,7 +471,8 @@ func (system *ActorSystem) tellMarshalled(ref *ActorRef, mars []byte, fromActor
 		info := infoAny.(*actorRefInfo)
 		if info.mailbox != nil {
 			// Literal actor ref.
-			info.mailbox.Push(mars)
+			info.mailbox.Push(envelope{mars, trace})
+			info.cell.dispatcher.notify(info.cell)
 		} else {
 			// ChannelRef.
 			// respCh is only used once, then info is deleted.
@@ -440,7 +489,7 @@ func (system *ActorSystem) tellMarshalled(ref *ActorRef, mars []byte, fromActor
 				system.reportError(err)
 				return
 			}
-			atomic.AddInt32(&system.channelRefsUsed, 1)
+			system.channelRefsUsed.Add(1)
 			info.respCh <- message
 		}
 	} else {
@@ -457,19 +506,21 @@ func (system *ActorSystem) tellMarshalled(ref *ActorRef, mars []byte, fromActor
 			}
 			mailbox = NewMailbox()
 			system.remotes[ref.Address] = mailbox
-			go system.remoteSendRoutine(ref.Address, mailbox)
+			system.remoteLinks[ref.Address] = &linkMetrics{}
+			go system.remoteSendRoutine(ref.Address, mailbox, system.remoteLinks[ref.Address])
 		}
 		system.remotesMux.Unlock()
 
-		mailbox.Push(remoteMessage{mars, ref})
+		mailbox.Push(remoteMessage{mars, ref, trace})
 	}
 }
 
 // Goroutine that sends messages from a system.remotes mailbox.
-func (system *ActorSystem) remoteSendRoutine(address string, mailbox *Mailbox) {
+func (system *ActorSystem) remoteSendRoutine(address string, mailbox *Mailbox, link *linkMetrics) {
 	// For testing, we subject remoteTell's to test-configured latency.
 	client, err := staff.DialWithLatency(address)
 	if err != nil {
+		link.dialErrors.Add(1)
 		mailbox.Close()
 		system.reportError(err)
 		return
@@ -482,7 +533,9 @@ func (system *ActorSystem) remoteSendRoutine(address string, mailbox *Mailbox) {
 		}
 
 		message := messageAny.(remoteMessage)
-		remoteTell(client, message.ref, message.mars)
+		link.messagesSent.Add(1)
+		link.bytesSent.Add(int64(len(message.mars)))
+		remoteTell(client, message.ref, message.mars, message.trace)
 	}
 }
 
@@ -507,11 +560,11 @@ type Stats struct {
 // For testing use: returns system stats.
 func (system *ActorSystem) Stats() Stats {
 	stats := Stats{
-		MessagesSentExternal: int(atomic.LoadInt32(&system.messagesSentExternal)),
-		MessagesSentActor:    int(atomic.LoadInt32(&system.messagesSentActor)),
-		BytesSent:            int(atomic.LoadInt32(&system.bytesSent)),
-		RemoteBytesReceived:  int(atomic.LoadInt32(&system.remoteBytesReceived)),
-		ChannelRefsUsed:      int(atomic.LoadInt32(&system.channelRefsUsed)),
+		MessagesSentExternal: int(system.messagesSentExternal.Load()),
+		MessagesSentActor:    int(system.messagesSentActor.Load()),
+		BytesSent:            int(system.bytesSent.Load()),
+		RemoteBytesReceived:  int(system.remoteBytesReceived.Load()),
+		ChannelRefsUsed:      int(system.channelRefsUsed.Load()),
 	}
 	stats.MessagesSent = stats.MessagesSentExternal + stats.MessagesSentActor
 
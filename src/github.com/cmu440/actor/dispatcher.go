@@ -0,0 +1,192 @@
+package actor
+
+import (
+	"runtime"
+	"sync/atomic"
+	"time"
+)
+
+// A Dispatcher decides which goroutines run an actor's OnMessage calls.
+// Choose one per actor with ActorSystem.StartActorWith:
+//
+//   - NewGoroutineDispatcher (the default for StartActor): each actor gets
+//     its own goroutine, blocked on its mailbox when idle.
+//   - ActorSystem.NewPoolDispatcher: a fixed pool of worker goroutines
+//     shared by many actors, scheduling only actors with non-empty
+//     mailboxes. Cheap for thousands of mostly-idle actors, and fair:
+//     a worker handles at most a bounded batch of one actor's messages
+//     before moving on to the next.
+//   - NewPinnedDispatcher: like the goroutine dispatcher, but the actor's
+//     goroutine is locked to its own OS thread, e.g. for actors that
+//     call into thread-affine C libraries.
+//
+// Whatever the dispatcher, an actor's messages are handled one at a time
+// and in mailbox order.
+//
+// The set of dispatchers is closed: the interface's methods are unexported,
+// as they work on the package's internal actor cells, so use one of the
+// constructors above rather than implementing it.
+type Dispatcher interface {
+	// Starts running cell's actor. Called once, from StartActorWith.
+	start(cell *actorCell)
+	// Called after each message is pushed onto cell's mailbox.
+	notify(cell *actorCell)
+}
+
+// Everything needed to run one local actor.
+type actorCell struct {
+	system     *ActorSystem
+	actor      Actor
+	context    *ActorContext
+	mailbox    *Mailbox
+	metrics    *actorMetrics
+	dispatcher Dispatcher
+	// For poolDispatcher: whether cell is in the run queue or being run
+	// by a worker, so at most one worker runs it at a time.
+	scheduled atomic.Bool
+}
+
+// Handles one envelope from cell's mailbox.
+func (cell *actorCell) handle(envAny any) {
+	env := envAny.(envelope)
+	cell.metrics.messagesIn.Add(1)
+	message, err := unmarshal(env.mars)
+	if err != nil {
+		cell.metrics.errors.Add(1)
+		cell.system.reportError(err)
+		return
+	}
+	// Tells issued by OnMessage become children of env's span.
+	cell.context.trace = env.trace
+	start := time.Now()
+	err = cell.actor.OnMessage(message)
+	cell.context.trace = nil
+	cell.metrics.observeProcessing(time.Since(start))
+	cell.system.exportSpan(cell.context.Self, env.trace, message, start, err)
+	if err != nil {
+		cell.metrics.errors.Add(1)
+		cell.system.reportError(err)
+	}
+}
+
+// Handles messages until the mailbox is closed.
+func (cell *actorCell) run() {
+	for {
+		env, ok := cell.mailbox.Pop()
+		if !ok {
+			return
+		}
+		cell.handle(env)
+	}
+}
+
+// === Goroutine-per-actor
+
+type goroutineDispatcher struct{}
+
+var goroutineDispatcherInstance = &goroutineDispatcher{}
+
+// Returns the dispatcher that runs each actor on its own goroutine.
+// This is what StartActor uses.
+func NewGoroutineDispatcher() Dispatcher {
+	return goroutineDispatcherInstance
+}
+
+func (dispatcher *goroutineDispatcher) start(cell *actorCell) {
+	go cell.run()
+}
+
+func (dispatcher *goroutineDispatcher) notify(cell *actorCell) {
+	// cell.run() is already blocked on Pop.
+}
+
+// === Pinned
+
+type pinnedDispatcher struct{}
+
+// Returns a dispatcher that runs each actor on its own goroutine, locked to
+// its own OS thread for the actor's lifetime.
+func NewPinnedDispatcher() Dispatcher {
+	return &pinnedDispatcher{}
+}
+
+func (dispatcher *pinnedDispatcher) start(cell *actorCell) {
+	go func() {
+		runtime.LockOSThread()
+		defer runtime.UnlockOSThread()
+		cell.run()
+	}()
+}
+
+func (dispatcher *pinnedDispatcher) notify(cell *actorCell) {
+	// cell.run() is already blocked on Pop.
+}
+
+// === Worker pool
+
+type poolDispatcher struct {
+	// Cells with pending messages, waiting for a worker.
+	// Message type: *actorCell
+	runQueue *Mailbox
+	// Max messages a worker handles for one cell before rescheduling it.
+	throughput int
+}
+
+// Returns a dispatcher that runs its actors on a pool of worker goroutines
+// (workers of them). Each time a worker picks up an actor, it handles at
+// most throughput messages before putting the actor back at the end of the
+// run queue. workers and throughput are clamped to at least 1.
+//
+// The pool belongs to system: use it only for system's actors. Its workers
+// stop when system is closed.
+func (system *ActorSystem) NewPoolDispatcher(workers int, throughput int) Dispatcher {
+	dispatcher := &poolDispatcher{
+		runQueue:   NewMailbox(),
+		throughput: max(throughput, 1),
+	}
+
+	system.newActorMux.Lock()
+	if system.closed {
+		dispatcher.runQueue.Close()
+	}
+	system.pools = append(system.pools, dispatcher)
+	system.newActorMux.Unlock()
+
+	for i := 0; i < max(workers, 1); i++ {
+		go dispatcher.work()
+	}
+	return dispatcher
+}
+
+func (dispatcher *poolDispatcher) start(cell *actorCell) {
+	// Nothing to do until the first message arrives.
+}
+
+func (dispatcher *poolDispatcher) notify(cell *actorCell) {
+	if cell.scheduled.CompareAndSwap(false, true) {
+		dispatcher.runQueue.Push(cell)
+	}
+}
+
+func (dispatcher *poolDispatcher) work() {
+	for {
+		cellAny, ok := dispatcher.runQueue.Pop()
+		if !ok {
+			return
+		}
+		cell := cellAny.(*actorCell)
+		for i := 0; i < dispatcher.throughput; i++ {
+			env, ok := cell.mailbox.TryPop()
+			if !ok {
+				break
+			}
+			cell.handle(env)
+		}
+		cell.scheduled.Store(false)
+		// A message may have arrived after our last TryPop but before
+		// scheduled was cleared, in which case its notify was a no-op.
+		if cell.mailbox.Len() > 0 {
+			dispatcher.notify(cell)
+		}
+	}
+}
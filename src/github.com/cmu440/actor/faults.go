@@ -0,0 +1,20 @@
+package actor
+
+// For testing use: makes this system silently drop every message told by it
+// (from actors or Tell/TellAfter calls) for which filter(ref, message)
+// returns true, as if it were lost on the network. Pass nil to stop.
+//
+// filter runs on the sender's goroutine, so it must be thread-safe and fast.
+func (system *ActorSystem) SetDropFilter(filter func(ref *ActorRef, message any) bool) {
+	if filter == nil {
+		system.dropFilter.Store(nil)
+		return
+	}
+	system.dropFilter.Store(&filter)
+}
+
+// Returns whether the drop filter drops message to ref.
+func (system *ActorSystem) dropped(ref *ActorRef, message any) bool {
+	filter := system.dropFilter.Load()
+	return filter != nil && (*filter)(ref, message)
+}
@@ -0,0 +1,199 @@
+// Package fsm helps write actors as finite state machines: named states,
+// a message handler per state, optional per-state timeouts, transition
+// callbacks, and state data carried between states.
+//
+// Build the machine in your actor "constructor":
+//
+//	func newDoorActor(context *actor.ActorContext) actor.Actor {
+//		return fsm.New(context, "closed", doorData{}).
+//			When("closed", func(m *fsm.FSM[doorData], message any) error {
+//				if _, ok := message.(Open); ok {
+//					m.Goto("open")
+//				}
+//				return nil
+//			}).
+//			WhenWithTimeout("open", 5*time.Second, func(m *fsm.FSM[doorData], message any) error {
+//				if _, ok := message.(fsm.StateTimeout); ok {
+//					m.Goto("closed")
+//				}
+//				return nil
+//			}).
+//			Build()
+//	}
+//
+// Like any actor, handlers may only touch the machine's state and data and
+// send messages with m.Context().
+package fsm
+
+import (
+	"encoding/gob"
+	"fmt"
+	"time"
+
+	"github.com/cmu440/actor"
+)
+
+func init() {
+	gob.Register(StateTimeout{})
+}
+
+// The name of a state.
+type State string
+
+// Message delivered to a state's handler when the machine has stayed in
+// that state for its timeout (see Builder.WhenWithTimeout).
+//
+// Timeouts are scheduled with ActorContext.TellAfter when the state is
+// entered, so one may already be in the mailbox after the machine leaves the
+// state; such stale timeouts are dropped and never reach a handler.
+type StateTimeout struct {
+	State State
+	// Counts transitions, to recognize stale timeouts.
+	Generation int
+}
+
+// Handles one message in a state. Call m.Goto to transition once the handler
+// returns; otherwise the machine stays in the current state.
+//
+// Returned errors are passed on to the ActorSystem as from OnMessage, and
+// cancel any requested transition.
+type Handler[D any] func(m *FSM[D], message any) error
+
+type stateSpec[D any] struct {
+	handler Handler[D]
+	// Zero if none.
+	timeout time.Duration
+}
+
+// Builds an FSM. Each method returns the builder, for chaining.
+type Builder[D any] struct {
+	fsm *FSM[D]
+}
+
+// A state machine actor, implementing actor.Actor.
+type FSM[D any] struct {
+	context      *actor.ActorContext
+	states       map[State]stateSpec[D]
+	unhandled    Handler[D]
+	transitions  []func(m *FSM[D], from State, to State)
+	state        State
+	data         D
+	generation   int
+	next         State
+	transitioned bool
+}
+
+// Starts building a machine for the actor with the given context, in state
+// initial with data.
+func New[D any](context *actor.ActorContext, initial State, data D) *Builder[D] {
+	return &Builder[D]{&FSM[D]{
+		context: context,
+		states:  make(map[State]stateSpec[D]),
+		state:   initial,
+		data:    data,
+	}}
+}
+
+// Sets the handler for messages received in state.
+func (b *Builder[D]) When(state State, handler Handler[D]) *Builder[D] {
+	return b.WhenWithTimeout(state, 0, handler)
+}
+
+// Like When, but additionally sends the handler a StateTimeout if the machine
+// is still in state timeout after entering it. Re-entering the state with
+// Goto restarts the timeout.
+func (b *Builder[D]) WhenWithTimeout(state State, timeout time.Duration, handler Handler[D]) *Builder[D] {
+	b.fsm.states[state] = stateSpec[D]{handler, timeout}
+	return b
+}
+
+// Sets the handler for states with no handler of their own. Without one,
+// messages in such states are errors.
+func (b *Builder[D]) WhenUnhandled(handler Handler[D]) *Builder[D] {
+	b.fsm.unhandled = handler
+	return b
+}
+
+// Adds a callback run on every transition (including Goto the current
+// state), after the handler returns and before the next message.
+// m.State() is already to.
+func (b *Builder[D]) OnTransition(callback func(m *FSM[D], from State, to State)) *Builder[D] {
+	b.fsm.transitions = append(b.fsm.transitions, callback)
+	return b
+}
+
+// Returns the machine, ready to be returned from an actor constructor.
+// Starts the initial state's timeout, if any.
+func (b *Builder[D]) Build() actor.Actor {
+	b.fsm.armTimeout()
+	return b.fsm
+}
+
+// Returns the actor's context, for sending messages from handlers.
+func (m *FSM[D]) Context() *actor.ActorContext {
+	return m.context
+}
+
+// Returns the current state.
+func (m *FSM[D]) State() State {
+	return m.state
+}
+
+// Returns the state data.
+func (m *FSM[D]) Data() D {
+	return m.data
+}
+
+// Replaces the state data.
+func (m *FSM[D]) SetData(data D) {
+	m.data = data
+}
+
+// Requests a transition to state once the current handler returns.
+func (m *FSM[D]) Goto(state State) {
+	m.next = state
+	m.transitioned = true
+}
+
+// Cancels a transition requested earlier in the current handler.
+func (m *FSM[D]) Stay() {
+	m.transitioned = false
+}
+
+// OnMessage implements actor.Actor.OnMessage.
+func (m *FSM[D]) OnMessage(message any) error {
+	if timeout, ok := message.(StateTimeout); ok && (timeout.Generation != m.generation || timeout.State != m.state) {
+		// Stale.
+		return nil
+	}
+
+	spec, ok := m.states[m.state]
+	handler := spec.handler
+	if !ok {
+		handler = m.unhandled
+	}
+	if handler == nil {
+		return fmt.Errorf("fsm: no handler for %T in state %q", message, m.state)
+	}
+
+	m.transitioned = false
+	err := handler(m, message)
+	if err != nil || !m.transitioned {
+		return err
+	}
+
+	from := m.state
+	m.state = m.next
+	m.generation++
+	for _, callback := range m.transitions {
+		callback(m, from, m.state)
+	}
+	m.armTimeout()
+	return nil
+}
+
+func (m *FSM[D]) armTimeout() {
+	if spec, ok := m.states[m.state]; ok && spec.timeout > 0 {
+		m.context.TellAfter(m.context.Self, StateTimeout{m.state, m.generation}, spec.timeout)
+	}
+}
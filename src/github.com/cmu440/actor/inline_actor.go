@@ -0,0 +1,75 @@
+package actor
+
+// For testing use: a local actor whose messages are handled synchronously,
+// on the caller's goroutine, instead of by a Dispatcher.
+//
+// Messages sent to Ref() wait in the actor's mailbox until ProcessPending is
+// called; Receive handles a message immediately without going through the
+// mailbox. See also the actor/testkit package, which wraps this type.
+type InlineActor struct {
+	cell *actorCell
+}
+
+type inlineDispatcher struct{}
+
+func (dispatcher *inlineDispatcher) start(cell *actorCell) {
+	// Run only by InlineActor's methods.
+}
+
+func (dispatcher *inlineDispatcher) notify(cell *actorCell) {
+	// Run only by InlineActor's methods.
+}
+
+// For testing use: starts a new local actor that only handles messages when
+// told to; see InlineActor.
+func (system *ActorSystem) StartInlineActor(newActor func(context *ActorContext) Actor) *InlineActor {
+	ref := system.StartActorWith(newActor, &inlineDispatcher{})
+	infoAny, ok := system.infos.Load(ref.Counter)
+	if !ok {
+		// Closed system: return an actor with nothing to run.
+		context := newActorContext(system, ref, newActorMetrics())
+		return &InlineActor{&actorCell{system: system, actor: newActor(context), context: context, mailbox: NewMailbox()}}
+	}
+	return &InlineActor{infoAny.(*actorRefInfo).cell}
+}
+
+// Returns the actor's ref.
+func (inline *InlineActor) Ref() *ActorRef {
+	return inline.cell.context.Self
+}
+
+// Returns the actor instance, e.g. to inspect its state between messages.
+func (inline *InlineActor) Actor() Actor {
+	return inline.cell.actor
+}
+
+// Handles message right away, returning OnMessage's error.
+//
+// message is marshalled and unmarshalled first, as for Tell, so that
+// unregistered or non-marshallable messages fail here too.
+func (inline *InlineActor) Receive(message any) error {
+	mars, err := marshal(message)
+	if err != nil {
+		return err
+	}
+	message, err = unmarshal(mars)
+	if err != nil {
+		return err
+	}
+	return inline.cell.actor.OnMessage(message)
+}
+
+// Handles every message currently waiting in the actor's mailbox, in order,
+// and returns how many there were. Errors are reported to the system's
+// error handler (see ActorSystem.OnError), as for other actors.
+func (inline *InlineActor) ProcessPending() int {
+	count := 0
+	for {
+		env, ok := inline.cell.mailbox.TryPop()
+		if !ok {
+			return count
+		}
+		inline.cell.handle(env)
+		count++
+	}
+}
@@ -66,6 +66,31 @@ func (mailbox *Mailbox) Pop() (message any, ok bool) {
 	return nil, false
 }
 
+// TryPop
+// Like Pop, but returns (nil, false) immediately instead of blocking if
+// the mailbox is empty.
+func (mailbox *Mailbox) TryPop() (message any, ok bool) {
+	mailbox.mu.Lock()
+	defer mailbox.mu.Unlock()
+
+	if len(mailbox.message) > 0 && !mailbox.closed {
+		message = mailbox.message[0]
+		mailbox.message = mailbox.message[1:]
+		return message, true
+	}
+	return nil, false
+}
+
+// Len
+// Returns the number of messages currently queued in the mailbox.
+//
+// This is only a snapshot, for metrics; it may be stale as soon as it returns.
+func (mailbox *Mailbox) Len() int {
+	mailbox.mu.Lock()
+	defer mailbox.mu.Unlock()
+	return len(mailbox.message)
+}
+
 // Close
 // Closes the mailbox, causing future Pop() calls to return (nil, false)
 // and terminating any goroutines running in the background.
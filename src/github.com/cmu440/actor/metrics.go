@@ -0,0 +1,260 @@
+package actor
+
+import (
+	"fmt"
+	"io"
+	"net/http"
+	"sort"
+	"strconv"
+	"sync/atomic"
+	"time"
+)
+
+// Upper bounds of the processing time histogram buckets, in seconds.
+// An implicit +Inf bucket follows the last one.
+var processingBuckets = []float64{
+	0.00001, 0.00005, 0.0001, 0.0005, 0.001, 0.005, 0.01, 0.05, 0.1, 0.5, 1,
+}
+
+// Live per-actor counters, updated atomically by the actor's goroutine
+// (and by Tells from its context).
+type actorMetrics struct {
+	messagesIn  atomic.Int64
+	messagesOut atomic.Int64
+	errors      atomic.Int64
+	// Non-cumulative counts per processingBuckets entry, plus one for +Inf.
+	buckets         []atomic.Int64
+	processingNanos atomic.Int64
+}
+
+func newActorMetrics() *actorMetrics {
+	return &actorMetrics{buckets: make([]atomic.Int64, len(processingBuckets)+1)}
+}
+
+func (metrics *actorMetrics) observeProcessing(d time.Duration) {
+	metrics.processingNanos.Add(int64(d))
+	secs := d.Seconds()
+	i := sort.SearchFloat64s(processingBuckets, secs)
+	metrics.buckets[i].Add(1)
+}
+
+// Live counters for the link to one remote ActorSystem.
+type linkMetrics struct {
+	messagesSent atomic.Int64
+	bytesSent    atomic.Int64
+	dialErrors   atomic.Int64
+}
+
+// Snapshot of one local actor's metrics.
+type ActorMetrics struct {
+	// Uid of the actor's ActorRef.
+	Actor string
+	// Messages waiting in the actor's mailbox.
+	MailboxDepth int
+	// Messages taken from the mailbox (including ones that failed to
+	// unmarshal).
+	MessagesIn int64
+	// Messages sent with the actor's context (Tell and TellAfter).
+	MessagesOut int64
+	// Unmarshal errors plus errors returned by OnMessage.
+	Errors int64
+	// Cumulative counts of OnMessage calls that took at most
+	// ProcessingBuckets[i] seconds; the last entry counts all calls.
+	ProcessingCounts []int64
+	// Total time spent in OnMessage.
+	ProcessingTime time.Duration
+}
+
+// Snapshot of the link to one remote ActorSystem.
+type LinkMetrics struct {
+	Address string
+	// Messages waiting to be sent.
+	QueueDepth   int
+	MessagesSent int64
+	BytesSent    int64
+	DialErrors   int64
+}
+
+// Snapshot of an ActorSystem's metrics; see ActorSystem.Metrics.
+type Metrics struct {
+	MessagesSentExternal int64
+	MessagesSentActor    int64
+	BytesSent            int64
+	RemoteBytesReceived  int64
+	ChannelRefsUsed      int64
+	// Upper bounds (in seconds) for ActorMetrics.ProcessingCounts, not
+	// including the final +Inf bucket.
+	ProcessingBuckets []float64
+	// Sorted by ActorRef counter.
+	Actors []ActorMetrics
+	// Sorted by address.
+	Links []LinkMetrics
+}
+
+// Returns a snapshot of this system's metrics.
+//
+// Unlike Stats, this is meant for monitoring in production too: it takes
+// no locks on actors' critical paths.
+func (system *ActorSystem) Metrics() Metrics {
+	m := Metrics{
+		MessagesSentExternal: system.messagesSentExternal.Load(),
+		MessagesSentActor:    system.messagesSentActor.Load(),
+		BytesSent:            system.bytesSent.Load(),
+		RemoteBytesReceived:  system.remoteBytesReceived.Load(),
+		ChannelRefsUsed:      system.channelRefsUsed.Load(),
+		ProcessingBuckets:    processingBuckets,
+	}
+
+	counters := make(map[string]int)
+	system.infos.Range(func(key, value any) bool {
+		info := value.(*actorRefInfo)
+		if info.metrics == nil {
+			return true
+		}
+		counts := make([]int64, len(info.metrics.buckets))
+		var total int64
+		for i := range info.metrics.buckets {
+			total += info.metrics.buckets[i].Load()
+			counts[i] = total
+		}
+		actor := ActorMetrics{
+			Actor:            info.context.Self.Uid(),
+			MailboxDepth:     info.mailbox.Len(),
+			MessagesIn:       info.metrics.messagesIn.Load(),
+			MessagesOut:      info.metrics.messagesOut.Load(),
+			Errors:           info.metrics.errors.Load(),
+			ProcessingCounts: counts,
+			ProcessingTime:   time.Duration(info.metrics.processingNanos.Load()),
+		}
+		counters[actor.Actor] = key.(int)
+		m.Actors = append(m.Actors, actor)
+		return true
+	})
+	sort.Slice(m.Actors, func(i, j int) bool {
+		return counters[m.Actors[i].Actor] < counters[m.Actors[j].Actor]
+	})
+
+	system.remotesMux.Lock()
+	for address, link := range system.remoteLinks {
+		m.Links = append(m.Links, LinkMetrics{
+			Address:      address,
+			QueueDepth:   system.remotes[address].Len(),
+			MessagesSent: link.messagesSent.Load(),
+			BytesSent:    link.bytesSent.Load(),
+			DialErrors:   link.dialErrors.Load(),
+		})
+	}
+	system.remotesMux.Unlock()
+	sort.Slice(m.Links, func(i, j int) bool {
+		return m.Links[i].Address < m.Links[j].Address
+	})
+
+	return m
+}
+
+// Returns an http.Handler that serves this system's metrics in the
+// Prometheus text exposition format (version 0.0.4), e.g. for
+//
+//	http.Handle("/metrics", system.MetricsHandler())
+func (system *ActorSystem) MetricsHandler() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/plain; version=0.0.4")
+		system.Metrics().WritePrometheus(w)
+	})
+}
+
+// Writes m to w in the Prometheus text exposition format.
+func (m Metrics) WritePrometheus(w io.Writer) error {
+	p := &promWriter{w: w}
+
+	p.header("actor_system_messages_sent_total", "counter", "Messages sent, by source (actor or external Tell).")
+	p.sample("actor_system_messages_sent_total", `source="actor"`, m.MessagesSentActor)
+	p.sample("actor_system_messages_sent_total", `source="external"`, m.MessagesSentExternal)
+	p.header("actor_system_bytes_sent_total", "counter", "Marshalled bytes sent in messages.")
+	p.sample("actor_system_bytes_sent_total", "", m.BytesSent)
+	p.header("actor_system_remote_bytes_received_total", "counter", "Marshalled bytes received in remote messages.")
+	p.sample("actor_system_remote_bytes_received_total", "", m.RemoteBytesReceived)
+	p.header("actor_system_channel_refs_used_total", "counter", "ChannelRefs that received a message.")
+	p.sample("actor_system_channel_refs_used_total", "", m.ChannelRefsUsed)
+
+	p.header("actor_mailbox_depth", "gauge", "Messages waiting in the actor's mailbox.")
+	for _, a := range m.Actors {
+		p.sample("actor_mailbox_depth", actorLabel(a), int64(a.MailboxDepth))
+	}
+	p.header("actor_messages_in_total", "counter", "Messages taken from the actor's mailbox.")
+	for _, a := range m.Actors {
+		p.sample("actor_messages_in_total", actorLabel(a), a.MessagesIn)
+	}
+	p.header("actor_messages_out_total", "counter", "Messages sent by the actor.")
+	for _, a := range m.Actors {
+		p.sample("actor_messages_out_total", actorLabel(a), a.MessagesOut)
+	}
+	p.header("actor_errors_total", "counter", "Unmarshal and OnMessage errors.")
+	for _, a := range m.Actors {
+		p.sample("actor_errors_total", actorLabel(a), a.Errors)
+	}
+	p.header("actor_processing_seconds", "histogram", "Time spent in OnMessage.")
+	for _, a := range m.Actors {
+		for i, count := range a.ProcessingCounts {
+			le := "+Inf"
+			if i < len(m.ProcessingBuckets) {
+				le = strconv.FormatFloat(m.ProcessingBuckets[i], 'g', -1, 64)
+			}
+			p.sample("actor_processing_seconds_bucket", fmt.Sprintf(`%s,le="%s"`, actorLabel(a), le), count)
+		}
+		p.line("actor_processing_seconds_sum{%s} %s", actorLabel(a), strconv.FormatFloat(a.ProcessingTime.Seconds(), 'g', -1, 64))
+		p.sample("actor_processing_seconds_count", actorLabel(a), a.ProcessingCounts[len(a.ProcessingCounts)-1])
+	}
+
+	p.header("actor_remote_queue_depth", "gauge", "Messages waiting to be sent to the remote system.")
+	for _, l := range m.Links {
+		p.sample("actor_remote_queue_depth", linkLabel(l), int64(l.QueueDepth))
+	}
+	p.header("actor_remote_messages_sent_total", "counter", "Messages sent to the remote system.")
+	for _, l := range m.Links {
+		p.sample("actor_remote_messages_sent_total", linkLabel(l), l.MessagesSent)
+	}
+	p.header("actor_remote_bytes_sent_total", "counter", "Marshalled bytes sent to the remote system.")
+	for _, l := range m.Links {
+		p.sample("actor_remote_bytes_sent_total", linkLabel(l), l.BytesSent)
+	}
+	p.header("actor_remote_dial_errors_total", "counter", "Failed connections to the remote system.")
+	for _, l := range m.Links {
+		p.sample("actor_remote_dial_errors_total", linkLabel(l), l.DialErrors)
+	}
+
+	return p.err
+}
+
+func actorLabel(a ActorMetrics) string {
+	return fmt.Sprintf("actor=%q", a.Actor)
+}
+
+func linkLabel(l LinkMetrics) string {
+	return fmt.Sprintf("address=%q", l.Address)
+}
+
+// Writes Prometheus text lines, remembering the first error.
+type promWriter struct {
+	w   io.Writer
+	err error
+}
+
+func (p *promWriter) line(format string, a ...any) {
+	if p.err == nil {
+		_, p.err = fmt.Fprintf(p.w, format+"\n", a...)
+	}
+}
+
+func (p *promWriter) header(name string, kind string, help string) {
+	p.line("# HELP %s %s", name, help)
+	p.line("# TYPE %s %s", name, kind)
+}
+
+func (p *promWriter) sample(name string, labels string, value int64) {
+	if labels == "" {
+		p.line("%s %d", name, value)
+	} else {
+		p.line("%s{%s} %d", name, labels, value)
+	}
+}
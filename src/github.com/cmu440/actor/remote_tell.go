@@ -7,6 +7,8 @@ import (
 type RemoteTellArgs struct {
 	Ref  *ActorRef
 	Mars []byte
+	// Nil if the message is untraced.
+	Trace *TraceContext
 }
 
 // remoteTellReply represents the reply for the remoteTell RPC.
@@ -17,7 +19,7 @@ type RemoteTellReply struct {
 //var mailBox *Mailbox
 //var mux sync.Mutex
 
-// Calls system.tellFromRemote(ref, mars) on the remote ActorSystem listening
+// Calls system.tellFromRemote(ref, mars, trace) on the remote ActorSystem listening
 // on ref.Address.
 //
 // This function should NOT wait for a reply from the remote system before
@@ -25,10 +27,10 @@ type RemoteTellReply struct {
 // It should ensure that messages are delivered in-order to the remote system.
 // (You may assume that remoteTell is not called multiple times
 // concurrently with the same ref.Address).
-func remoteTell(client *rpc.Client, ref *ActorRef, mars []byte) {
+func remoteTell(client *rpc.Client, ref *ActorRef, mars []byte, trace *TraceContext) {
 	// TODO (3B): implement this!
 
-	args := &RemoteTellArgs{Ref: ref, Mars: mars}
+	args := &RemoteTellArgs{Ref: ref, Mars: mars, Trace: trace}
 	//mailBox.Push(args)
 
 	//go func() {
@@ -51,7 +53,7 @@ func remoteTell(client *rpc.Client, ref *ActorRef, mars []byte) {
 //
 // You do not need to start the server's listening on the network;
 // just register a handler struct that handles remoteTell RPCs by calling
-// system.tellFromRemote(ref, mars).
+// system.tellFromRemote(ref, mars, trace).
 func registerRemoteTells(system *ActorSystem, server *rpc.Server) error {
 	// TODO (3B): implement this!
 	//mailBox = NewMailbox()
@@ -75,8 +77,8 @@ type RemoteTellHandler struct {
 
 // RemoteTell handles the remoteTell RPC.
 func (h *RemoteTellHandler) RemoteTell(args *RemoteTellArgs, reply *RemoteTellReply) error {
-	// Call system.tellFromRemote(ref, mars) using the provided arguments.
-	h.ActorSys.tellFromRemote(args.Ref, args.Mars)
+	// Call system.tellFromRemote(ref, mars, trace) using the provided arguments.
+	h.ActorSys.tellFromRemote(args.Ref, args.Mars, args.Trace)
 
 	return nil
 }
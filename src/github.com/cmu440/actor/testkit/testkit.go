@@ -0,0 +1,217 @@
+// Package testkit provides helpers for testing actors: probes that record
+// the messages sent to them, synchronous actor refs for unit tests, and
+// shortcuts for starting ActorSystems.
+//
+// Example:
+//
+//	systems := testkit.NewSystems(t, 2)
+//	probe := testkit.NewTestProbe(t, systems[1])
+//	ref := systems[0].StartActor(newCounterActor)
+//	systems[0].Tell(ref, MGet{Sender: probe.Ref()})
+//	probe.ExpectMsg(MResult{0})
+package testkit
+
+import (
+	"fmt"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/cmu440/actor"
+)
+
+// Default timeout for TestProbe's Expect* methods.
+const DefaultTimeout = time.Duration(3) * time.Second
+
+// Max unread messages a TestProbe buffers. Beyond that, the probe's actor
+// blocks until the test reads some.
+const probeBufferSize = 1024
+
+// Starts an ActorSystem on an ephemeral port, closed automatically when the
+// test ends. Errors reported by the system fail the test.
+func NewSystem(t testing.TB) *actor.ActorSystem {
+	t.Helper()
+	system, err := actor.NewActorSystem(0)
+	if err != nil {
+		t.Fatalf("NewActorSystem: %s", err)
+	}
+	system.OnError(func(err error) {
+		t.Errorf("ActorSystem at %s reported error: %s", system.Address(), err)
+	})
+	t.Cleanup(func() {
+		// Ignore Close-related errors.
+		system.OnError(nil)
+		system.Close()
+	})
+	return system
+}
+
+// Starts count ActorSystems as in NewSystem.
+func NewSystems(t testing.TB, count int) []*actor.ActorSystem {
+	t.Helper()
+	systems := make([]*actor.ActorSystem, count)
+	for i := range systems {
+		systems[i] = NewSystem(t)
+	}
+	return systems
+}
+
+// An actor ref that records the messages sent to it, for the test to check
+// with the Expect* methods.
+//
+// A probe's methods must be called from the test's goroutine.
+type TestProbe struct {
+	t   testing.TB
+	ref *actor.ActorRef
+	ch  chan any
+	// Timeout used by ExpectMsg, ExpectMsgType and FishForMessage.
+	Timeout time.Duration
+}
+
+// Actor behind a TestProbe's ref.
+//
+// It holds a Go channel shared with the test, which ordinary actors must
+// never do; that is the point of a probe.
+type probeActor struct {
+	ch chan<- any
+}
+
+func (actor *probeActor) OnMessage(message any) error {
+	actor.ch <- message
+	return nil
+}
+
+// Starts a new probe actor in system.
+func NewTestProbe(t testing.TB, system *actor.ActorSystem) *TestProbe {
+	ch := make(chan any, probeBufferSize)
+	ref := system.StartActor(func(context *actor.ActorContext) actor.Actor {
+		return &probeActor{ch}
+	})
+	return &TestProbe{t: t, ref: ref, ch: ch, Timeout: DefaultTimeout}
+}
+
+// Returns the probe's ref, to include in messages as a sender or target.
+func (probe *TestProbe) Ref() *actor.ActorRef {
+	return probe.ref
+}
+
+// Returns the next message, or (nil, false) if none arrives within d.
+func (probe *TestProbe) Receive(d time.Duration) (message any, ok bool) {
+	select {
+	case message := <-probe.ch:
+		return message, true
+	case <-time.After(d):
+		return nil, false
+	}
+}
+
+// Returns the next message, failing the test if none arrives within
+// probe.Timeout.
+func (probe *TestProbe) ReceiveOne() any {
+	probe.t.Helper()
+	message, ok := probe.Receive(probe.Timeout)
+	if !ok {
+		probe.t.Fatalf("Probe %s received no message within %s", probe.ref.Uid(), probe.Timeout)
+	}
+	return message
+}
+
+// Checks that the next message (within probe.Timeout) equals expected,
+// according to reflect.DeepEqual, and returns it.
+func (probe *TestProbe) ExpectMsg(expected any) any {
+	probe.t.Helper()
+	return probe.ExpectMsgWithin(probe.Timeout, expected)
+}
+
+// Like ExpectMsg, but with timeout d.
+func (probe *TestProbe) ExpectMsgWithin(d time.Duration, expected any) any {
+	probe.t.Helper()
+	message, ok := probe.Receive(d)
+	if !ok {
+		probe.t.Fatalf("Probe %s expected %#v, but received no message within %s", probe.ref.Uid(), expected, d)
+	}
+	if !reflect.DeepEqual(message, expected) {
+		probe.t.Fatalf("Probe %s expected %#v, but received %#v", probe.ref.Uid(), expected, message)
+	}
+	return message
+}
+
+// Checks that no message arrives within d.
+func (probe *TestProbe) ExpectNoMsg(d time.Duration) {
+	probe.t.Helper()
+	if message, ok := probe.Receive(d); ok {
+		probe.t.Fatalf("Probe %s expected no message, but received %#v", probe.ref.Uid(), message)
+	}
+}
+
+// Checks that the next message (within probe.Timeout) has the same type as
+// sample, and returns it. For example:
+//
+//	result := probe.ExpectMsgType(MResult{}).(MResult)
+func (probe *TestProbe) ExpectMsgType(sample any) any {
+	probe.t.Helper()
+	message := probe.ReceiveOne()
+	if reflect.TypeOf(message) != reflect.TypeOf(sample) {
+		probe.t.Fatalf("Probe %s expected a %T, but received %#v", probe.ref.Uid(), sample, message)
+	}
+	return message
+}
+
+// Receives messages until one satisfies matches, discarding the rest, and
+// returns it. Fails the test if no match arrives within probe.Timeout in
+// total.
+func (probe *TestProbe) FishForMessage(matches func(message any) bool) any {
+	probe.t.Helper()
+	deadline := time.Now().Add(probe.Timeout)
+	var skipped []string
+	for {
+		message, ok := probe.Receive(time.Until(deadline))
+		if !ok {
+			probe.t.Fatalf("Probe %s received no matching message within %s (skipped %v)", probe.ref.Uid(), probe.Timeout, skipped)
+		}
+		if matches(message) {
+			return message
+		}
+		skipped = append(skipped, fmt.Sprintf("%#v", message))
+	}
+}
+
+// A ref to an actor whose messages are handled synchronously, for unit
+// testing an actor's OnMessage logic without goroutines or sleeps.
+type TestActorRef struct {
+	t      testing.TB
+	inline *actor.InlineActor
+}
+
+// Starts the actor made by newActor in system, without a dispatcher.
+func NewTestActorRef(t testing.TB, system *actor.ActorSystem, newActor func(context *actor.ActorContext) actor.Actor) *TestActorRef {
+	return &TestActorRef{t, system.StartInlineActor(newActor)}
+}
+
+// Returns the actor's ref. Messages sent to it are handled by ProcessPending.
+func (ref *TestActorRef) Ref() *actor.ActorRef {
+	return ref.inline.Ref()
+}
+
+// Returns the actor instance, e.g. to type-assert and inspect its state.
+func (ref *TestActorRef) Actor() actor.Actor {
+	return ref.inline.Actor()
+}
+
+// Runs OnMessage(message) inline, failing the test if it returns an error.
+func (ref *TestActorRef) Receive(message any) {
+	ref.t.Helper()
+	if err := ref.inline.Receive(message); err != nil {
+		ref.t.Fatalf("Actor %s failed on %#v: %s", ref.Ref().Uid(), message, err)
+	}
+}
+
+// Runs OnMessage(message) inline and returns its error.
+func (ref *TestActorRef) ReceiveErr(message any) error {
+	return ref.inline.Receive(message)
+}
+
+// Handles every message already sent to Ref(), returning how many.
+func (ref *TestActorRef) ProcessPending() int {
+	return ref.inline.ProcessPending()
+}
@@ -0,0 +1,169 @@
+package actor
+
+import (
+	"encoding/json"
+	"fmt"
+	"math/rand"
+	"os"
+	"sync"
+	"time"
+)
+
+// Trace context attached to a message, used to follow a request as it fans
+// out through the actor system (e.g., a client Put turning into an MPut,
+// then into replies and further Tells).
+//
+// Each traced message is its own span: SpanID identifies the message,
+// ParentID identifies the message whose handler sent it ("" for a root),
+// and TraceID is shared by every span descending from the same root.
+//
+// Trace contexts are optional. Messages sent without one (e.g., with plain
+// ActorSystem.Tell) are untraced, and so is everything they cause.
+type TraceContext struct {
+	TraceID  string
+	SpanID   string
+	ParentID string
+}
+
+// A finished span: one traced message, as handled by its receiving actor.
+type Span struct {
+	TraceID  string
+	SpanID   string
+	ParentID string
+	// Uid of the receiving actor's ActorRef.
+	Actor string
+	// Go type of the handled message, e.g. "kvserver.MPut".
+	MessageType string
+	Start       time.Time
+	Duration    time.Duration
+	// The error returned by OnMessage, or "" if none.
+	Error string
+}
+
+// Receives finished spans from an ActorSystem; see
+// ActorSystem.SetTraceExporter.
+//
+// ExportSpan is called from actor goroutines, possibly concurrently, so
+// implementations must be thread-safe. It should also be fast, since it
+// runs on the handling actor's critical path.
+type TraceExporter interface {
+	ExportSpan(span Span)
+}
+
+func newTraceID() string {
+	return fmt.Sprintf("%016x", rand.Uint64())
+}
+
+// Returns a new root trace context (no parent).
+func NewTraceContext() *TraceContext {
+	return &TraceContext{TraceID: newTraceID(), SpanID: newTraceID()}
+}
+
+// Returns the trace context for a message sent while handling
+// a message with trace context parent, or nil if parent is nil.
+func (parent *TraceContext) child() *TraceContext {
+	if parent == nil {
+		return nil
+	}
+	return &TraceContext{TraceID: parent.TraceID, SpanID: newTraceID(), ParentID: parent.SpanID}
+}
+
+// Sets the exporter that receives spans for traced messages handled by this
+// system's actors. Pass nil to stop exporting.
+//
+// There can only be one exporter; an existing one is overwritten.
+func (system *ActorSystem) SetTraceExporter(exporter TraceExporter) {
+	system.traceExporterMux.Lock()
+	system.traceExporter = exporter
+	system.traceExporterMux.Unlock()
+}
+
+func (system *ActorSystem) getTraceExporter() TraceExporter {
+	system.traceExporterMux.Lock()
+	defer system.traceExporterMux.Unlock()
+	return system.traceExporter
+}
+
+// Like Tell, but starts a new trace rooted at message, which is propagated
+// to every Tell its handler (and their handlers, etc.) issues.
+//
+// Returns the root's trace context, or nil if this system has no trace
+// exporter, in which case this is exactly Tell.
+func (system *ActorSystem) TellTraced(ref *ActorRef, message any) *TraceContext {
+	if system.getTraceExporter() == nil {
+		system.Tell(ref, message)
+		return nil
+	}
+	trace := NewTraceContext()
+	system.tellInternal(ref, message, false, trace)
+	return trace
+}
+
+// Like Tell, but sends message as a child span of parent, e.g. to continue
+// a trace received from outside the actor system. A nil parent is the same
+// as Tell.
+func (system *ActorSystem) TellWithTrace(ref *ActorRef, message any, parent *TraceContext) {
+	system.tellInternal(ref, message, false, parent.child())
+}
+
+func (system *ActorSystem) exportSpan(self *ActorRef, trace *TraceContext, message any, start time.Time, err error) {
+	exporter := system.getTraceExporter()
+	if exporter == nil || trace == nil {
+		return
+	}
+	span := Span{
+		TraceID:     trace.TraceID,
+		SpanID:      trace.SpanID,
+		ParentID:    trace.ParentID,
+		Actor:       self.Uid(),
+		MessageType: fmt.Sprintf("%T", message),
+		Start:       start,
+		Duration:    time.Since(start),
+	}
+	if err != nil {
+		span.Error = err.Error()
+	}
+	exporter.ExportSpan(span)
+}
+
+// A TraceExporter that appends each span to a file as one line of JSON
+// ("JSON lines"), suitable for grepping by TraceID or loading into other
+// tools.
+type JSONLinesExporter struct {
+	mux  sync.Mutex
+	file *os.File
+	enc  *json.Encoder
+}
+
+// Opens (creating or appending to) the file at path and returns an exporter
+// writing to it. Call Close when done.
+func NewJSONLinesExporter(path string) (*JSONLinesExporter, error) {
+	file, err := os.OpenFile(path, os.O_CREATE|os.O_APPEND|os.O_WRONLY, 0644)
+	if err != nil {
+		return nil, err
+	}
+	return &JSONLinesExporter{file: file, enc: json.NewEncoder(file)}, nil
+}
+
+// ExportSpan implements TraceExporter.ExportSpan.
+//
+// Write errors are dropped, since tracing is best-effort.
+func (exporter *JSONLinesExporter) ExportSpan(span Span) {
+	exporter.mux.Lock()
+	defer exporter.mux.Unlock()
+	if exporter.file != nil {
+		exporter.enc.Encode(span)
+	}
+}
+
+// Closes the underlying file. Spans exported afterwards are dropped.
+func (exporter *JSONLinesExporter) Close() error {
+	exporter.mux.Lock()
+	defer exporter.mux.Unlock()
+	if exporter.file == nil {
+		return nil
+	}
+	err := exporter.file.Close()
+	exporter.file = nil
+	return err
+}
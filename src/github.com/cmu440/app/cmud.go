@@ -4,11 +4,13 @@ package main
 
 import (
 	"bufio"
+	"context"
 	"fmt"
 	"math/rand"
 	"os"
 	"strconv"
 	"strings"
+	"sync"
 	"time"
 
 	"github.com/cmu440/kvclient"
@@ -90,6 +92,7 @@ var cmdsOrder = [...]string{
 	"buy `computer`",
 	"inv",
 	"solve",
+	"quit",
 }
 
 var cmds = map[string]string{
@@ -102,6 +105,7 @@ var cmds = map[string]string{
 	"buy `computer`":  "Buy new hardware",
 	"inv":             "Display your inventory",
 	"solve":           "Solve next problem (enter battle!)",
+	"quit":            "Leave the map and exit",
 }
 
 var solveCmdsOrder = [...]string{
@@ -135,10 +139,22 @@ const (
 	solveRange        = 20
 )
 
-// Get / Put / List error checking wrappers
+// How long a player's location outlives their connection. While connected, it is refreshed every locTTL/3.
+const locTTL = time.Minute
+
+// The player's location, as last written by setLocation.
+var location = ""
+var locationMux sync.Mutex
+
+// How long Get, Put and List wait for a server before giving up.
+const requestTimeout = 5 * time.Second
+
+// Get / Put / Delete / Increment / List error checking wrappers
 
 func Get(cli *kvclient.Client, key string) (string, bool) {
-	value, ok, err := cli.Get(key)
+	ctx, cancel := context.WithTimeout(context.Background(), requestTimeout)
+	defer cancel()
+	value, ok, err := cli.GetCtx(ctx, key)
 	if err != nil {
 		fmt.Println(err)
 		Error("Get request failed.")
@@ -147,15 +163,47 @@ func Get(cli *kvclient.Client, key string) (string, bool) {
 }
 
 func Put(cli *kvclient.Client, key string, value string) {
-	err := cli.Put(key, value)
+	ctx, cancel := context.WithTimeout(context.Background(), requestTimeout)
+	defer cancel()
+	err := cli.PutCtx(ctx, key, value)
 	if err != nil {
 		fmt.Println(err)
 		Error("Put request failed.")
 	}
 }
 
+func ApplyBatch(cli *kvclient.Client, ops []kvclient.BatchOp) {
+	_, err := cli.ApplyBatch(ops)
+	if err != nil {
+		fmt.Println(err)
+		Error("ApplyBatch request failed.")
+	}
+}
+
+func Delete(cli *kvclient.Client, key string) {
+	_, err := cli.Delete(key)
+	if err != nil {
+		fmt.Println(err)
+		Error("Delete request failed.")
+	}
+}
+
+func Increment(cli *kvclient.Client, key string, delta int64) int64 {
+	value, ok, err := cli.Increment(key, delta)
+	if err != nil {
+		fmt.Println(err)
+		Error("Increment request failed.")
+	}
+	if !ok {
+		Error("Not a counter: " + key)
+	}
+	return value
+}
+
 func List(cli *kvclient.Client, prefix string) map[string]string {
-	entries, err := cli.List(prefix)
+	ctx, cancel := context.WithTimeout(context.Background(), requestTimeout)
+	defer cancel()
+	entries, err := cli.ListCtx(ctx, prefix)
 	if err != nil {
 		fmt.Println(err)
 		Error("List request failed.")
@@ -550,22 +598,34 @@ func InitializeCharacter(cli *kvclient.Client) {
 	_, ok := Get(cli, name)
 	if ok {
 		PrintWelcomeBack(name)
+		// Characters from before balances were counters have a plain balance, which the first increment converts.
+		// Their computer counts are converted by the first purchase, break or solve.
+		Increment(cli, balancePrefix+name, 0)
 		PrintStats(cli)
 		fmt.Println("")
-	} else {
-		Put(cli, name, "Undergraduate")
 
-		// initialize location
+		// back on the map after quitting or disconnecting
+		loc, ok := Get(cli, locPrefix+name)
+		if !ok {
+			loc = "5"
+		}
+		if err := setLocation(cli, loc); err != nil {
+			fmt.Println(err)
+			Error("Put request failed.")
+		}
+	} else {
+		// initialize education and location together, so other players never see half a character
 		locKey := locPrefix + name
-		Put(cli, locKey, "5")
+		ApplyBatch(cli, []kvclient.BatchOp{{Key: name, Value: "Undergraduate"}, {Key: locKey, Value: "5", TTL: locTTL}})
+		location = "5"
 
 		// initialize balance
 		balanceKey := balancePrefix + name
-		Put(cli, balanceKey, "0")
+		Increment(cli, balanceKey, 0)
 
 		// initialize inventory
 		compKey := compPrefix + name + delim + "cpu"
-		Put(cli, compKey, "1")
+		Increment(cli, compKey, 1)
 
 		// Welcome new character!
 		PrintWelcome(name)
@@ -617,11 +677,29 @@ func validateLocation(loc string) bool {
 	return false
 }
 
+// setLocation moves the player to loc, for locTTL unless refreshed by keepLocation.
+func setLocation(cli *kvclient.Client, loc string) error {
+	locationMux.Lock()
+	defer locationMux.Unlock()
+	location = loc
+	return cli.PutWithTTL(locPrefix+name, loc, locTTL)
+}
+
+// keepLocation refreshes the player's location while connected, so that it expires only once they disconnect.
+func keepLocation(cli *kvclient.Client) {
+	for range time.Tick(locTTL / 3) {
+		locationMux.Lock()
+		loc := location
+		locationMux.Unlock()
+		if err := setLocation(cli, loc); err != nil {
+			fmt.Println("Error:", err)
+		}
+	}
+}
+
 func move(cli *kvclient.Client, loc string) {
 	if validateLocation(loc) {
-		key := locPrefix + name
-		value := loc
-		err := cli.Put(key, value)
+		err := setLocation(cli, loc)
 		if err != nil {
 			fmt.Println("Error:", err)
 		}
@@ -651,20 +729,19 @@ func showMap(cli *kvclient.Client) {
 	}
 }
 
+// Balances and computer counts are counters, so that concurrent sessions of a player can mine and buy without losing
+// updates.
 func getBalance(cli *kvclient.Client) uint64 {
-	var balance uint64
-	var err error
-	key := balancePrefix + name
-	value, ok := Get(cli, key)
-	if ok {
-		balance, err = strconv.ParseUint(value, 10, 64)
-		if err != nil {
-			Error("Balance was not a uint64")
-		}
-	} else {
+	balance, ok, err := cli.Counter(balancePrefix + name)
+	if err != nil {
+		fmt.Println(err)
+		Error("Get request failed.")
+	}
+	if !ok {
 		Error("default balance should at least be 0 for: " + name)
 	}
-	return balance
+	// A concurrent purchase on another server may briefly overdraw.
+	return uint64(max(balance, 0))
 }
 
 func validateComputer(computer string) bool {
@@ -712,43 +789,37 @@ func mine(cli *kvclient.Client, num int) {
 		EventMessage(fmt.Sprintf("Mining bitcoin [%d/%d]...", i, num))
 		sleepTime := time.Duration(rand.Intn(miningTime))
 		time.Sleep(sleepTime * time.Second)
-		balance := getBalance(cli)
 		mined := rand.Intn(miningRange)
 		if mined <= miningSuccessRate {
 			minedCoins := getMinedCoins(cli)
-			newBalance := strconv.FormatUint(balance+minedCoins, 10)
-			key := balancePrefix + name
-			Put(cli, key, newBalance)
+			balance := Increment(cli, balancePrefix+name, int64(minedCoins))
 			EventMessage(fmt.Sprintf("Successfully mined %d coin(s)!", minedCoins))
-			PrintBalance(balance + minedCoins)
+			PrintBalance(uint64(max(balance, 0)))
 		} else {
 			EventMessage("Didn't mine a coin.")
-			PrintBalance(balance)
+			PrintBalance(getBalance(cli))
 		}
 	}
 }
 
 func buy(cli *kvclient.Client, comp string) {
 	balance := getBalance(cli)
-	computers := getComputers(cli)
 	compKey := compPrefix + name + delim + comp
-	compPrice := uint64(prices[comp])
-
-	if balance >= compPrice {
-		newNumComputers := 0
-		for balance >= compPrice {
-			balance -= compPrice
-			newNumComputers++
+	compPrice := int64(prices[comp])
+
+	newNumComputers := int64(balance) / compPrice
+	if newNumComputers > 0 {
+		// Spend the coins first; if another session spent them meanwhile, give them back.
+		cost := newNumComputers * compPrice
+		if Increment(cli, balancePrefix+name, -cost) < 0 {
+			Increment(cli, balancePrefix+name, cost)
+			newNumComputers = 0
 		}
-		EventMessage("You have purchased: " + strconv.Itoa(newNumComputers) + " " + PrettifyHardwareName(comp))
-		if numComputers, ok := computers[comp]; ok {
-			newNumComputers += numComputers
-		}
-		computers[comp] = newNumComputers
-		// update balance
-		Put(cli, balancePrefix+name, strconv.FormatUint(balance, 10))
-		// update number of computers
-		Put(cli, compKey, strconv.Itoa(newNumComputers))
+	}
+
+	if newNumComputers > 0 {
+		EventMessage("You have purchased: " + strconv.FormatInt(newNumComputers, 10) + " " + PrettifyHardwareName(comp))
+		Increment(cli, compKey, newNumComputers)
 	} else {
 		EventMessage("You could not afford: " + comp)
 	}
@@ -769,7 +840,9 @@ func ComputeProblem(cli *kvclient.Client, problem string) bool {
 		for k, v := range computers {
 			if breakHardware == index {
 				fmt.Println("Oh no! We broke some of our " + PrettifyHardwareName(k))
-				computers[k] = v / breakRate
+				// update computing power
+				compKey := compPrefix + name + delim + k
+				Increment(cli, compKey, int64(v/breakRate-v))
 				total += v / breakRate
 			} else {
 				total += v
@@ -777,12 +850,6 @@ func ComputeProblem(cli *kvclient.Client, problem string) bool {
 			index++
 		}
 
-		// update computing power
-		for k, v := range computers {
-			compKey := compPrefix + name + delim + k
-			Put(cli, compKey, strconv.Itoa(v))
-		}
-
 		// lost the battle
 		if total == 0 {
 			StoryTimeSleep()
@@ -795,7 +862,7 @@ func ComputeProblem(cli *kvclient.Client, problem string) bool {
 
 			// give back starting CPU
 			compKey := compPrefix + name + delim + "cpu"
-			Put(cli, compKey, "1")
+			Increment(cli, compKey, 1)
 			return true
 		}
 		return false
@@ -909,6 +976,7 @@ func main() {
 				name = words[0]
 				InitializeCharacter(cli)
 				InitializeProblems(cli)
+				go keepLocation(cli)
 				break
 			}
 		}
@@ -982,6 +1050,12 @@ func main() {
 			}
 		case "solve":
 			SolveProblem(cli, reader)
+		case "quit":
+			// Leave the map; everything else is kept for next time.
+			Delete(cli, locPrefix+name)
+			cli.Close()
+			fmt.Println("Goodbye!")
+			os.Exit(0)
 		default:
 			PrintHelpMessage()
 		}
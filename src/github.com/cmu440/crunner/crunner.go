@@ -42,7 +42,7 @@ func main() {
 		line = strings.TrimSpace(line)
 		words := strings.Split(line, " ")
 		if len(words) == 0 {
-			fmt.Println("Commands are [Get, List, Put]")
+			fmt.Println("Commands are [Get, List, Put, Delete]")
 			continue
 		}
 		switch words[0] {
@@ -89,8 +89,23 @@ func main() {
 				fmt.Println("Error:", err)
 			}
 			fmt.Println("Ok")
+		case "Delete":
+			if len(words) != 2 {
+				fmt.Println("Usage: Delete <key>")
+				continue
+			}
+			key := words[1]
+			ok, err := cli.Delete(key)
+			if err != nil {
+				fmt.Println("Error:", err)
+			}
+			if ok {
+				fmt.Println("Ok")
+			} else {
+				fmt.Println("Not present")
+			}
 		default:
-			fmt.Println("Unknown command; commands are [Get, List, Put]")
+			fmt.Println("Unknown command; commands are [Get, List, Put, Delete]")
 		}
 	}
 }
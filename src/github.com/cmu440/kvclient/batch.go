@@ -0,0 +1,61 @@
+package kvclient
+
+import (
+	"errors"
+
+	"github.com/cmu440/kvcommon"
+)
+
+// ErrBatchSpansOwners is the cause of the ServerError of a batch whose keys have different owners in the server's
+// partitioned mode.
+var ErrBatchSpansOwners = errors.New("kvclient: batch keys have different owners")
+
+// BatchOp
+// A write in a batch, as accepted by ApplyBatch: sets Key to Value, or removes Key if Delete, with an optional TTL as
+// in PutWithTTL. See kvcommon.BatchOp.
+type BatchOp = kvcommon.BatchOp
+
+// MultiGet
+// Returns the values of the present keys among keys, all read at once from one replica, in one RPC.
+//
+// If there is a network error contacting the RPC server indicated by router.NextAddr(), that error is returned instead.
+func (client *Client) MultiGet(keys []string) (entries map[string]string, err error) {
+	reply := kvcommon.MultiGetReply{}
+	if err := client.call("QueryReceiver.MultiGet", kvcommon.MultiGetArgs{Keys: keys}, &reply); err != nil {
+		return nil, err
+	}
+	if reply.Entries == nil {
+		reply.Entries = make(map[string]string)
+	}
+	return reply.Entries, nil
+}
+
+// MultiPut
+// Sets the value of each key in entries, atomically, in one RPC. See ApplyBatch.
+//
+// If there is a network error contacting the RPC server indicated by router.NextAddr(), that error is returned instead.
+func (client *Client) MultiPut(entries map[string]string) error {
+	ops := make([]BatchOp, 0, len(entries))
+	for key, value := range entries {
+		ops = append(ops, BatchOp{Key: key, Value: value})
+	}
+	_, err := client.ApplyBatch(ops)
+	return err
+}
+
+// ApplyBatch
+// Applies all writes in ops at once, in one RPC: a Get, List or MultiGet on any replica sees either none of them or
+// all of them (or newer writes). If several ops write the same key, the last one wins. Returns the Version all the
+// writes were made at, a write token for GetAfter of any of their keys.
+//
+// In the server's partitioned mode, the keys of ops must all have the same owners; otherwise a ServerError wrapping
+// ErrBatchSpansOwners is returned and nothing is written. Use a transaction (Begin) to write keys of different owners.
+//
+// If there is a network error contacting the RPC server indicated by router.NextAddr(), that error is returned instead.
+func (client *Client) ApplyBatch(ops []BatchOp) (version Version, err error) {
+	reply := kvcommon.BatchReply{}
+	if err := client.call("QueryReceiver.ApplyBatch", kvcommon.BatchArgs{Ops: ops}, &reply); err != nil {
+		return Version{}, err
+	}
+	return reply.Version, nil
+}
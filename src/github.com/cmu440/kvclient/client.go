@@ -3,9 +3,12 @@
 package kvclient
 
 import (
-	"fmt"
+	"context"
+	"errors"
 	"github.com/cmu440/kvcommon"
 	"net/rpc"
+	"reflect"
+	"time"
 )
 
 // QueryRouter
@@ -31,6 +34,10 @@ type Client struct {
 	// Once you get the address, use rpc.Dial to get a rpc.Client.
 	// For compatibility with our tests, use network "tcp".
 	router QueryRouter
+	// Connections to the RPC servers, shared by all calls.
+	pool *connPool
+	// Retry policy and circuit breakers.
+	retries *retrier
 }
 
 // NewClient
@@ -38,9 +45,20 @@ type Client struct {
 func NewClient(router QueryRouter) *Client {
 	return &Client{
 		router,
+		newConnPool(),
+		newRetrier(),
 	}
 }
 
+// SetIdleTimeout
+// Sets how long a connection to an RPC server may carry no calls before it is closed, one minute by default. It
+// applies to connections that become idle afterwards.
+func (client *Client) SetIdleTimeout(timeout time.Duration) {
+	client.pool.mux.Lock()
+	defer client.pool.mux.Unlock()
+	client.pool.idleTimeout = timeout
+}
+
 // Send RPCs to type and name "QueryReceiver", defined in kvcommon/rpc_types.go.
 // Your implementation should be thread-safe: there may be concurrent Get/Put/List calls, or multiple outstanding RPCs.
 
@@ -50,22 +68,11 @@ func NewClient(router QueryRouter) *Client {
 //
 // If there is a network error contacting the RPC server indicated by router.NextAddr(), that error is returned instead.
 func (client *Client) Get(key string) (value string, ok bool, err error) {
-	addr := client.router.NextAddr()
-	conn, err := rpc.Dial("tcp", addr)
-	if err != nil {
-		fmt.Println(err)
-		return "", false, nil
-	}
 	args := kvcommon.GetArgs{Key: key}
 	reply := kvcommon.GetReply{}
-	err = conn.Call("QueryReceiver.Get", args, &reply)
-
-	if err != nil {
-		fmt.Println(err)
-		return "", false, nil
-
+	if err := client.call("QueryReceiver.Get", args, &reply); err != nil {
+		return "", false, err
 	}
-
 	return reply.Value, reply.Ok, nil
 }
 
@@ -75,54 +82,450 @@ func (client *Client) Get(key string) (value string, ok bool, err error) {
 //
 // If there is a network error contacting the RPC server indicated by router.NextAddr(), that error is returned instead.
 func (client *Client) List(prefix string) (entries map[string]string, err error) {
-	addr := client.router.NextAddr()
-	conn, err := rpc.Dial("tcp", addr)
-	if err != nil {
-		fmt.Println(err)
-		return nil, err
-	}
 	args := kvcommon.ListArgs{Prefix: prefix}
 	reply := kvcommon.ListReply{}
-	err = conn.Call("QueryReceiver.List", args, &reply)
-
-	if err != nil {
-		fmt.Println(err)
+	if err := client.call("QueryReceiver.List", args, &reply); err != nil {
 		return nil, err
-
 	}
-
 	return reply.Entries, nil
 }
 
 // Put
-// Sets the value associated with key.
+// Sets the value associated with key, returning once the serving replica has written it. Other replicas get the value
+// with the next syncs; use PutWithVersion and GetAfter to read it from them.
 //
+// If key holds a CRDT, a ServerError wrapping ErrWrongType is returned.
 // If there is a network error contacting the RPC server indicated by router.NextAddr(), that error is returned instead.
 func (client *Client) Put(key string, value string) error {
-	addr := client.router.NextAddr()
-	conn, err := rpc.Dial("tcp", addr)
-	if err != nil {
-		fmt.Println(err)
-		return err
+	args := kvcommon.PutArgs{Key: key, Value: value}
+	return client.call("QueryReceiver.Put", args, &kvcommon.PutReply{})
+}
+
+// Delete
+// Removes the value associated with key. Returns whether the serving replica had a value for key.
+//
+// The delete is synced to other replicas like a Put and wins against older Puts of key, though a Get from another
+// replica may still return the old value until then.
+//
+// If there is a network error contacting the RPC server indicated by router.NextAddr(), that error is returned instead.
+func (client *Client) Delete(key string) (ok bool, err error) {
+	args := kvcommon.DeleteArgs{Key: key}
+	reply := kvcommon.DeleteReply{}
+	if err := client.call("QueryReceiver.Delete", args, &reply); err != nil {
+		return false, err
+	}
+	return reply.Ok, nil
+}
+
+// Version
+// Version of a key's value, as returned by GetWithVersion and accepted by PutIfVersion. See kvcommon.Version.
+type Version = kvcommon.Version
+
+// GetWithVersion
+// Like Get, but also returns the value's Version, for a later PutIfVersion. If key is not present, the zero Version
+// is returned.
+//
+// If there is a network error contacting the RPC server indicated by router.NextAddr(), that error is returned instead.
+func (client *Client) GetWithVersion(key string) (value string, version Version, ok bool, err error) {
+	args := kvcommon.GetVersionArgs{Key: key}
+	reply := kvcommon.GetVersionReply{}
+	if err := client.call("QueryReceiver.GetVersion", args, &reply); err != nil {
+		return "", Version{}, false, err
 	}
+	return reply.Value, reply.Version, reply.Ok, nil
+}
+
+// PutWithVersion
+// Like Put, but also returns the written value's Version.
+//
+// The Version serves as a read-your-writes token: a later GetWithVersion of key has seen this write (or a newer one)
+// if its Version is not older, i.e. !version.Newer(readVersion), and GetAfter waits until it has.
+//
+// If there is a network error contacting the RPC server indicated by router.NextAddr(), that error is returned instead.
+func (client *Client) PutWithVersion(key string, value string) (version Version, err error) {
 	args := kvcommon.PutArgs{Key: key, Value: value}
-	reply := kvcommon.PutReply{}
-	err = conn.Call("QueryReceiver.Put", args, &reply)
+	reply := kvcommon.PutVersionReply{}
+	if err := client.call("QueryReceiver.PutVersion", args, &reply); err != nil {
+		return Version{}, err
+	}
+	return reply.Version, nil
+}
 
-	if err != nil {
-		fmt.Println(err)
-		return err
+// PutWithTTL
+// Like Put, but the value expires after ttl: from then on, every replica treats key as deleted, unless a newer write
+// of key replaced the value. Expiry counts from the serving replica's clock, so replicas with skewed clocks expire the
+// value at slightly different times. A ttl of zero never expires.
+//
+// The TTL is ignored for keys in the server's MultiValue conflict mode.
+//
+// If there is a network error contacting the RPC server indicated by router.NextAddr(), that error is returned instead.
+func (client *Client) PutWithTTL(key string, value string, ttl time.Duration) error {
+	args := kvcommon.PutArgs{Key: key, Value: value, TTL: ttl}
+	return client.call("QueryReceiver.Put", args, &kvcommon.PutReply{})
+}
+
+// Consistency
+// Consistency level of a Get or Put, as accepted by GetWithConsistency and PutWithConsistency. See
+// kvcommon.Consistency.
+type Consistency = kvcommon.Consistency
+
+const (
+	// Only the serving replica answers; the level of Get and Put.
+	One = kvcommon.One
+	// A majority of the key's replicas answer.
+	Quorum = kvcommon.Quorum
+	// All of the key's replicas answer.
+	All = kvcommon.All
+)
 
+// GetWithConsistency
+// Like Get, but the serving replica asks level of the key's replicas for their values, and returns the newest.
+// Replicas that answered with an older value are repaired.
+//
+// A GetWithConsistency at Quorum after a PutWithConsistency at Quorum sees that write (or a newer one), as the two
+// sets of replicas overlap; the same holds for All with any level.
+//
+// If fewer replicas than level answer within the server's Config.ConsistencyTimeout, an error is returned.
+// If there is a network error contacting the RPC server indicated by router.NextAddr(), that error is returned instead.
+func (client *Client) GetWithConsistency(key string, level Consistency) (value string, ok bool, err error) {
+	args := kvcommon.GetArgs{Key: key, Consistency: level}
+	reply := kvcommon.GetReply{}
+	if err := client.call("QueryReceiver.Get", args, &reply); err != nil {
+		return "", false, err
 	}
+	return reply.Value, reply.Ok, nil
+}
 
-	return nil
+// PutWithConsistency
+// Like Put, but waits until level of the key's replicas have written the value.
+//
+// If fewer replicas than level acknowledge the write within the server's Config.ConsistencyTimeout, an error is
+// returned. The write may still have been made on some replicas, and then reaches the others with the next syncs.
+// If there is a network error contacting the RPC server indicated by router.NextAddr(), that error is returned instead.
+func (client *Client) PutWithConsistency(key string, value string, level Consistency) error {
+	args := kvcommon.PutArgs{Key: key, Value: value, Consistency: level}
+	return client.call("QueryReceiver.Put", args, &kvcommon.PutReply{})
 }
 
-// Close
-// OPTIONAL: Closes the client, including all of its RPC clients.
+// GetAfter
+// Like Get, but the serving replica first waits until it has seen the write with Version after, as returned by
+// PutWithVersion (or a newer write of key). So a session that passes the Versions of its writes to later reads reads
+// its writes on any replica. The zero Version does not wait.
+//
+// If the serving replica does not catch up within the server's Config.CatchUpTimeout, the call is retried on the next
+// replica, as the retry policy allows, and otherwise an error is returned.
+// If there is a network error contacting the RPC server indicated by router.NextAddr(), that error is returned instead.
+func (client *Client) GetAfter(key string, after Version) (value string, ok bool, err error) {
+	args := kvcommon.GetArgs{Key: key, After: after}
+	reply := kvcommon.GetReply{}
+	if err := client.call("QueryReceiver.Get", args, &reply); err != nil {
+		return "", false, err
+	}
+	return reply.Value, reply.Ok, nil
+}
+
+// CausalContext
+// Causal context of a multi-value key's siblings, as returned by GetSiblings and accepted by PutWithContext. See
+// kvcommon.CausalContext.
+type CausalContext = kvcommon.CausalContext
+
+// GetSiblings
+// Returns all concurrent values of key, oldest first, and a causal context covering them. Empty if key is not present.
+//
+// Keys in the server's MultiValue conflict mode keep concurrent writes as siblings until a PutWithContext resolves
+// them; Get and List show only the newest. Other keys have at most one value.
+//
+// If there is a network error contacting the RPC server indicated by router.NextAddr(), that error is returned instead.
+func (client *Client) GetSiblings(key string) (values []string, context CausalContext, err error) {
+	args := kvcommon.GetSiblingsArgs{Key: key}
+	reply := kvcommon.GetSiblingsReply{}
+	if err := client.call("QueryReceiver.GetSiblings", args, &reply); err != nil {
+		return nil, nil, err
+	}
+	return reply.Values, reply.Context, nil
+}
+
+// PutWithContext
+// Sets the value associated with key, replacing the siblings that context (from GetSiblings) covers. Siblings written
+// concurrently, that context has not seen, are kept.
+//
+// Put is the same as PutWithContext with a nil context: for keys in MultiValue conflict mode, it adds a sibling.
+//
+// If there is a network error contacting the RPC server indicated by router.NextAddr(), that error is returned instead.
+func (client *Client) PutWithContext(key string, value string, context CausalContext) error {
+	args := kvcommon.PutWithContextArgs{Key: key, Value: value, Context: context}
+	return client.call("QueryReceiver.PutWithContext", args, &kvcommon.PutReply{})
+}
+
+// Conditional writes
+//
+// PutIfAbsent, CompareAndSet and PutIfVersion check their condition and write in one step on the serving query actor,
+// so they are linearizable among all queries served by that actor: of two sessions racing to update a key through
+// the same actor, at most one succeeds.
+//
+// Across replicas they are not: two actors may each accept a conditional write before syncing, and last-writer-wins
+// sync then keeps only the newer one (by Version) everywhere, silently dropping the other. Route all conditional
+// writes to a key through one actor if none may be lost.
+//
+// A read-modify-write loop of GetWithVersion and PutIfVersion may lose to other sessions any number of times, so bound
+// its attempts and back off between them; for counters, Increment needs no loop at all.
+
+// PutIfAbsent
+// Sets the value associated with key if key is not present. Returns whether it was set.
+//
+// If there is a network error contacting the RPC server indicated by router.NextAddr(), that error is returned instead.
+func (client *Client) PutIfAbsent(key string, value string) (ok bool, err error) {
+	args := kvcommon.PutIfAbsentArgs{Key: key, Value: value}
+	reply := kvcommon.ConditionalPutReply{}
+	if err := client.call("QueryReceiver.PutIfAbsent", args, &reply); err != nil {
+		return false, err
+	}
+	return reply.Ok, nil
+}
+
+// CompareAndSet
+// Sets the value associated with key to value if it is currently expected. Returns whether it was set.
 //
-// You are not required to implement this function for full credit; the tests end by calling Close but do not check
-// that it does anything. However, you may find it useful to implement this so that you can run multiple/repeated
-// tests in the same "go test" command without cross-test interference.
+// If there is a network error contacting the RPC server indicated by router.NextAddr(), that error is returned instead.
+func (client *Client) CompareAndSet(key string, expected string, value string) (ok bool, err error) {
+	args := kvcommon.CompareAndSetArgs{Key: key, Expected: expected, Value: value}
+	reply := kvcommon.ConditionalPutReply{}
+	if err := client.call("QueryReceiver.CompareAndSet", args, &reply); err != nil {
+		return false, err
+	}
+	return reply.Ok, nil
+}
+
+// PutIfVersion
+// Sets the value associated with key if its current Version is version, as returned by GetWithVersion; the zero
+// Version matches an absent key. Returns whether it was set, and if so the new Version.
+//
+// Unlike CompareAndSet, this fails if key was overwritten in between, even with the same value.
+//
+// If there is a network error contacting the RPC server indicated by router.NextAddr(), that error is returned instead.
+func (client *Client) PutIfVersion(key string, version Version, value string) (newVersion Version, ok bool, err error) {
+	args := kvcommon.PutIfVersionArgs{Key: key, Version: version, Value: value}
+	reply := kvcommon.ConditionalPutReply{}
+	if err := client.call("QueryReceiver.PutIfVersion", args, &reply); err != nil {
+		return Version{}, false, err
+	}
+	if !reply.Ok {
+		return Version{}, false, nil
+	}
+	return reply.Version, true, nil
+}
+
+// CRDTs
+//
+// Increment, SetAdd, SetRemove, MapSet and MapDelete update conflict-free replicated data types stored at a key:
+// a counter, a set of strings, or a map of strings. Replicas merge concurrent updates instead of picking one, so
+// unlike read-modify-write with Get and Put, no update is lost, on any replica.
+//
+// A key holding a CRDT is created by its first update, and keeps its type: updates of another type, Delete and
+// conditional writes to it have no effect, and Puts of it fail with ErrWrongType. Get and List show a counter as a
+// decimal integer, a set as a sorted JSON array and a map as a JSON object.
+
+// ErrWrongType is the cause of the ServerError of a Put of a key holding a CRDT.
+var ErrWrongType = errors.New("kvclient: key holds a CRDT")
+
+// Increment
+// Adds delta (possibly negative) to the counter at key, creating it at zero if key is not present, or at the integer
+// key holds if it is a plain value. Returns the counter's value on the serving replica after the increment, and false
+// if key holds something else.
+//
+// If there is a network error contacting the RPC server indicated by router.NextAddr(), that error is returned instead.
+func (client *Client) Increment(key string, delta int64) (value int64, ok bool, err error) {
+	reply := kvcommon.CRDTReply{}
+	if err := client.call("QueryReceiver.Increment", kvcommon.IncrementArgs{Key: key, Delta: delta}, &reply); err != nil {
+		return 0, false, err
+	}
+	return reply.Counter, reply.Ok, nil
+}
+
+// Counter
+// Returns the value of the counter at key, or false if key does not hold a counter.
+//
+// If there is a network error contacting the RPC server indicated by router.NextAddr(), that error is returned instead.
+func (client *Client) Counter(key string) (value int64, ok bool, err error) {
+	reply := kvcommon.CRDTReply{}
+	if err := client.call("QueryReceiver.GetCRDT", kvcommon.GetCRDTArgs{Key: key}, &reply); err != nil {
+		return 0, false, err
+	}
+	return reply.Counter, reply.Ok && reply.Type == "counter", nil
+}
+
+// SetAdd
+// Adds member to the set at key, creating it if key is not present. Returns false if key holds something else.
+//
+// A member added and removed concurrently on different replicas stays in the set.
+//
+// If there is a network error contacting the RPC server indicated by router.NextAddr(), that error is returned instead.
+func (client *Client) SetAdd(key string, member string) (ok bool, err error) {
+	reply := kvcommon.CRDTReply{}
+	if err := client.call("QueryReceiver.SetAdd", kvcommon.SetArgs{Key: key, Member: member}, &reply); err != nil {
+		return false, err
+	}
+	return reply.Ok, nil
+}
+
+// SetRemove
+// Removes member from the set at key. Returns false if key holds something else.
+//
+// If there is a network error contacting the RPC server indicated by router.NextAddr(), that error is returned instead.
+func (client *Client) SetRemove(key string, member string) (ok bool, err error) {
+	reply := kvcommon.CRDTReply{}
+	if err := client.call("QueryReceiver.SetRemove", kvcommon.SetArgs{Key: key, Member: member}, &reply); err != nil {
+		return false, err
+	}
+	return reply.Ok, nil
+}
+
+// SetMembers
+// Returns the sorted members of the set at key, or false if key does not hold a set.
+//
+// If there is a network error contacting the RPC server indicated by router.NextAddr(), that error is returned instead.
+func (client *Client) SetMembers(key string) (members []string, ok bool, err error) {
+	reply := kvcommon.CRDTReply{}
+	if err := client.call("QueryReceiver.GetCRDT", kvcommon.GetCRDTArgs{Key: key}, &reply); err != nil {
+		return nil, false, err
+	}
+	if !reply.Ok || reply.Type != "set" {
+		return nil, false, nil
+	}
+	if reply.Members == nil {
+		reply.Members = make([]string, 0)
+	}
+	return reply.Members, true, nil
+}
+
+// MapSet
+// Sets field to value in the map at key, creating it if key is not present. Returns false if key holds something else.
+//
+// Concurrent updates of the same field are resolved by last-writer-wins; different fields do not conflict.
+//
+// If there is a network error contacting the RPC server indicated by router.NextAddr(), that error is returned instead.
+func (client *Client) MapSet(key string, field string, value string) (ok bool, err error) {
+	reply := kvcommon.CRDTReply{}
+	if err := client.call("QueryReceiver.MapSet", kvcommon.MapArgs{Key: key, Field: field, Value: value}, &reply); err != nil {
+		return false, err
+	}
+	return reply.Ok, nil
+}
+
+// MapDelete
+// Removes field from the map at key. Returns false if key holds something else.
+//
+// If there is a network error contacting the RPC server indicated by router.NextAddr(), that error is returned instead.
+func (client *Client) MapDelete(key string, field string) (ok bool, err error) {
+	reply := kvcommon.CRDTReply{}
+	if err := client.call("QueryReceiver.MapDelete", kvcommon.MapArgs{Key: key, Field: field}, &reply); err != nil {
+		return false, err
+	}
+	return reply.Ok, nil
+}
+
+// MapEntries
+// Returns the fields of the map at key, or false if key does not hold a map.
+//
+// If there is a network error contacting the RPC server indicated by router.NextAddr(), that error is returned instead.
+func (client *Client) MapEntries(key string) (entries map[string]string, ok bool, err error) {
+	reply := kvcommon.CRDTReply{}
+	if err := client.call("QueryReceiver.GetCRDT", kvcommon.GetCRDTArgs{Key: key}, &reply); err != nil {
+		return nil, false, err
+	}
+	if !reply.Ok || reply.Type != "map" {
+		return nil, false, nil
+	}
+	if reply.Entries == nil {
+		reply.Entries = make(map[string]string)
+	}
+	return reply.Entries, true, nil
+}
+
+// call
+// Calls the RPC method on the server indicated by router.NextAddr(), retrying on the next ones as the retry policy
+// allows.
+func (client *Client) call(method string, args any, reply any) error {
+	return client.callCtx(context.Background(), method, args, reply)
+}
+
+// callCtx
+// Like call, but gives up once ctx is done, returning ctx.Err().
+func (client *Client) callCtx(ctx context.Context, method string, args any, reply any) error {
+	policy := client.retries.policy()
+	backoff := policy.Backoff
+	for attempt := 1; ; attempt++ {
+		err := client.callAtCtx(ctx, client.router.NextAddr(), method, args, reply)
+		if err == nil || attempt >= policy.Attempts || !retryable(method, err) {
+			return err
+		}
+		timer := time.NewTimer(backoff)
+		select {
+		case <-timer.C:
+		case <-ctx.Done():
+			timer.Stop()
+			return ctx.Err()
+		}
+		backoff = min(2*backoff, policy.MaxBackoff)
+	}
+}
+
+// callAt
+// Calls the RPC method on the server at addr, over the pooled connection to it, unless its circuit breaker is open.
+// A call over a reused connection that turns out to be shut down was never sent, and is retried once over a new
+// connection. Returns a NetworkError or ServerError if the call fails, or ErrClosed.
+func (client *Client) callAt(addr string, method string, args any, reply any) error {
+	return client.callAtCtx(context.Background(), addr, method, args, reply)
+}
+
+// callAtCtx
+// Like callAt, but gives up once ctx is done, returning ctx.Err(). The server may still handle the call then, and
+// its reply is dropped: the call decodes it into a reply of its own, copied to reply only if it arrives in time. As
+// the server may be stalled, later calls do not use the connection.
+func (client *Client) callAtCtx(ctx context.Context, addr string, method string, args any, reply any) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	if !client.retries.allow(addr) {
+		return &NetworkError{addr, method, false, ErrCircuitOpen}
+	}
+	for {
+		conn, dialed, err := client.pool.acquire(ctx, addr)
+		if err != nil && (err == ErrClosed || ctx.Err() != nil) {
+			client.retries.abandon(addr)
+			return err
+		} else if err != nil {
+			client.retries.record(addr, true)
+			return &NetworkError{addr, method, false, err}
+		}
+		private := reflect.New(reflect.TypeOf(reply).Elem())
+		done := conn.rpc.Go(method, args, private.Interface(), make(chan *rpc.Call, 1)).Done
+		select {
+		case call := <-done:
+			err = call.Error
+			reflect.ValueOf(reply).Elem().Set(private.Elem())
+		case <-ctx.Done():
+			client.pool.release(addr, conn, ctx.Err())
+			client.retries.abandon(addr)
+			return ctx.Err()
+		}
+		client.pool.release(addr, conn, err)
+		if err == rpc.ErrShutdown && !dialed {
+			continue
+		}
+		var serverErr rpc.ServerError
+		client.retries.record(addr, broken(err))
+		if err == nil {
+			return nil
+		} else if errors.As(err, &serverErr) {
+			return &ServerError{addr, method, string(serverErr)}
+		}
+		return &NetworkError{addr, method, true, err}
+	}
+}
+
+// Close
+// Closes the client, including all of its connections. Calls in flight fail, as do all later calls, with ErrClosed.
 func (client *Client) Close() {
+	client.pool.close()
 }
@@ -0,0 +1,63 @@
+package kvclient
+
+import (
+	"context"
+	"time"
+
+	"github.com/cmu440/kvcommon"
+)
+
+// Contexts
+//
+// GetCtx, ListCtx, PutCtx and GetWithVersionCtx take a context.Context, and return ctx.Err() once it is done, whether
+// the call is dialing, waiting for the server's reply or backing off before a retry. ctx's deadline, if any, is sent
+// along with the request, so that the server stops waiting for its query actor at the deadline as well. A write
+// abandoned this way may still be applied.
+
+// deadline
+// Returns ctx's deadline, or the zero Time if it has none.
+func deadline(ctx context.Context) time.Time {
+	d, _ := ctx.Deadline()
+	return d
+}
+
+// GetCtx
+// Like Get, but gives up once ctx is done, returning ctx.Err().
+func (client *Client) GetCtx(ctx context.Context, key string) (value string, ok bool, err error) {
+	args := kvcommon.GetArgs{Key: key, Deadline: deadline(ctx)}
+	reply := kvcommon.GetReply{}
+	if err := client.callCtx(ctx, "QueryReceiver.Get", args, &reply); err != nil {
+		return "", false, err
+	}
+	return reply.Value, reply.Ok, nil
+}
+
+// GetWithVersionCtx
+// Like GetWithVersion, but gives up once ctx is done, returning ctx.Err().
+func (client *Client) GetWithVersionCtx(ctx context.Context, key string) (value string, version Version, ok bool,
+	err error) {
+	args := kvcommon.GetVersionArgs{Key: key, Deadline: deadline(ctx)}
+	reply := kvcommon.GetVersionReply{}
+	if err := client.callCtx(ctx, "QueryReceiver.GetVersion", args, &reply); err != nil {
+		return "", Version{}, false, err
+	}
+	return reply.Value, reply.Version, reply.Ok, nil
+}
+
+// ListCtx
+// Like List, but gives up once ctx is done, returning ctx.Err().
+func (client *Client) ListCtx(ctx context.Context, prefix string) (entries map[string]string, err error) {
+	args := kvcommon.ListArgs{Prefix: prefix, Deadline: deadline(ctx)}
+	reply := kvcommon.ListReply{}
+	if err := client.callCtx(ctx, "QueryReceiver.List", args, &reply); err != nil {
+		return nil, err
+	}
+	return reply.Entries, nil
+}
+
+// PutCtx
+// Like Put, but gives up once ctx is done, returning ctx.Err(). The value may still be written then.
+func (client *Client) PutCtx(ctx context.Context, key string, value string) error {
+	args := kvcommon.PutArgs{Key: key, Value: value, Deadline: deadline(ctx)}
+	return client.callCtx(ctx, "QueryReceiver.Put", args, &kvcommon.PutReply{})
+}
@@ -0,0 +1,187 @@
+package kvclient
+
+import (
+	"context"
+	"errors"
+	"net"
+	"net/rpc"
+	"sync"
+	"time"
+)
+
+// Connections
+//
+// A Client keeps one connection per RPC server address, dialed on first use and shared by all of its calls: net/rpc
+// pipelines concurrent calls over a connection, matching replies to calls by sequence number, so a slow call (e.g. a
+// Watch long poll) does not hold up the others. A connection that carried no call for the idle timeout is closed, and
+// dialed again on next use. A connection that breaks is dropped, so that the next call dials a new one. A connection
+// over which a call was abandoned, e.g. as its context was done, is taken out of the pool as well, as the server may
+// be stalled, but closed only once the other calls over it end, so that they are not cut off.
+
+// Default time a connection may stay idle before it is closed.
+const defaultIdleTimeout = time.Minute
+
+// ErrClosed is returned by calls on a Client after Close.
+var ErrClosed = errors.New("kvclient: client is closed")
+
+// connPool
+// The connections of a Client, by address.
+type connPool struct {
+	mux   sync.Mutex
+	conns map[string]*pooledConn
+	// Closed once the dial in progress to an address ends.
+	dialing map[string]chan struct{}
+	// Time a connection may stay idle before it is closed.
+	idleTimeout time.Duration
+	closed      bool
+}
+
+// pooledConn
+// A connection of a connPool.
+type pooledConn struct {
+	rpc *rpc.Client
+	// Number of calls in flight over the connection.
+	calls int
+	// Closes the connection once idle; nil while calls are in flight.
+	idle *time.Timer
+}
+
+// newConnPool
+// Returns an empty connPool.
+func newConnPool() *connPool {
+	return &connPool{
+		conns:       make(map[string]*pooledConn),
+		dialing:     make(map[string]chan struct{}),
+		idleTimeout: defaultIdleTimeout,
+	}
+}
+
+// acquire
+// Returns the connection to addr, dialing it if there is none, for one call; release it after the call. Returns
+// whether the connection was dialed for this call. Calls that find a dial to addr in progress wait for it. Returns
+// ctx.Err() if ctx is done before there is a connection.
+func (pool *connPool) acquire(ctx context.Context, addr string) (conn *pooledConn, dialed bool, err error) {
+	pool.mux.Lock()
+	for {
+		if conn, err := pool.reuse(addr); conn != nil || err != nil {
+			pool.mux.Unlock()
+			return conn, false, err
+		}
+		done, ok := pool.dialing[addr]
+		if !ok {
+			break
+		}
+		pool.mux.Unlock()
+		select {
+		case <-done:
+		case <-ctx.Done():
+			return nil, false, ctx.Err()
+		}
+		pool.mux.Lock()
+	}
+	done := make(chan struct{})
+	pool.dialing[addr] = done
+	pool.mux.Unlock()
+
+	// Dial without the lock, so that calls to other addresses are not held up.
+	netConn, err := (&net.Dialer{}).DialContext(ctx, "tcp", addr)
+	pool.mux.Lock()
+	defer pool.mux.Unlock()
+	delete(pool.dialing, addr)
+	close(done)
+	if ctx.Err() != nil {
+		if err == nil {
+			netConn.Close()
+		}
+		return nil, false, ctx.Err()
+	} else if err != nil {
+		return nil, false, err
+	}
+	if pool.closed {
+		netConn.Close()
+		return nil, false, ErrClosed
+	}
+	conn = &pooledConn{rpc: rpc.NewClient(netConn), calls: 1}
+	pool.conns[addr] = conn
+	return conn, true, nil
+}
+
+// reuse
+// Returns the connection to addr for one call, or nil if there is none. Must be called with pool.mux held.
+func (pool *connPool) reuse(addr string) (*pooledConn, error) {
+	if pool.closed {
+		return nil, ErrClosed
+	}
+	conn, ok := pool.conns[addr]
+	if !ok {
+		return nil, nil
+	}
+	if conn.idle != nil {
+		conn.idle.Stop()
+		conn.idle = nil
+	}
+	conn.calls++
+	return conn, nil
+}
+
+// release
+// Ends a call over conn, the connection to addr, that failed with err, if any. Drops the connection if err shows it
+// is broken, takes it out of the pool if the call was abandoned, and starts its idle timer if it carries no more
+// calls.
+func (pool *connPool) release(addr string, conn *pooledConn, err error) {
+	pool.mux.Lock()
+	defer pool.mux.Unlock()
+	conn.calls--
+	if errors.Is(err, context.Canceled) || errors.Is(err, context.DeadlineExceeded) {
+		if pool.conns[addr] == conn {
+			delete(pool.conns, addr)
+		}
+	} else if broken(err) {
+		pool.drop(addr, conn)
+		return
+	}
+	if conn.calls == 0 && pool.conns[addr] != conn {
+		// Taken out of the pool, so no call will reuse it.
+		pool.drop(addr, conn)
+	} else if conn.calls == 0 {
+		conn.idle = time.AfterFunc(pool.idleTimeout, func() {
+			pool.mux.Lock()
+			defer pool.mux.Unlock()
+			if conn.calls == 0 {
+				pool.drop(addr, conn)
+			}
+		})
+	}
+}
+
+// drop
+// Closes conn, and removes it from the pool if it is still the connection to addr. Must be called with pool.mux held.
+func (pool *connPool) drop(addr string, conn *pooledConn) {
+	if pool.conns[addr] == conn {
+		delete(pool.conns, addr)
+	}
+	if conn.idle != nil {
+		conn.idle.Stop()
+		conn.idle = nil
+	}
+	conn.rpc.Close()
+}
+
+// close
+// Closes all connections. Calls in flight over them fail with rpc.ErrShutdown, and later acquires with ErrClosed.
+func (pool *connPool) close() {
+	pool.mux.Lock()
+	defer pool.mux.Unlock()
+	pool.closed = true
+	for addr, conn := range pool.conns {
+		pool.drop(addr, conn)
+	}
+}
+
+// broken
+// Returns whether err, returned by a call, shows that its connection can carry no more calls. Errors returned by the
+// RPC server itself (rpc.ServerError) leave the connection usable.
+func broken(err error) bool {
+	var serverErr rpc.ServerError
+	return err != nil && !errors.As(err, &serverErr)
+}
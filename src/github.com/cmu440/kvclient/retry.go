@@ -0,0 +1,228 @@
+package kvclient
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/cmu440/kvcommon"
+)
+
+// Retries
+//
+// A call that fails with a NetworkError is retried, up to RetryPolicy.Attempts in all, with exponential backoff in
+// between. Each attempt goes to the next address of the router, so that calls fail over from a server that is down.
+// Calls of reads are retried after any network error. Writes are retried only if the request was never sent, as the
+// server may have applied it before the failure: sent again, a Put, PutVersion, ApplyBatch or CRDT update would be
+// applied at a new Version, superseding or undoing writes to its keys made in between, and a Delete, conditional put
+// or Commit would see its own effect. Errors returned by the server itself (ServerError) are not retried, except for
+// reads that the replica did not catch up with in time (kvcommon.NotCaughtUp), which go to the next replica.
+//
+// Each address has a circuit breaker: after BreakerPolicy.Failures network errors in a row, calls to the address fail
+// at once with ErrCircuitOpen, for BreakerPolicy.Cooldown. Then one call is let through as a probe: if it reaches the
+// server, the breaker closes again, and otherwise it opens for another cooldown.
+
+// ErrCircuitOpen is the cause of the NetworkError of a call to an address whose circuit breaker is open.
+var ErrCircuitOpen = errors.New("kvclient: circuit breaker open")
+
+// NetworkError
+// Returned by a call that did not get a reply from the server at Addr, because the server could not be reached, or
+// the connection to it broke.
+type NetworkError struct {
+	Addr   string
+	Method string
+	// Whether the request may have reached the server, which then may have applied it.
+	Sent bool
+	Err  error
+}
+
+func (err *NetworkError) Error() string {
+	return fmt.Sprintf("kvclient: %s at %s: %s", err.Method, err.Addr, err.Err)
+}
+
+func (err *NetworkError) Unwrap() error {
+	return err.Err
+}
+
+// ServerError
+// Returned by a call that the server at Addr answered with an error, e.g. because its query actor did not answer in
+// time.
+type ServerError struct {
+	Addr    string
+	Method  string
+	Message string
+}
+
+func (err *ServerError) Error() string {
+	return fmt.Sprintf("kvclient: %s at %s: server error: %s", err.Method, err.Addr, err.Message)
+}
+
+// Unwrap
+// Returns ErrWrongType if the server refused a Put of a key holding a CRDT, ErrBatchSpansOwners if it refused a batch
+// across owners, and nil otherwise.
+func (err *ServerError) Unwrap() error {
+	switch {
+	case strings.HasPrefix(err.Message, kvcommon.WrongType):
+		return ErrWrongType
+	case err.Message == kvcommon.BatchSpansOwners:
+		return ErrBatchSpansOwners
+	}
+	return nil
+}
+
+// RetryPolicy
+// How a Client retries calls that fail with a NetworkError.
+type RetryPolicy struct {
+	// Number of attempts of a call in all; 1 or less makes no retries.
+	Attempts int
+	// Wait before the first retry, doubled before each further one, up to MaxBackoff.
+	Backoff    time.Duration
+	MaxBackoff time.Duration
+}
+
+// DefaultRetryPolicy is the RetryPolicy of a new Client.
+var DefaultRetryPolicy = RetryPolicy{Attempts: 3, Backoff: 20 * time.Millisecond, MaxBackoff: 500 * time.Millisecond}
+
+// BreakerPolicy
+// When the circuit breaker of an address opens, and for how long.
+type BreakerPolicy struct {
+	// Number of network errors in a row that open the breaker; 0 disables circuit breaking.
+	Failures int
+	// Time an open breaker fails calls before letting a probe through.
+	Cooldown time.Duration
+}
+
+// DefaultBreakerPolicy is the BreakerPolicy of a new Client.
+var DefaultBreakerPolicy = BreakerPolicy{Failures: 5, Cooldown: time.Second}
+
+// idempotent holds the methods whose calls may be retried after a request was sent: the reads.
+var idempotent = map[string]bool{
+	"QueryReceiver.Get":         true,
+	"QueryReceiver.List":        true,
+	"QueryReceiver.Scan":        true,
+	"QueryReceiver.MultiGet":    true,
+	"QueryReceiver.GetVersion":  true,
+	"QueryReceiver.GetSiblings": true,
+	"QueryReceiver.GetCRDT":     true,
+}
+
+// retrier
+// The retry policy and circuit breakers of a Client.
+type retrier struct {
+	mux     sync.Mutex
+	retry   RetryPolicy
+	breaker BreakerPolicy
+	// Circuit breakers by address.
+	breakers map[string]*breaker
+}
+
+// breaker
+// The circuit breaker of an address.
+type breaker struct {
+	// Number of network errors in a row.
+	failures int
+	// While open, the time the cooldown ends; zero while closed.
+	openUntil time.Time
+	// Whether a probe is in flight.
+	probing bool
+}
+
+// newRetrier
+// Returns a retrier with the default policies.
+func newRetrier() *retrier {
+	return &retrier{retry: DefaultRetryPolicy, breaker: DefaultBreakerPolicy, breakers: make(map[string]*breaker)}
+}
+
+// SetRetryPolicy
+// Sets how the client retries calls that fail with a NetworkError, DefaultRetryPolicy by default.
+func (client *Client) SetRetryPolicy(policy RetryPolicy) {
+	client.retries.mux.Lock()
+	defer client.retries.mux.Unlock()
+	client.retries.retry = policy
+}
+
+// SetBreakerPolicy
+// Sets when the circuit breakers of the client open, DefaultBreakerPolicy by default.
+func (client *Client) SetBreakerPolicy(policy BreakerPolicy) {
+	client.retries.mux.Lock()
+	defer client.retries.mux.Unlock()
+	client.retries.breaker = policy
+	client.retries.breakers = make(map[string]*breaker)
+}
+
+// policy
+// Returns the retry policy.
+func (r *retrier) policy() RetryPolicy {
+	r.mux.Lock()
+	defer r.mux.Unlock()
+	return r.retry
+}
+
+// allow
+// Returns whether a call to addr may go ahead, or its circuit breaker is open. A call let through as a probe must be
+// followed by record.
+func (r *retrier) allow(addr string) bool {
+	r.mux.Lock()
+	defer r.mux.Unlock()
+	b, ok := r.breakers[addr]
+	if !ok || b.openUntil.IsZero() {
+		return true
+	}
+	if b.probing || time.Now().Before(b.openUntil) {
+		return false
+	}
+	b.probing = true
+	return true
+}
+
+// record
+// Records the outcome of a call to addr that was let through: whether it failed with a network error.
+func (r *retrier) record(addr string, failed bool) {
+	r.mux.Lock()
+	defer r.mux.Unlock()
+	if r.breaker.Failures <= 0 {
+		return
+	}
+	b, ok := r.breakers[addr]
+	if !ok {
+		if !failed {
+			return
+		}
+		b = &breaker{}
+		r.breakers[addr] = b
+	}
+	b.probing = false
+	if !failed {
+		delete(r.breakers, addr)
+		return
+	}
+	b.failures++
+	if b.failures >= r.breaker.Failures {
+		b.openUntil = time.Now().Add(r.breaker.Cooldown)
+	}
+}
+
+// abandon
+// Ends a call to addr that was let through with no outcome, e.g. as its context was done.
+func (r *retrier) abandon(addr string) {
+	r.mux.Lock()
+	defer r.mux.Unlock()
+	if b, ok := r.breakers[addr]; ok {
+		b.probing = false
+	}
+}
+
+// retryable
+// Returns whether a call of method that failed with err may be retried.
+func retryable(method string, err error) bool {
+	var netErr *NetworkError
+	var serverErr *ServerError
+	if errors.As(err, &serverErr) {
+		return strings.HasPrefix(serverErr.Message, kvcommon.NotCaughtUp)
+	} else if !errors.As(err, &netErr) {
+		return false
+	}
+	return !netErr.Sent || idempotent[method]
+}
@@ -0,0 +1,89 @@
+package kvclient
+
+import "github.com/cmu440/kvcommon"
+
+// KeyValue
+// A key and its value, as returned by Scan. See kvcommon.KeyValue.
+type KeyValue = kvcommon.KeyValue
+
+// Scan
+// Returns up to limit entries with keys in [start, end), in key order; an empty end has no bound. Zero limit, or one
+// above the server's maximum, returns the server's maximum.
+//
+// If more keys follow, more is true and next is the key to start the next Scan at. A page may then hold fewer than
+// limit entries, even none, if keys in it were deleted on some replicas but not synced yet.
+//
+// If there is a network error contacting the RPC server indicated by router.NextAddr(), that error is returned instead.
+func (client *Client) Scan(start string, end string, limit int) (entries []KeyValue, next string, more bool, err error) {
+	args := kvcommon.ScanArgs{Start: start, End: end, Limit: limit}
+	reply := kvcommon.ScanReply{}
+	if err := client.call("QueryReceiver.Scan", args, &reply); err != nil {
+		return nil, "", false, err
+	}
+	return reply.Entries, reply.Next, reply.More, nil
+}
+
+// Iterator
+// Iterates over the entries with keys in a range in key order, fetching them a page at a time with Scan.
+//
+// Use it like:
+//
+//	it := client.Iterate(start, end, 100)
+//	for it.Next() {
+//		use(it.Key(), it.Value())
+//	}
+//	if err := it.Err(); err != nil { ... }
+//
+// Pages are fetched from whichever replica the router picks, so entries written during the iteration may or may not
+// be seen. An Iterator is not thread-safe.
+type Iterator struct {
+	client   *Client
+	end      string
+	pageSize int
+	page     []KeyValue
+	// Index of the current entry in page.
+	pos  int
+	next string
+	more bool
+	err  error
+}
+
+// Iterate
+// Returns an Iterator over the entries with keys in [start, end), fetching pageSize entries at a time; an empty end has
+// no bound.
+func (client *Client) Iterate(start string, end string, pageSize int) *Iterator {
+	return &Iterator{client: client, end: end, pageSize: pageSize, pos: -1, next: start, more: true}
+}
+
+// Next
+// Advances to the next entry, fetching the next page if needed. Returns false when there are no more entries, or
+// fetching failed; see Err.
+func (it *Iterator) Next() bool {
+	it.pos++
+	for it.pos >= len(it.page) {
+		if !it.more || it.err != nil {
+			return false
+		}
+		it.page, it.next, it.more, it.err = it.client.Scan(it.next, it.end, it.pageSize)
+		it.pos = 0
+	}
+	return true
+}
+
+// Key
+// Returns the key of the current entry.
+func (it *Iterator) Key() string {
+	return it.page[it.pos].Key
+}
+
+// Value
+// Returns the value of the current entry.
+func (it *Iterator) Value() string {
+	return it.page[it.pos].Value
+}
+
+// Err
+// Returns the error that stopped the iteration, if any.
+func (it *Iterator) Err() error {
+	return it.err
+}
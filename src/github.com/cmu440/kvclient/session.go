@@ -0,0 +1,79 @@
+package kvclient
+
+import (
+	"sync"
+
+	"github.com/cmu440/kvcommon"
+)
+
+// Session
+// A sequence of Gets and Puts with session guarantees for each key, whichever replicas the client's router picks:
+//   - Monotonic reads: a Get never returns an older value than an earlier Get of the key in the session.
+//   - Read-your-writes: a Get returns the session's last Put of the key, or a newer value.
+//   - Monotonic writes and writes-follow-reads: a Put supersedes the session's earlier Puts of the key, and the values
+//     of it the session read.
+//
+// The session keeps the newest Version of each key it has read or written, returned by the server. A Get asks the
+// serving replica to wait until it has that Version or a newer one, and a Put to write a newer one. If a replica does
+// not catch up in time, or cannot be reached, the client retries the call on the next replica, as its RetryPolicy
+// allows.
+//
+// One Version per key covers all replicas, rather than one per key and replica: Versions are ordered the same way on
+// every replica, so a value at least as new as the session's Version is one it may see wherever it is served. Keeping
+// a Version per replica would let a replica the session has not used yet serve an older value.
+//
+// A Session is thread-safe, but concurrent calls in one session are not ordered with each other.
+type Session struct {
+	client *Client
+	mux    sync.Mutex
+	// The newest Version of each key the session has seen.
+	seen map[string]Version
+}
+
+// NewSession
+// Returns a new Session using the client.
+func (client *Client) NewSession() *Session {
+	return &Session{client: client, seen: make(map[string]Version)}
+}
+
+// Get
+// Like Client.Get, with the session's guarantees.
+func (session *Session) Get(key string) (value string, ok bool, err error) {
+	args := kvcommon.GetVersionArgs{Key: key, After: session.version(key)}
+	reply := kvcommon.GetVersionReply{}
+	if err := session.client.call("QueryReceiver.GetVersion", args, &reply); err != nil {
+		return "", false, err
+	}
+	session.observe(key, reply.Token)
+	return reply.Value, reply.Ok, nil
+}
+
+// Put
+// Like Client.Put, with the session's guarantees.
+func (session *Session) Put(key string, value string) error {
+	args := kvcommon.PutArgs{Key: key, Value: value, After: session.version(key)}
+	reply := kvcommon.PutReply{}
+	if err := session.client.call("QueryReceiver.Put", args, &reply); err != nil {
+		return err
+	}
+	session.observe(key, reply.Version)
+	return nil
+}
+
+// version
+// Returns the newest Version of key the session has seen.
+func (session *Session) version(key string) Version {
+	session.mux.Lock()
+	defer session.mux.Unlock()
+	return session.seen[key]
+}
+
+// observe
+// Records that the session has seen version of key.
+func (session *Session) observe(key string, version Version) {
+	session.mux.Lock()
+	defer session.mux.Unlock()
+	if version.Newer(session.seen[key]) {
+		session.seen[key] = version
+	}
+}
@@ -0,0 +1,90 @@
+package kvclient
+
+import "github.com/cmu440/kvcommon"
+
+// Txn
+// An optimistic transaction. Gets read each key from its home replica, noting the Version read, and Puts and Deletes
+// are buffered until Commit. Commit then applies all the writes at once, if none of the keys read changed in the
+// meantime and no other transaction is committing them; otherwise the transaction aborts, and can be retried with a
+// new Txn.
+//
+// Use it like:
+//
+//	for {
+//		tx := client.Begin()
+//		value, ok, err := tx.Get(from)
+//		...
+//		tx.Put(from, ...)
+//		tx.Put(to, ...)
+//		if committed, err := tx.Commit(); committed || err != nil { ... }
+//	}
+//
+// Transactions are isolated from each other, and from plain writes served by a key's home replica, which holds them
+// while a transaction commits; plain writes served by other replicas may still race with a commit. A Txn is not
+// thread-safe, and must not be used after Commit.
+type Txn struct {
+	client *Client
+	// The Version of each key read, from the first Get of it.
+	reads map[string]Version
+	// Buffered writes, in order, and the index of the last one of each key.
+	ops    []BatchOp
+	writes map[string]int
+}
+
+// Begin
+// Returns a new transaction using the client.
+func (client *Client) Begin() *Txn {
+	return &Txn{client: client, reads: make(map[string]Version), writes: make(map[string]int)}
+}
+
+// Get
+// Returns the value associated with key as the transaction sees it: the transaction's own last write of key, if any,
+// or else the value on key's home replica.
+//
+// If there is a network error contacting the RPC server indicated by router.NextAddr(), that error is returned instead.
+func (tx *Txn) Get(key string) (value string, ok bool, err error) {
+	if i, written := tx.writes[key]; written {
+		return tx.ops[i].Value, !tx.ops[i].Delete, nil
+	}
+	args := kvcommon.GetVersionArgs{Key: key, Home: true}
+	reply := kvcommon.GetVersionReply{}
+	if err := tx.client.call("QueryReceiver.GetVersion", args, &reply); err != nil {
+		return "", false, err
+	}
+	if _, read := tx.reads[key]; !read {
+		tx.reads[key] = reply.Token
+	}
+	return reply.Value, reply.Ok, nil
+}
+
+// Put
+// Buffers setting the value associated with key, until Commit.
+func (tx *Txn) Put(key string, value string) {
+	tx.writes[key] = len(tx.ops)
+	tx.ops = append(tx.ops, BatchOp{Key: key, Value: value})
+}
+
+// Delete
+// Buffers removing the value associated with key, until Commit.
+func (tx *Txn) Delete(key string) {
+	tx.writes[key] = len(tx.ops)
+	tx.ops = append(tx.ops, BatchOp{Key: key, Delete: true})
+}
+
+// Commit
+// Applies the transaction's writes, all at once, if none of the keys it read changed since. Returns false if the
+// transaction aborted instead.
+//
+// If there is a network error contacting the RPC server indicated by router.NextAddr(), that error is returned instead;
+// the transaction may or may not have committed.
+func (tx *Txn) Commit() (ok bool, err error) {
+	args := kvcommon.CommitArgs{Reads: make([]kvcommon.TxnRead, 0, len(tx.reads)), Ops: tx.ops}
+	for key, version := range tx.reads {
+		args.Reads = append(args.Reads, kvcommon.TxnRead{Key: key, Version: version})
+	}
+	reply := kvcommon.CommitReply{}
+	if err := tx.client.call("QueryReceiver.Commit", args, &reply); err != nil {
+		return false, err
+	}
+	return reply.Ok, nil
+}
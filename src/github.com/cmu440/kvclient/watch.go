@@ -0,0 +1,106 @@
+package kvclient
+
+import (
+	"sync"
+
+	"github.com/cmu440/kvcommon"
+)
+
+// Number of events a Watcher buffers for its consumer.
+const watchEvents = 100
+
+// Event
+// A change of a watched key, as streamed by a Watcher. See kvcommon.Event.
+type Event = kvcommon.Event
+
+// Watcher
+// Streams the changes of the keys with a prefix that one replica applies, as returned by Watch. The Watcher long-polls
+// the replica from a goroutine of its own.
+//
+// Use it like:
+//
+//	w := client.Watch("loc/", Version{})
+//	defer w.Close()
+//	for event := range w.Events() {
+//		use(event)
+//	}
+//	if err := w.Err(); err != nil { ... }
+type Watcher struct {
+	events chan Event
+	done   chan struct{}
+	close  sync.Once
+	mux    sync.Mutex
+	// The error that stopped the watch, if any.
+	err error
+}
+
+// Watch
+// Starts watching the keys starting with prefix on the replica indicated by router.NextAddr(), and returns a Watcher
+// streaming their changes after Version after: first the current values of the keys changed after it (of all present
+// keys, for the zero Version), then each change the replica applies, whether written there or synced from another
+// replica, in the order applied. To resume a watch, e.g. on another replica after an error, pass the Version of the
+// newest event received.
+//
+// The Watcher buffers up to watchEvents events, and stops polling while its buffer is full. If the replica applies
+// many changes in the meantime, the watch catches up with the keys' current values, so that several changes of a key
+// come as one event.
+func (client *Client) Watch(prefix string, after Version) *Watcher {
+	w := &Watcher{events: make(chan Event, watchEvents), done: make(chan struct{})}
+	args := kvcommon.WatchArgs{Prefix: prefix, After: after}
+	go w.run(client, client.router.NextAddr(), args)
+	return w
+}
+
+// Events
+// Returns the channel of events. It is closed when the watch stops, after Close or an error; see Err.
+func (w *Watcher) Events() <-chan Event {
+	return w.events
+}
+
+// Err
+// Returns the error that stopped the watch: a network error contacting the replica, or nil if the watch was closed
+// or has not stopped.
+func (w *Watcher) Err() error {
+	w.mux.Lock()
+	defer w.mux.Unlock()
+	return w.err
+}
+
+// Close
+// Stops the watch. Events may still deliver the events buffered so far.
+func (w *Watcher) Close() {
+	w.close.Do(func() {
+		close(w.done)
+	})
+}
+
+// run
+// Polls the replica at addr for changes, passing them on to w.events, until the watch is closed or a poll fails.
+func (w *Watcher) run(client *Client, addr string, args kvcommon.WatchArgs) {
+	defer close(w.events)
+	for {
+		select {
+		case <-w.done:
+			return
+		default:
+		}
+		reply := kvcommon.WatchReply{}
+		if err := client.callAt(addr, "QueryReceiver.Watch", args, &reply); err != nil {
+			w.mux.Lock()
+			w.err = err
+			w.mux.Unlock()
+			return
+		}
+		for _, event := range reply.Events {
+			select {
+			case w.events <- event:
+			case <-w.done:
+				return
+			}
+			if event.Version.Newer(args.After) {
+				args.After = event.Version
+			}
+		}
+		args.Actor, args.Cursor = reply.Actor, reply.Cursor
+	}
+}
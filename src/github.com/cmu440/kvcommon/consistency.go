@@ -0,0 +1,38 @@
+package kvcommon
+
+// Consistency level of a Get or Put: how many replicas of the key must answer before the serving query actor answers
+// the client.
+type Consistency int
+
+const (
+	// Only the serving replica; the default.
+	One Consistency = iota
+	// A majority of the key's replicas.
+	Quorum
+	// All of the key's replicas.
+	All
+)
+
+// Required
+// Returns how many of a key's n replicas must answer at consistency level c.
+func (c Consistency) Required(n int) int {
+	switch c {
+	case Quorum:
+		return n/2 + 1
+	case All:
+		return n
+	}
+	return min(1, n)
+}
+
+func (c Consistency) String() string {
+	switch c {
+	case One:
+		return "ONE"
+	case Quorum:
+		return "QUORUM"
+	case All:
+		return "ALL"
+	}
+	return "UNKNOWN"
+}
@@ -3,9 +3,18 @@
 // Package kvcommon includes shared internals for kvclient and kvserver.
 package kvcommon
 
+import "time"
+
 // Args for Get RPC.
 type GetArgs struct {
 	Key string
+	// How many replicas must answer. The zero value is One.
+	Consistency Consistency
+	// A write token from an earlier Put: the serving replica answers only once it has seen that write (or a newer one
+	// of Key). The zero Version does not wait.
+	After Version
+	// When the client stops waiting for the reply, after which the server stops too. The zero Time has no deadline.
+	Deadline time.Time
 }
 
 // Reply for Get RPC.
@@ -17,6 +26,8 @@ type GetReply struct {
 // Args for List RPC.
 type ListArgs struct {
 	Prefix string
+	// Like GetArgs.Deadline.
+	Deadline time.Time
 }
 
 // Reply for List RPC.
@@ -24,14 +35,281 @@ type ListReply struct {
 	Entries map[string]string
 }
 
+// Args for Scan RPC.
+type ScanArgs struct {
+	// The scan covers keys in [Start, End). An empty End has no bound.
+	Start string
+	End   string
+	// Most entries to return. Zero or more than the server's maximum returns the server's maximum.
+	Limit int
+	// Like GetArgs.Deadline.
+	Deadline time.Time
+}
+
+// A key and its value.
+type KeyValue struct {
+	Key   string
+	Value string
+}
+
+// Reply for Scan RPC.
+type ScanReply struct {
+	// Entries in key order.
+	Entries []KeyValue
+	// If More, the keys from Next on were not scanned yet; continue with ScanArgs.Start set to Next.
+	Next string
+	More bool
+}
+
+// Args for MultiGet RPC.
+type MultiGetArgs struct {
+	Keys []string
+	// Like GetArgs.Deadline.
+	Deadline time.Time
+}
+
+// Reply for MultiGet RPC.
+type MultiGetReply struct {
+	// Values of the present keys among MultiGetArgs.Keys.
+	Entries map[string]string
+}
+
+// A change of a key, as applied by a replica: a Put, a Delete (then Deleted is set), or a CRDT update. Version.Origin
+// is the replica that made the change.
+type Event struct {
+	Key     string
+	Value   string
+	Deleted bool
+	Version Version
+}
+
+// Args for Watch RPC.
+type WatchArgs struct {
+	Prefix string
+	// Where to continue: Cursor on the replica Actor, as returned by an earlier Watch. A Watch to another replica, or
+	// one that fell too far behind, instead gets the current values of the keys changed after Version After.
+	Actor  string
+	Cursor int
+	After  Version
+	// Like GetArgs.Deadline.
+	Deadline time.Time
+}
+
+// Reply for Watch RPC.
+type WatchReply struct {
+	// Changes of keys with the prefix, in the order the replica applied them. Empty if there were none for a while.
+	Events []Event
+	// Where the next Watch continues.
+	Actor  string
+	Cursor int
+}
+
 // Args for Put RPC.
 type PutArgs struct {
 	Key   string
 	Value string
+	// How many replicas must acknowledge the write. The zero value is One.
+	Consistency Consistency
+	// A Version the write must supersede, e.g. of values of Key read or written before. The zero Version supersedes
+	// nothing in particular.
+	After Version
+	// How long the value lives before it expires, on every replica. Zero lives forever.
+	TTL time.Duration
+	// Like GetArgs.Deadline. A write whose deadline passes may still be applied.
+	Deadline time.Time
 }
 
 // Reply for Put RPC.
 type PutReply struct {
+	// The written value's Version, a write token for GetArgs.After.
+	Version Version
+}
+
+// A write in a batch: sets Key to Value, or removes Key if Delete.
+type BatchOp struct {
+	Key    string
+	Value  string
+	Delete bool
+	// Like PutArgs.TTL.
+	TTL time.Duration
+}
+
+// Args for ApplyBatch RPC.
+type BatchArgs struct {
+	// If several Ops write the same key, the last one wins.
+	Ops []BatchOp
+	// Like PutArgs.Deadline.
+	Deadline time.Time
+}
+
+// Reply for ApplyBatch RPC.
+type BatchReply struct {
+	// The Version all of the batch's writes were made at, a write token for GetArgs.After of any of their keys.
+	Version Version
+}
+
+// A key a transaction read, and the Version it read: GetVersionReply.Token.
+type TxnRead struct {
+	Key     string
+	Version Version
+}
+
+// Args for Commit RPC.
+type CommitArgs struct {
+	Reads []TxnRead
+	Ops   []BatchOp
+	// Like PutArgs.Deadline.
+	Deadline time.Time
+}
+
+// Reply for Commit RPC.
+type CommitReply struct {
+	// False if the transaction aborted: a key it read changed, or another transaction was committing it.
+	Ok bool
+}
+
+// Args for Delete RPC.
+type DeleteArgs struct {
+	Key string
+	// Like PutArgs.Deadline.
+	Deadline time.Time
+}
+
+// Reply for Delete RPC.
+type DeleteReply struct {
+	// Whether the serving replica had a value for the key.
+	Ok bool
+}
+
+// Args for GetVersion RPC.
+type GetVersionArgs struct {
+	Key string
+	// Like GetArgs.After.
+	After Version
+	// Whether to read from the key's home replica, which validates transactions on it, as transactions read.
+	Home bool
+	// Like GetArgs.Deadline.
+	Deadline time.Time
+}
+
+// Reply for GetVersion RPC.
+type GetVersionReply struct {
+	Value   string
+	Ok      bool
+	Version Version
+	// The Version of the value read, or of the delete that removed it, for a later GetArgs.After or PutArgs.After.
+	// Unlike Version, this is not zero for a deleted key.
+	Token Version
+}
+
+// Reply for PutVersion RPC, which takes PutArgs.
+type PutVersionReply struct {
+	// The written value's Version.
+	Version Version
+}
+
+// Args for GetSiblings RPC.
+type GetSiblingsArgs struct {
+	Key string
+	// Like GetArgs.Deadline.
+	Deadline time.Time
+}
+
+// Reply for GetSiblings RPC.
+type GetSiblingsReply struct {
+	// Values of all concurrent writes, oldest first. Empty if the key is not present.
+	Values []string
+	// Covers all the Values, for a PutWithContext that resolves them.
+	Context CausalContext
+}
+
+// Args for PutWithContext RPC.
+type PutWithContextArgs struct {
+	Key     string
+	Value   string
+	Context CausalContext
+	// Like PutArgs.Deadline.
+	Deadline time.Time
+}
+
+// Args for PutIfAbsent RPC.
+type PutIfAbsentArgs struct {
+	Key   string
+	Value string
+	// Like PutArgs.Deadline.
+	Deadline time.Time
+}
+
+// Args for CompareAndSet RPC.
+type CompareAndSetArgs struct {
+	Key      string
+	Expected string
+	Value    string
+	// Like PutArgs.Deadline.
+	Deadline time.Time
+}
+
+// Args for PutIfVersion RPC.
+type PutIfVersionArgs struct {
+	Key     string
+	Version Version
+	Value   string
+	// Like PutArgs.Deadline.
+	Deadline time.Time
+}
+
+// Reply for PutIfAbsent, CompareAndSet and PutIfVersion RPCs.
+type ConditionalPutReply struct {
+	// Whether the condition held and the value was written.
+	Ok bool
+	// If Ok, the written value's Version. Otherwise the current value, whether the key is present, and its Version.
+	Value   string
+	Present bool
+	Version Version
+}
+
+// Args for Increment RPC.
+type IncrementArgs struct {
+	Key   string
+	Delta int64
+	// Like PutArgs.Deadline.
+	Deadline time.Time
+}
+
+// Args for SetAdd and SetRemove RPCs.
+type SetArgs struct {
+	Key    string
+	Member string
+	// Like PutArgs.Deadline.
+	Deadline time.Time
+}
+
+// Args for MapSet and MapDelete RPCs.
+type MapArgs struct {
+	Key   string
+	Field string
+	Value string
+	// Like PutArgs.Deadline.
+	Deadline time.Time
+}
+
+// Args for GetCRDT RPC.
+type GetCRDTArgs struct {
+	Key string
+	// Like GetArgs.Deadline.
+	Deadline time.Time
+}
+
+// Reply for CRDT RPCs: the key's state after the update.
+type CRDTReply struct {
+	// False if the key holds a value of another type; then nothing was updated.
+	Ok bool
+	// The key's type: "counter", "set" or "map".
+	Type string
+	// Depending on the key's type: the counter's value, the set's sorted members or the map's entries.
+	Counter int64
+	Members []string
+	Entries map[string]string
 }
 
 // Interface for kvclient-kvserver RPC calls.
@@ -42,6 +320,45 @@ type QueryReceiver interface {
 	// Returns all (key, value) pairs whose key starts with prefix, similar
 	// to recursively listing all files in a folder.
 	List(args ListArgs, reply *ListReply) error
-	// Sets the value associated with key.
+	// Returns the first entries with keys in a range, in key order, and where the next ones start.
+	Scan(args ScanArgs, reply *ScanReply) error
+	// Returns the values of the present keys among args.Keys, all read at once from the serving replica.
+	MultiGet(args MultiGetArgs, reply *MultiGetReply) error
+	// Waits for changes of keys starting with args.Prefix, and returns them.
+	Watch(args WatchArgs, reply *WatchReply) error
+	// Sets the value associated with key, and returns the written value's Version once the serving replica wrote it.
 	Put(args PutArgs, reply *PutReply) error
+	// Applies all writes in args.Ops at once: no reader of the serving replica, or of a replica they were synced to,
+	// sees only some of them.
+	ApplyBatch(args BatchArgs, reply *BatchReply) error
+	// Commits a transaction: applies all writes in args.Ops at once, if no key in args.Reads changed since it was read.
+	Commit(args CommitArgs, reply *CommitReply) error
+	// Removes the value associated with key, if any.
+	Delete(args DeleteArgs, reply *DeleteReply) error
+	// Like Get, but also returns the value's Version.
+	GetVersion(args GetVersionArgs, reply *GetVersionReply) error
+	// Like Put, but waits for the write and returns its Version.
+	PutVersion(args PutArgs, reply *PutVersionReply) error
+	// Returns all concurrent values of a multi-value key, and a causal context covering them.
+	GetSiblings(args GetSiblingsArgs, reply *GetSiblingsReply) error
+	// Sets the value associated with key, superseding the siblings that args.Context covers.
+	PutWithContext(args PutWithContextArgs, reply *PutReply) error
+	// Adds args.Delta to the counter at args.Key, creating it at zero if not present.
+	Increment(args IncrementArgs, reply *CRDTReply) error
+	// Adds args.Member to the set at args.Key, creating it if not present.
+	SetAdd(args SetArgs, reply *CRDTReply) error
+	// Removes args.Member from the set at args.Key.
+	SetRemove(args SetArgs, reply *CRDTReply) error
+	// Sets args.Field to args.Value in the map at args.Key, creating it if not present.
+	MapSet(args MapArgs, reply *CRDTReply) error
+	// Removes args.Field from the map at args.Key.
+	MapDelete(args MapArgs, reply *CRDTReply) error
+	// Returns the state of the CRDT at args.Key. reply.Ok is false if the key does not hold one.
+	GetCRDT(args GetCRDTArgs, reply *CRDTReply) error
+	// Sets the value associated with key if the key is not present.
+	PutIfAbsent(args PutIfAbsentArgs, reply *ConditionalPutReply) error
+	// Sets the value associated with key if its current value is args.Expected.
+	CompareAndSet(args CompareAndSetArgs, reply *ConditionalPutReply) error
+	// Sets the value associated with key if its current Version is args.Version.
+	PutIfVersion(args PutIfVersionArgs, reply *ConditionalPutReply) error
 }
@@ -0,0 +1,60 @@
+package kvcommon
+
+import "time"
+
+// Number of low bits of a hybrid logical clock timestamp holding its logical counter.
+const LogicalBits = 16
+
+// Version of a key's value, assigned by the replica that wrote it. Versions are ordered by Timestamp, then Origin
+// (lower wins), the same order last-writer-wins sync uses to pick between replicas' values.
+//
+// The zero Version stands for an absent key.
+type Version struct {
+	// Hybrid logical clock timestamp of the write: milliseconds since the Unix epoch, shifted left by LogicalBits,
+	// plus a logical counter. The writing replica's clock is ahead of every value it has seen, so a write always
+	// supersedes the value it replaces, even under clock skew.
+	Timestamp int64
+	// Identifies the replica that wrote the value, to break ties between replicas.
+	Origin string
+}
+
+// NotCaughtUp starts the message of the error a replica answers a read with when it did not catch up with the read's
+// After Version in time. Such a read changed nothing, so the client may retry it on another replica.
+const NotCaughtUp = "kvserver: replica did not catch up"
+
+// WrongType starts the message of the error a replica answers a Put with when the key holds a CRDT.
+const WrongType = "kvserver: wrong type"
+
+// BatchSpansOwners is the message of the error a replica in partitioned mode answers a batch with when its keys do
+// not all have the same owners.
+const BatchSpansOwners = "kvserver: batch keys have different owners"
+
+// HLCTimestamp
+// Returns the earliest hybrid logical clock timestamp at t.
+func HLCTimestamp(t time.Time) int64 {
+	return t.UnixMilli() << LogicalBits
+}
+
+// Time
+// Returns the physical part of the version's timestamp.
+func (v Version) Time() time.Time {
+	return time.UnixMilli(v.Timestamp >> LogicalBits)
+}
+
+// Newer
+// Returns whether v wins against other under last-writer-wins.
+//
+// As a read-your-writes token: a read whose Version is not older than that of one's own write has seen it.
+func (v Version) Newer(other Version) bool {
+	if v.Timestamp != other.Timestamp {
+		return v.Timestamp > other.Timestamp
+	}
+	return v.Origin < other.Origin
+}
+
+// CausalContext
+// Summarizes the writes to a multi-value key that a client has seen: for each replica (by Version.Origin), the
+// Timestamp of its latest write seen. A write made with a context supersedes every sibling the context has seen.
+//
+// A nil CausalContext has seen nothing.
+type CausalContext map[string]int64
@@ -0,0 +1,435 @@
+package kvserver
+
+import (
+	"encoding/binary"
+	"hash/fnv"
+	"maps"
+	"sort"
+	"time"
+
+	"github.com/cmu440/actor"
+	"github.com/cmu440/kvcommon"
+)
+
+// Anti-entropy
+//
+// Delta sync (SynSignal) sends each change once, so a lost SynMsg would leave replicas diverged forever. To repair
+// that, every Config.AntiEntropyInterval each query actor compares its store with one of the query actors it syncs
+// with, taking turns:
+//  1. The initiator sends the root of its Merkle tree in a MerkleExchange.
+//  2. A query actor receiving a MerkleExchange compares the given nodes with its own. For each differing inner node,
+//     it replies with its hashes of the node's children, and so on down the tree.
+//  3. For differing leaves (buckets of keys), it sends its entries in those buckets, and asks for the peer's.
+//
+// So replicas that agree exchange only roots, and repairs cost bandwidth proportional to the number of differing
+// buckets.
+//
+// Anti-entropy rides along in the AntiEntropy field of the next SynMsg to the peer, and rounds start on SynSignal,
+// rather than in messages of their own, so anti-entropy does not add to the number of messages between query actors.
+//
+// The leaves are kept up to date as entries change (see rehash), so a round hashes only the inner nodes, not the store.
+
+const (
+	// Children per inner node of the Merkle tree.
+	merkleFanout = 16
+	// Levels below the root; leaves are at this level.
+	merkleDepth = 2
+	// Number of leaves (buckets of keys).
+	merkleLeaves = 256 // merkleFanout^merkleDepth
+)
+
+// AntiEntropy
+// Anti-entropy messages from Sender, carried in a SynMsg. The SynMsg also carries the entries Sender was asked for.
+type AntiEntropy struct {
+	Sender    *actor.ActorRef
+	Exchanges []MerkleExchange
+	// Buckets (leaves of the Merkle tree) whose entries Sender asks for.
+	Pull []int
+}
+
+// MerkleExchange
+// The sender's hashes of Nodes at Level of its Merkle tree.
+type MerkleExchange struct {
+	Level  int
+	Nodes  []int
+	Hashes []uint64
+}
+
+// pendingAntiEntropy
+// Anti-entropy to send to Ref with the next sync.
+type pendingAntiEntropy struct {
+	Ref       *actor.ActorRef
+	Exchanges []MerkleExchange
+	Pull      []int
+	// Buckets whose entries to send.
+	Push []int
+}
+
+// merkleBucket
+// Returns the leaf of the Merkle tree holding key.
+func merkleBucket(key string) int {
+	h := fnv.New32a()
+	h.Write([]byte(key))
+	return int(h.Sum32() % merkleLeaves)
+}
+
+// merkleIndex
+// The leaves of the actor's Merkle trees, and the keys in each leaf and of each version, updated on every change of an
+// entry.
+type merkleIndex struct {
+	// Hash of each key's entry, see entryHash.
+	Hashes map[string]uint64
+	// Leaves of the tree of the keys shared with each peer, by Uid. Outside partitioned mode, all keys are shared with
+	// all peers, and there is one tree, under "".
+	Leaves map[string][]uint64
+	// Keys in Store in each bucket.
+	Buckets []map[string]bool
+	// Version (StoreValue.token) of each key in Store, and the keys with each version: the writes of a batch share
+	// their version, see batch.go.
+	Versions map[string]kvcommon.Version
+	Batches  map[kvcommon.Version][]string
+}
+
+// newMerkleIndex
+// Returns the merkleIndex of an empty store.
+func newMerkleIndex() merkleIndex {
+	index := merkleIndex{
+		Hashes:   make(map[string]uint64),
+		Leaves:   make(map[string][]uint64),
+		Buckets:  make([]map[string]bool, merkleLeaves),
+		Versions: make(map[string]kvcommon.Version),
+		Batches:  make(map[kvcommon.Version][]string),
+	}
+	for i := range index.Buckets {
+		index.Buckets[i] = make(map[string]bool)
+	}
+	return index
+}
+
+// merkleTree
+// Returns the hashes of the Merkle tree of the actor's keys shared with peer by level: one root at level 0, down to
+// merkleLeaves leaves at level merkleDepth.
+//
+// A leaf's hash is the sum of the hashes of its keys' entries, so it does not depend on map iteration order, and a
+// change of an entry updates it by the difference.
+func (actor *queryActor) merkleTree(peer string) [][]uint64 {
+	if !actor.partitioned() {
+		peer = ""
+	}
+	tree := make([][]uint64, merkleDepth+1)
+	tree[merkleDepth] = actor.Merkle.Leaves[peer]
+	if tree[merkleDepth] == nil {
+		tree[merkleDepth] = make([]uint64, merkleLeaves)
+	}
+	for level := merkleDepth - 1; level >= 0; level-- {
+		children := tree[level+1]
+		tree[level] = make([]uint64, len(children)/merkleFanout)
+		for i := range tree[level] {
+			b := make([]byte, 0, 8*merkleFanout)
+			for _, child := range children[i*merkleFanout : (i+1)*merkleFanout] {
+				b = binary.LittleEndian.AppendUint64(b, child)
+			}
+			h := fnv.New64a()
+			h.Write(b)
+			tree[level][i] = h.Sum64()
+		}
+	}
+	return tree
+}
+
+// rehash
+// Updates the Merkle index after a change of key's entry.
+func (actor *queryActor) rehash(key string) {
+	index := &actor.Merkle
+	bucket := merkleBucket(key)
+	if version, ok := index.Versions[key]; ok {
+		batch := index.Batches[version]
+		for i := range batch {
+			if batch[i] == key {
+				batch[i] = batch[len(batch)-1]
+				batch = batch[:len(batch)-1]
+				break
+			}
+		}
+		if len(batch) == 0 {
+			delete(index.Batches, version)
+		} else {
+			index.Batches[version] = batch
+		}
+		delete(index.Versions, key)
+		delete(index.Buckets[bucket], key)
+	}
+	if v, ok := actor.Store[key]; ok {
+		index.Versions[key] = v.token()
+		index.Batches[v.token()] = append(index.Batches[v.token()], key)
+		index.Buckets[bucket][key] = true
+	}
+
+	old, hash := index.Hashes[key], actor.entryHash(key)
+	if hash == old {
+		return
+	}
+	if hash == 0 {
+		delete(index.Hashes, key)
+	} else {
+		index.Hashes[key] = hash
+	}
+	peers := []string{""}
+	if actor.partitioned() {
+		peers = peers[:0]
+		for _, owner := range actor.owners(key) {
+			if owner.Uid() != actor.Context.Self.Uid() {
+				peers = append(peers, owner.Uid())
+			}
+		}
+	}
+	for _, peer := range peers {
+		leaves, ok := index.Leaves[peer]
+		if !ok {
+			leaves = make([]uint64, merkleLeaves)
+			index.Leaves[peer] = leaves
+		}
+		// Sums wrap around, so subtracting the old hash undoes adding it.
+		leaves[bucket] += hash - old
+	}
+}
+
+// rebuildMerkle
+// Recomputes the Merkle index from the store, after the keys shared with each peer changed.
+func (actor *queryActor) rebuildMerkle() {
+	actor.Merkle = newMerkleIndex()
+	for key := range actor.Store {
+		actor.rehash(key)
+	}
+}
+
+// entryHash
+// Returns the hash of the actor's entry for key, or zero if it has none.
+func (actor *queryActor) entryHash(key string) uint64 {
+	v, ok := actor.Store[key]
+	if !ok {
+		return 0
+	}
+	b := appendString(nil, key)
+	b = appendString(b, v.Origin)
+	b = binary.AppendVarint(b, v.Timestamp)
+	b = appendBool(b, v.Deleted)
+	siblings := actor.Registers[key]
+	b = binary.AppendUvarint(b, uint64(len(siblings)))
+	for _, s := range siblings {
+		b = appendString(b, s.Value)
+		b = appendBool(b, s.Deleted)
+		b = appendString(b, s.Origin)
+		b = binary.AppendVarint(b, s.Timestamp)
+		b = binary.AppendUvarint(b, uint64(len(s.Context)))
+		for _, origin := range sortedKeys(s.Context) {
+			b = appendString(b, origin)
+			b = binary.AppendVarint(b, s.Context[origin])
+		}
+	}
+	if state, ok := actor.CRDTs[key]; ok {
+		b = appendCRDT(b, state)
+	}
+	h := fnv.New64a()
+	h.Write(b)
+	return h.Sum64()
+}
+
+// appendCRDT
+// Appends the fields of state to b, for entryHash.
+func appendCRDT(b []byte, state CRDT) []byte {
+	b = binary.AppendUvarint(b, uint64(state.Type))
+	b = binary.AppendVarint(b, state.Timestamp)
+	b = appendString(b, state.Origin)
+	switch state.Type {
+	case CounterType:
+		for _, counts := range []map[string]int64{state.Counter.P, state.Counter.N} {
+			b = binary.AppendUvarint(b, uint64(len(counts)))
+			for _, origin := range sortedKeys(counts) {
+				b = appendString(b, origin)
+				b = binary.AppendVarint(b, counts[origin])
+			}
+		}
+	case SetType:
+		b = binary.AppendUvarint(b, uint64(len(state.Set.Adds)))
+		for _, member := range sortedKeys(state.Set.Adds) {
+			b = appendString(b, member)
+			b = appendTags(b, state.Set.Adds[member])
+		}
+		b = appendTags(b, state.Set.Removed)
+	case MapType:
+		b = binary.AppendUvarint(b, uint64(len(state.Map.Fields)))
+		for _, field := range sortedKeys(state.Map.Fields) {
+			f := state.Map.Fields[field]
+			b = appendString(b, field)
+			b = appendString(b, f.Value)
+			b = appendBool(b, f.Deleted)
+			b = binary.AppendVarint(b, f.Timestamp)
+			b = appendString(b, f.Origin)
+		}
+	}
+	return b
+}
+
+// appendTags
+// Appends the tags of an ORSet to b, sorted.
+func appendTags(b []byte, tags map[string]bool) []byte {
+	b = binary.AppendUvarint(b, uint64(len(tags)))
+	for _, tag := range sortedKeys(tags) {
+		b = appendString(b, tag)
+	}
+	return b
+}
+
+// appendString
+// Appends s to b, after its length, so that consecutive strings cannot run together.
+func appendString(b []byte, s string) []byte {
+	return append(binary.AppendUvarint(b, uint64(len(s))), s...)
+}
+
+// appendBool
+// Appends one byte for v to b.
+func appendBool(b []byte, v bool) []byte {
+	if v {
+		return append(b, 1)
+	}
+	return append(b, 0)
+}
+
+// sortedKeys
+// Returns the keys of m, sorted.
+func sortedKeys[V any](m map[string]V) []string {
+	keys := make([]string, 0, len(m))
+	for key := range m {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+// entriesIn
+// Returns a SynMsg with the actor's entries in the given buckets that are shared with peer, and the other entries
+// written in the same batches.
+func (actor *queryActor) entriesIn(buckets []int, peer string) SynMsg {
+	syn := SynMsg{
+		Data:      make(map[string]MPut),
+		Registers: make(map[string][]Sibling),
+		CRDTs:     make(map[string]CRDT),
+	}
+	versions := make(map[kvcommon.Version]bool)
+	for _, bucket := range buckets {
+		for key := range actor.Merkle.Buckets[bucket] {
+			version := actor.Merkle.Versions[key]
+			if versions[version] || !actor.owns(peer, key) {
+				continue
+			}
+			versions[version] = true
+			for _, other := range actor.Merkle.Batches[version] {
+				if actor.owns(peer, other) {
+					actor.addEntry(&syn, other)
+				}
+			}
+		}
+	}
+	return syn
+}
+
+// pendingFor
+// Returns the anti-entropy pending for ref, adding it if there is none.
+func (actor *queryActor) pendingFor(ref *actor.ActorRef) *pendingAntiEntropy {
+	p, ok := actor.AntiEntropyOut[ref.Uid()]
+	if !ok {
+		p = &pendingAntiEntropy{Ref: ref}
+		actor.AntiEntropyOut[ref.Uid()] = p
+	}
+	return p
+}
+
+// startAntiEntropy
+// Starts an anti-entropy round with the next of the query actors the actor syncs with, if Config.AntiEntropyInterval
+// has passed since the last round.
+func (actor *queryActor) startAntiEntropy() {
+	interval := actor.Config.AntiEntropyInterval
+	if interval <= 0 || time.Since(actor.AntiEntropyLast) < interval {
+		return
+	}
+	actor.AntiEntropyLast = time.Now()
+
+	// The capacity limit makes append copy instead of overwriting ActorsInfo.
+	peers := append(actor.ActorsInfo[:actor.Me:actor.Me], actor.ActorsInfo[actor.Me+1:]...)
+	for _, remote := range actor.RemoteInfo {
+		if actor.partitioned() {
+			// Remote query actors hold different keys.
+			peers = append(peers, remote...)
+		} else {
+			peers = append(peers, remote[0])
+		}
+	}
+	if len(peers) == 0 {
+		return
+	}
+	peer := peers[actor.AntiEntropyNext%len(peers)]
+	actor.AntiEntropyNext++
+	root := MerkleExchange{Level: 0, Nodes: []int{0}, Hashes: []uint64{actor.merkleTree(peer.Uid())[0][0]}}
+	p := actor.pendingFor(peer)
+	p.Exchanges = append(p.Exchanges, root)
+}
+
+// onAntiEntropy
+// Handles anti-entropy received in a SynMsg, after its entries have been applied.
+func (actor *queryActor) onAntiEntropy(m *AntiEntropy) {
+	p := actor.pendingFor(m.Sender)
+	p.Push = append(p.Push, m.Pull...)
+	defer func() {
+		if len(p.Exchanges) == 0 && len(p.Pull) == 0 && len(p.Push) == 0 {
+			delete(actor.AntiEntropyOut, m.Sender.Uid())
+		}
+	}()
+	if len(m.Exchanges) == 0 {
+		return
+	}
+
+	tree := actor.merkleTree(m.Sender.Uid())
+	for _, exchange := range m.Exchanges {
+		differing := make([]int, 0)
+		for i, node := range exchange.Nodes {
+			if tree[exchange.Level][node] != exchange.Hashes[i] {
+				differing = append(differing, node)
+			}
+		}
+		if len(differing) == 0 {
+			continue
+		}
+		if exchange.Level == merkleDepth {
+			p.Push = append(p.Push, differing...)
+			p.Pull = append(p.Pull, differing...)
+			continue
+		}
+		reply := MerkleExchange{Level: exchange.Level + 1}
+		for _, node := range differing {
+			for child := node * merkleFanout; child < (node+1)*merkleFanout; child++ {
+				reply.Nodes = append(reply.Nodes, child)
+				reply.Hashes = append(reply.Hashes, tree[exchange.Level+1][child])
+			}
+		}
+		p.Exchanges = append(p.Exchanges, reply)
+	}
+}
+
+// withAntiEntropy
+// Returns the sync message syn for ref with the anti-entropy pending for ref, if any, added; and clears it.
+func (actor *queryActor) withAntiEntropy(ref *actor.ActorRef, syn SynMsg) SynMsg {
+	p, ok := actor.AntiEntropyOut[ref.Uid()]
+	if !ok {
+		return syn
+	}
+	delete(actor.AntiEntropyOut, ref.Uid())
+
+	// The entries pushed and those in syn are both current, so they agree on keys in both.
+	result := actor.entriesIn(p.Push, ref.Uid())
+	maps.Copy(result.Data, syn.Data)
+	maps.Copy(result.Registers, syn.Registers)
+	maps.Copy(result.CRDTs, syn.CRDTs)
+	result.AntiEntropy = &AntiEntropy{actor.Context.Self, p.Exchanges, p.Pull}
+	return result
+}
@@ -0,0 +1,150 @@
+package kvserver
+
+import (
+	"github.com/cmu440/actor"
+	"github.com/cmu440/kvcommon"
+)
+
+// Batches
+//
+// A query actor applies all writes of a batch while handling one MBatch, at the same timestamp, so none of its readers
+// sees only some of them. The writes enter the logs together and so go out in the same SynMsg, which the recipients
+// merge while handling one message too; in gossip mode they are gossiped for the same rounds. Anti-entropy sends all
+// entries written at one version together (see entriesIn), so a replica repairing a lost sync gets the whole batch.
+//
+// In partitioned mode, the actor that receives a batch applies it and hands its writes off to their keys' owners right
+// away, each owner getting all of them in one SynMsg. A batch whose keys do not all have the same owners is refused
+// with a SpansOwners, as its owners would get their parts in separate messages and could each apply only theirs.
+
+// MBatch is the message type for batch write requests.
+type MBatch struct {
+	Ops    []kvcommon.BatchOp
+	Sender *actor.ActorRef
+}
+
+// BatchResult is the message type for MBatch responses.
+type BatchResult struct {
+	Version kvcommon.Version
+}
+
+// SpansOwners is the message type for MBatch responses in partitioned mode when the batch's keys do not all have the
+// same owners.
+type SpansOwners struct{}
+
+func (m SpansOwners) Error() string {
+	return kvcommon.BatchSpansOwners
+}
+
+// MMultiGet is the message type for requests for the values of several keys.
+type MMultiGet struct {
+	Keys   []string
+	Sender *actor.ActorRef
+}
+
+// MultiGetResult is the message type for MMultiGet responses: the values of the present keys.
+type MultiGetResult struct {
+	Entries map[string]string
+}
+
+// applyBatch
+// Writes the ops of m and answers with their Version, unless their keys span owners in partitioned mode. Held while
+// a prepared transaction locks any of the keys.
+func (actor *queryActor) applyBatch(m MBatch) {
+	if actor.partitioned() && !actor.sameOwners(m.Ops) {
+		actor.Context.Tell(m.Sender, SpansOwners{})
+		return
+	}
+	keys := make([]string, len(m.Ops))
+	for i, op := range m.Ops {
+		keys[i] = op.Key
+	}
+	if actor.holdIfLocked(m, keys...) {
+		return
+	}
+	timestamp := actor.writeBatch(m.Ops)
+	version := kvcommon.Version{Timestamp: timestamp, Origin: actor.Context.Self.Uid()}
+	actor.Context.Tell(m.Sender, BatchResult{Version: version})
+}
+
+// writeBatch
+// Writes ops, all at one new timestamp, and returns the timestamp. If several ops write the same key, the last one
+// wins. Like Put, writes to keys holding CRDTs are dropped.
+func (actor *queryActor) writeBatch(ops []kvcommon.BatchOp) int64 {
+	timestamp := actor.Clock.now()
+	actor.writeBatchAt(ops, timestamp)
+	return timestamp
+}
+
+// writeBatchAt
+// Like writeBatch, but writes at the given timestamp, which the actor's clock must have observed. Writing the same ops
+// at the same timestamp again changes nothing: the writes have the same versions, which last-writer-wins keeps once,
+// and siblings the actor already has, or had and saw superseded, are skipped.
+func (actor *queryActor) writeBatchAt(ops []kvcommon.BatchOp, timestamp int64) {
+	last := make(map[string]int)
+	for i, op := range ops {
+		last[op.Key] = i
+	}
+	dot := Sibling{Origin: actor.Context.Self.Uid(), Timestamp: timestamp}
+	keys := make(map[string]bool)
+	for i, op := range ops {
+		if last[op.Key] != i {
+			continue
+		}
+		if siblings, ok := actor.Registers[op.Key]; ok && covers(registerContext(siblings), dot) {
+			continue
+		}
+		data := MPut{Key: op.Key, Value: op.Value, Deleted: op.Delete, TTL: op.TTL}
+		if op.Delete {
+			data.Context = registerContext(actor.Registers[op.Key])
+		}
+		actor.writeAt(data, timestamp)
+		keys[op.Key] = true
+	}
+	if actor.partitioned() {
+		actor.sendToOwners(keys)
+	}
+}
+
+// sameOwners
+// Returns whether the keys of ops all have the same owners.
+func (actor *queryActor) sameOwners(ops []kvcommon.BatchOp) bool {
+	owners := make(map[string]bool)
+	for i, op := range ops {
+		refs := actor.owners(op.Key)
+		for _, ref := range refs {
+			if i > 0 && !owners[ref.Uid()] {
+				return false
+			}
+			owners[ref.Uid()] = true
+		}
+		if len(refs) != len(owners) {
+			return false
+		}
+	}
+	return true
+}
+
+// keyEntries
+// Returns the actor's entries for keys, tombstones included.
+func (actor *queryActor) keyEntries(keys []string) map[string]StoreValue {
+	entries := make(map[string]StoreValue)
+	for _, key := range keys {
+		actor.expireIfDue(key)
+		if v, ok := actor.Store[key]; ok {
+			entries[key] = v
+		}
+	}
+	return entries
+}
+
+// multiGetResult
+// Returns the MultiGetResult for entries, skipping tombstones.
+func multiGetResult(entries map[string]StoreValue) MultiGetResult {
+	result := MultiGetResult{Entries: make(map[string]string)}
+	for key, v := range entries {
+		if !v.Deleted {
+			result.Entries[key] = v.Value
+		}
+	}
+	return result
+}
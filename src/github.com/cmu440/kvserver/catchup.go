@@ -0,0 +1,90 @@
+package kvserver
+
+import (
+	"fmt"
+
+	"github.com/cmu440/kvcommon"
+)
+
+// Read-your-writes
+//
+// A Put answers with the Version it wrote, which the client can pass to a later Get as MGet.After, possibly on another
+// query actor. That query actor answers the Get only once its value of the key is at least as new as After, waiting
+// for syncs for up to Config.CatchUpTimeout, and otherwise answers with a CatchUpFailed. A Get with MGet.Versioned
+// answers with the Version of the value it read as a token for later requests too.
+//
+// A Put with MPut.After first advances the actor's clock past it, so the write supersedes that Version even if it was
+// written on another query actor with a clock ahead of this one.
+
+// ReadTimeout
+// The message type for giving up waiting for the actor to catch up with Get request ID.
+type ReadTimeout struct {
+	ID int
+}
+
+// CatchUpFailed
+// The message type for Get responses when the actor did not catch up with MGet.After in time.
+type CatchUpFailed struct {
+	Key   string
+	After kvcommon.Version
+}
+
+func (m CatchUpFailed) Error() string {
+	return fmt.Sprintf("%s with version %d@%s of %q in time", kvcommon.NotCaughtUp, m.After.Timestamp, m.After.Origin,
+		m.Key)
+}
+
+// token
+// Returns the version of the stored value v, tombstones included, or the zero Version if there is none. Unlike
+// version, this is also newer than the values a delete superseded.
+func (v StoreValue) token() kvcommon.Version {
+	return kvcommon.Version{Timestamp: v.Timestamp, Origin: v.Origin}
+}
+
+// caughtUp
+// Returns whether the actor's value of key is at least as new as version after. Every value is as new as the zero
+// Version.
+//
+// A key the actor has no value of has caught up with versions older than the tombstone cutoff: by then, a delete or
+// expiry of key may have been collected, leaving nothing newer behind.
+func (actor *queryActor) caughtUp(key string, after kvcommon.Version) bool {
+	if after == (kvcommon.Version{}) {
+		return true
+	}
+	v, ok := actor.Store[key]
+	if !ok {
+		return after.Timestamp < actor.tombstoneCutoff()
+	}
+	return !isNewer(MPut{Origin: after.Origin, Timestamp: after.Timestamp}, v)
+}
+
+// waitForCatchUp
+// Holds Get request m until the actor catches up with m.After.
+func (actor *queryActor) waitForCatchUp(m MGet) {
+	id := actor.NextRead
+	actor.NextRead++
+	actor.Reads[id] = m
+	actor.Context.TellAfter(actor.Context.Self, ReadTimeout{id}, actor.Config.CatchUpTimeout)
+}
+
+// checkReads
+// Serves the held Get requests the actor has caught up with.
+func (actor *queryActor) checkReads() {
+	for id, m := range actor.Reads {
+		if actor.caughtUp(m.Key, m.After) {
+			delete(actor.Reads, id)
+			actor.get(m)
+		}
+	}
+}
+
+// onReadTimeout
+// Fails held Get request id.
+func (actor *queryActor) onReadTimeout(id int) {
+	m, ok := actor.Reads[id]
+	if !ok {
+		return
+	}
+	delete(actor.Reads, id)
+	actor.Context.Tell(m.Sender, CatchUpFailed{m.Key, m.After})
+}
@@ -0,0 +1,116 @@
+package kvserver
+
+import (
+	"strings"
+	"time"
+)
+
+// ConflictMode
+// How replicas resolve concurrent writes to a key.
+type ConflictMode int
+
+const (
+	// Keep the write with the newest version; the default.
+	LastWriterWins ConflictMode = iota
+	// Keep all concurrent writes as siblings, tracked with version vectors, until a write that has seen them (via
+	// kvclient.Client.GetSiblings and PutWithContext) resolves them.
+	MultiValue
+)
+
+// FsyncPolicy
+// When a query actor's writes to Config.DataDir are made durable with fsync.
+type FsyncPolicy int
+
+const (
+	// Fsync after every sync (100ms), so a crash loses at most the writes since; the default.
+	FsyncInterval FsyncPolicy = iota
+	// Fsync every write before answering it. Slowest, but loses nothing acknowledged.
+	FsyncAlways
+	// Never fsync, leaving it to the operating system. Survives server crashes, but not machine crashes.
+	FsyncNever
+)
+
+// Config
+// Optional server settings, passed to NewServerWithConfig and on to every query actor in its Init message.
+//
+// All servers in one key-value store should use the same Config.
+type Config struct {
+	// How long a deleted key's tombstone is kept before being garbage collected.
+	//
+	// Until then, the tombstone is synced like a Put so that the delete wins against older Puts of the key on every
+	// replica. The grace period must be longer than it takes a delete to reach all replicas; otherwise an older Put
+	// arriving after the tombstone is collected resurrects the key.
+	TombstoneGrace time.Duration
+
+	// Conflict mode per key prefix. A key uses the mode of its longest matching prefix, or LastWriterWins if none
+	// matches.
+	ConflictModes map[string]ConflictMode
+
+	// How often each query actor runs anti-entropy with another query actor, comparing their stores by Merkle tree
+	// and exchanging the entries that differ. This repairs replicas that missed a sync message. Zero disables it.
+	AntiEntropyInterval time.Duration
+
+	// Settings for spreading updates by gossip instead of broadcast. The zero value keeps broadcast.
+	Gossip Gossip
+
+	// Number of query actors holding each key, placed by consistent hashing over the query actors of all servers; see
+	// partition.go. Zero holds every key on every query actor. Gossip is not used with Replicas set.
+	Replicas int
+
+	// How long a query actor serving a Get or Put above consistency level One waits for the key's other replicas.
+	ConsistencyTimeout time.Duration
+	// How long a query actor holds a Get with a write token it has not caught up with; see catchup.go.
+	CatchUpTimeout time.Duration
+	// How long a transaction's coordinator waits for its participants' votes and acknowledgements; see txn.go.
+	// Participants hold a transaction's locks for at most twice that.
+	TxnTimeout time.Duration
+	// How long an RPC server waits for its query actor to answer a request before failing it. Should be longer
+	// than ConsistencyTimeout, CatchUpTimeout and TxnTimeout.
+	RequestTimeout time.Duration
+
+	// Directory to keep query actors' state in, each in a subdirectory of its own. A server started with the DataDir
+	// and query actor count of an earlier one recovers the earlier one's state. Empty keeps state in memory only.
+	DataDir string
+	// When writes to DataDir are fsynced.
+	Fsync FsyncPolicy
+	// Number of writes logged to DataDir between snapshots of a query actor's state. Zero never takes snapshots.
+	SnapshotEvery int
+}
+
+// Gossip
+// Settings for epidemic gossip; see gossip.go.
+type Gossip struct {
+	// Number of random peers each query actor sends its updates to per sync. Zero disables gossip.
+	Fanout int
+	// Number of syncs each query actor keeps gossiping an update for after learning of it. More rounds reach all query
+	// actors with higher probability, at the cost of more bytes per sync.
+	Rounds int
+	// Whether peers picked also send their updates back with their next sync.
+	PushPull bool
+}
+
+// DefaultConfig
+// Returns the Config used by NewServer.
+func DefaultConfig() Config {
+	return Config{
+		TombstoneGrace:      time.Minute,
+		AntiEntropyInterval: time.Second,
+		ConsistencyTimeout:  time.Second,
+		CatchUpTimeout:      time.Second,
+		TxnTimeout:          time.Second,
+		RequestTimeout:      5 * time.Second,
+		SnapshotEvery:       10000,
+	}
+}
+
+// conflictMode
+// Returns the conflict mode for key.
+func (config Config) conflictMode(key string) ConflictMode {
+	mode, longest := LastWriterWins, -1
+	for prefix, m := range config.ConflictModes {
+		if len(prefix) > longest && strings.HasPrefix(key, prefix) {
+			mode, longest = m, len(prefix)
+		}
+	}
+	return mode
+}
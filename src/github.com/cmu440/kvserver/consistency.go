@@ -0,0 +1,202 @@
+package kvserver
+
+import (
+	"fmt"
+
+	"github.com/cmu440/actor"
+	"github.com/cmu440/kvcommon"
+)
+
+// Consistency levels
+//
+// A Get or Put at a kvcommon.Consistency above One is coordinated by the query actor serving it, with the other
+// replicas of its key: the key's owners in partitioned mode, all query actors otherwise.
+//   - The coordinator sends each replica an MReplica. For a Put, it writes the value first, and the MReplica carries
+//     the key's entry for the replica to merge.
+//   - Each replica answers with its entry for the key, which the coordinator merges into its own, so it answers a Get
+//     with the newest value among the replicas heard from.
+//   - The coordinator answers the client once Consistency.Required replicas, itself included, have answered, or with
+//     a ConsistencyFailed after Config.ConsistencyTimeout. A failed Put stays written on the replicas it reached, and
+//     is synced from there as usual.
+//   - Read-repair: once all replicas answered, or at the timeout, the coordinator sends its entry to the replicas that
+//     answered with a different one.
+
+// MReplica
+// The message type for asking a replica for its entry for Key, after merging Syn into its own.
+type MReplica struct {
+	ID     int
+	Key    string
+	Syn    SynMsg
+	Sender *actor.ActorRef
+}
+
+// ReplicaResult
+// The message type for MReplica responses: the replica's entry and its hash, from queryActor.entryHash.
+type ReplicaResult struct {
+	ID   int
+	Uid  string
+	Syn  SynMsg
+	Hash uint64
+}
+
+// ConsistencyTimeout
+// The message type for giving up waiting for replicas to answer request ID.
+type ConsistencyTimeout struct {
+	ID int
+}
+
+// ConsistencyFailed
+// The message type for Get and Put responses when too few replicas answered in time.
+type ConsistencyFailed struct {
+	Level    kvcommon.Consistency
+	Answered int
+	Required int
+}
+
+func (m ConsistencyFailed) Error() string {
+	return fmt.Sprintf("kvserver: %d of %d replicas answered in time for consistency level %s", m.Answered, m.Required,
+		m.Level)
+}
+
+// pendingRequest
+// A Get or Put coordinated with the other replicas of Key.
+type pendingRequest struct {
+	Key    string
+	Sender *actor.ActorRef
+	// The answer to a Put, or the MGet of a Get, answered from the actor's Store.
+	Result   any
+	Level    kvcommon.Consistency
+	Required int
+	// Replicas that answered, the actor included.
+	Answered int
+	Done     bool
+	// The other replicas, and the hashes of the entries of those that answered, by Uid.
+	Replicas []*actor.ActorRef
+	Hashes   map[string]uint64
+}
+
+// replicasOf
+// Returns the query actors other than the actor itself holding key.
+func (actor *queryActor) replicasOf(key string) []*actor.ActorRef {
+	all := actor.allActors()
+	if actor.partitioned() {
+		all = actor.owners(key)
+	}
+	others := all[:0:0]
+	for _, ref := range all {
+		if ref.Uid() != actor.Context.Self.Uid() {
+			others = append(others, ref)
+		}
+	}
+	return others
+}
+
+// entrySyn
+// Returns a SynMsg with the actor's entry for key, if it has one.
+func (actor *queryActor) entrySyn(key string) SynMsg {
+	syn := SynMsg{
+		Data:      make(map[string]MPut),
+		Registers: make(map[string][]Sibling),
+		CRDTs:     make(map[string]CRDT),
+	}
+	actor.expireIfDue(key)
+	if _, ok := actor.Store[key]; ok {
+		actor.addEntry(&syn, key)
+	}
+	return syn
+}
+
+// coordinate
+// Starts coordinating a Get (result its MGet) or a Put already written (result its PutResult) of key at level with
+// the other replicas of key.
+func (actor *queryActor) coordinate(key string, level kvcommon.Consistency, sender *actor.ActorRef, result any) {
+	id := actor.NextRequest
+	actor.NextRequest++
+	replicas := actor.replicasOf(key)
+	actor.Requests[id] = &pendingRequest{
+		Key:      key,
+		Sender:   sender,
+		Result:   result,
+		Level:    level,
+		Required: level.Required(len(replicas) + 1),
+		Answered: 1,
+		Replicas: replicas,
+		Hashes:   make(map[string]uint64),
+	}
+
+	var syn SynMsg
+	if _, ok := result.(PutResult); ok {
+		syn = actor.entrySyn(key)
+	}
+	for _, ref := range replicas {
+		actor.Context.Tell(ref, MReplica{id, key, syn, actor.Context.Self})
+	}
+	actor.checkRequest(id)
+	if _, ok := actor.Requests[id]; ok {
+		actor.Context.TellAfter(actor.Context.Self, ConsistencyTimeout{id}, actor.Config.ConsistencyTimeout)
+	}
+}
+
+// onReplica
+// Merges the entry m carries and answers with the actor's own.
+func (actor *queryActor) onReplica(m MReplica) {
+	actor.merge(m.Syn)
+	self := actor.Context.Self.Uid()
+	actor.Context.Tell(m.Sender, ReplicaResult{m.ID, self, actor.entrySyn(m.Key), actor.entryHash(m.Key)})
+}
+
+// onReplicaResult
+// Merges a replica's entry into the actor's and counts its answer.
+func (actor *queryActor) onReplicaResult(m ReplicaResult) {
+	p, ok := actor.Requests[m.ID]
+	if !ok {
+		return
+	}
+	actor.merge(m.Syn)
+	p.Hashes[m.Uid] = m.Hash
+	p.Answered++
+	actor.checkRequest(m.ID)
+}
+
+// checkRequest
+// Answers request id once enough replicas answered, and repairs them once all did.
+func (actor *queryActor) checkRequest(id int) {
+	p := actor.Requests[id]
+	if !p.Done && p.Answered >= p.Required {
+		p.Done = true
+		result := p.Result
+		if get, ok := result.(MGet); ok {
+			result = actor.getResult(get)
+		}
+		actor.Context.Tell(p.Sender, result)
+	}
+	if p.Answered == len(p.Replicas)+1 {
+		actor.repair(id)
+	}
+}
+
+// onConsistencyTimeout
+// Fails request id if too few replicas answered, and repairs those that did.
+func (actor *queryActor) onConsistencyTimeout(id int) {
+	p, ok := actor.Requests[id]
+	if !ok {
+		return
+	}
+	if !p.Done {
+		actor.Context.Tell(p.Sender, ConsistencyFailed{p.Level, p.Answered, p.Required})
+	}
+	actor.repair(id)
+}
+
+// repair
+// Finishes request id, sending the actor's entry to the replicas that answered with a different one.
+func (actor *queryActor) repair(id int) {
+	p := actor.Requests[id]
+	delete(actor.Requests, id)
+	hash := actor.entryHash(p.Key)
+	for _, ref := range p.Replicas {
+		if h, ok := p.Hashes[ref.Uid()]; ok && h != hash {
+			actor.Context.Tell(ref, actor.entrySyn(p.Key))
+		}
+	}
+}
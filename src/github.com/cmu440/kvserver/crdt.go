@@ -0,0 +1,282 @@
+package kvserver
+
+import (
+	"encoding/json"
+	"fmt"
+	"maps"
+	"sort"
+	"strconv"
+
+	"github.com/cmu440/kvcommon"
+)
+
+// CRDTType
+// The kind of conflict-free replicated data type a key holds.
+type CRDTType int
+
+const (
+	// A PN-counter, shown by Get as a decimal integer.
+	CounterType CRDTType = iota + 1
+	// An observed-remove set of strings, shown by Get as a sorted JSON array.
+	SetType
+	// A map from strings to strings with last-writer-wins fields, shown by Get as a JSON object.
+	MapType
+)
+
+// String
+// Returns the type's name: "counter", "set" or "map".
+func (t CRDTType) String() string {
+	switch t {
+	case CounterType:
+		return "counter"
+	case SetType:
+		return "set"
+	case MapType:
+		return "map"
+	}
+	return ""
+}
+
+// WrongType
+// The message type for Put responses when the key holds a CRDT, which only the CRDT operations update.
+type WrongType struct {
+	Key  string
+	Type CRDTType
+}
+
+func (m WrongType) Error() string {
+	return fmt.Sprintf("%s: %q holds a %s", kvcommon.WrongType, m.Key, m.Type)
+}
+
+// CRDT
+// The state of a key holding a CRDT. Only the field for Type is used.
+//
+// Replicas sync full states and merge them with mergeCRDTs, which is commutative, associative and idempotent, so all
+// replicas converge no matter the order or repetition of syncs.
+type CRDT struct {
+	Type    CRDTType
+	Counter PNCounter
+	Set     ORSet
+	Map     LWWMap
+	// Version of the newest update, for the value shown in Store.
+	Timestamp int64
+	Origin    string
+}
+
+// PNCounter
+// A counter as the sum of per-replica increments P minus per-replica decrements N.
+type PNCounter struct {
+	P map[string]int64
+	N map[string]int64
+}
+
+// ORSet
+// An observed-remove set. Each add of a member gets a unique tag; a remove removes the tags it has observed, so an add
+// concurrent with a remove wins.
+type ORSet struct {
+	// Member -> tags of its adds.
+	Adds map[string]map[string]bool
+	// Tags of removed adds.
+	Removed map[string]bool
+}
+
+// LWWMap
+// A map whose fields are independent last-writer-wins registers.
+type LWWMap struct {
+	Fields map[string]LWWField
+}
+
+// LWWField
+// A field of an LWWMap; Deleted fields are tombstones.
+type LWWField struct {
+	Value     string
+	Deleted   bool
+	Timestamp int64
+	Origin    string
+}
+
+// newer
+// Returns whether f wins against other under last-writer-wins.
+func (f LWWField) newer(other LWWField) bool {
+	if f.Timestamp != other.Timestamp {
+		return f.Timestamp > other.Timestamp
+	}
+	return f.Origin < other.Origin
+}
+
+// increment
+// Adds delta to the counter, as replica origin.
+func (c *PNCounter) increment(origin string, delta int64) {
+	if c.P == nil {
+		c.P, c.N = make(map[string]int64), make(map[string]int64)
+	}
+	if delta >= 0 {
+		c.P[origin] += delta
+	} else {
+		c.N[origin] -= delta
+	}
+}
+
+// value
+// Returns the counter's value.
+func (c PNCounter) value() int64 {
+	var sum int64
+	for _, p := range c.P {
+		sum += p
+	}
+	for _, n := range c.N {
+		sum -= n
+	}
+	return sum
+}
+
+// add
+// Adds member to the set, with a tag unique to this add.
+func (s *ORSet) add(member string, tag string) {
+	if s.Adds == nil {
+		s.Adds, s.Removed = make(map[string]map[string]bool), make(map[string]bool)
+	}
+	if s.Adds[member] == nil {
+		s.Adds[member] = make(map[string]bool)
+	}
+	s.Adds[member][tag] = true
+}
+
+// remove
+// Removes member's adds observed so far from the set.
+func (s *ORSet) remove(member string) {
+	for tag := range s.Adds[member] {
+		s.Removed[tag] = true
+	}
+}
+
+// members
+// Returns the set's members, sorted.
+func (s ORSet) members() []string {
+	members := make([]string, 0)
+	for member, tags := range s.Adds {
+		for tag := range tags {
+			if !s.Removed[tag] {
+				members = append(members, member)
+				break
+			}
+		}
+	}
+	sort.Strings(members)
+	return members
+}
+
+// set
+// Writes field of the map, if f is newer than its current value.
+func (m *LWWMap) set(field string, f LWWField) {
+	if m.Fields == nil {
+		m.Fields = make(map[string]LWWField)
+	}
+	if old, ok := m.Fields[field]; !ok || f.newer(old) {
+		m.Fields[field] = f
+	}
+}
+
+// entries
+// Returns the map's fields that are not deleted.
+func (m LWWMap) entries() map[string]string {
+	entries := make(map[string]string)
+	for field, f := range m.Fields {
+		if !f.Deleted {
+			entries[field] = f.Value
+		}
+	}
+	return entries
+}
+
+// mergeCRDTs
+// Returns the merge of two replicas' states of a key.
+//
+// The zero CRDT is the empty state. If the replicas created the key with different types concurrently, the lower type
+// wins everywhere.
+func mergeCRDTs(a CRDT, b CRDT) CRDT {
+	if a.Type == 0 {
+		a, b = b, a
+	}
+	if a.Type != b.Type && b.Type != 0 {
+		if a.Type < b.Type {
+			return a
+		}
+		return b
+	}
+	merged := CRDT{Type: a.Type, Timestamp: a.Timestamp, Origin: a.Origin}
+	if (LWWField{Timestamp: b.Timestamp, Origin: b.Origin}).newer(LWWField{Timestamp: a.Timestamp, Origin: a.Origin}) {
+		merged.Timestamp, merged.Origin = b.Timestamp, b.Origin
+	}
+
+	switch a.Type {
+	case CounterType:
+		merged.Counter = PNCounter{mergeMax(a.Counter.P, b.Counter.P), mergeMax(a.Counter.N, b.Counter.N)}
+	case SetType:
+		merged.Set = ORSet{make(map[string]map[string]bool), make(map[string]bool)}
+		for _, s := range []ORSet{a.Set, b.Set} {
+			for member, tags := range s.Adds {
+				for tag := range tags {
+					merged.Set.add(member, tag)
+				}
+			}
+			for tag := range s.Removed {
+				merged.Set.Removed[tag] = true
+			}
+		}
+	case MapType:
+		merged.Map = LWWMap{make(map[string]LWWField)}
+		for _, m := range []LWWMap{a.Map, b.Map} {
+			for field, f := range m.Fields {
+				merged.Map.set(field, f)
+			}
+		}
+	}
+	return merged
+}
+
+// mergeMax
+// Returns the pointwise maximum of two per-replica counts.
+func mergeMax(a map[string]int64, b map[string]int64) map[string]int64 {
+	merged := make(map[string]int64)
+	for _, m := range []map[string]int64{a, b} {
+		for origin, count := range m {
+			merged[origin] = max(merged[origin], count)
+		}
+	}
+	return merged
+}
+
+// equal
+// Returns whether two states of a key are the same. Nil maps are equal to empty ones.
+func (c CRDT) equal(other CRDT) bool {
+	if c.Type != other.Type || c.Timestamp != other.Timestamp || c.Origin != other.Origin {
+		return false
+	}
+	switch c.Type {
+	case CounterType:
+		return maps.Equal(c.Counter.P, other.Counter.P) && maps.Equal(c.Counter.N, other.Counter.N)
+	case SetType:
+		return maps.EqualFunc(c.Set.Adds, other.Set.Adds, maps.Equal[map[string]bool, map[string]bool]) &&
+			maps.Equal(c.Set.Removed, other.Set.Removed)
+	case MapType:
+		return maps.Equal(c.Map.Fields, other.Map.Fields)
+	}
+	return true
+}
+
+// value
+// Returns the value Get and List show for the CRDT.
+func (c CRDT) value() string {
+	switch c.Type {
+	case CounterType:
+		return strconv.FormatInt(c.Counter.value(), 10)
+	case SetType:
+		bytes, _ := json.Marshal(c.Set.members())
+		return string(bytes)
+	case MapType:
+		bytes, _ := json.Marshal(c.Map.entries())
+		return string(bytes)
+	}
+	return ""
+}
@@ -0,0 +1,275 @@
+package kvserver
+
+import (
+	"bytes"
+	"encoding/binary"
+	"encoding/gob"
+	"errors"
+	"hash/crc32"
+	"io"
+	"os"
+	"path/filepath"
+	"sync"
+)
+
+// diskEngine
+// An Engine on local disk: a write-ahead log of changes, plus a snapshot of all entries taken every snapshotEvery
+// writes, after which the log restarts empty.
+//
+// Each log record is framed by its length and CRC-32, so a record torn by a crash is detected on recovery and dropped
+// along with the rest of the log after it. The snapshot is replaced atomically by writing a new file and renaming it.
+type diskEngine struct {
+	mux           sync.Mutex
+	dir           string
+	fsync         FsyncPolicy
+	snapshotEvery int
+	// All entries, kept to write snapshots.
+	entries map[string]Entry
+	log     *os.File
+	// Records in log since the last snapshot.
+	logged int
+	// Whether log has writes not yet fsynced.
+	dirty bool
+	// The error of a write that left a torn record at the end of log, after which the engine refuses writes.
+	failed error
+}
+
+// walRecord
+// A write-ahead log record: Write(Key, Entry).
+type walRecord struct {
+	Key   string
+	Entry *Entry
+}
+
+const (
+	snapshotFile = "snapshot"
+	logFile      = "wal"
+)
+
+// openDiskEngine
+// Opens the diskEngine in dir, creating dir if needed.
+func openDiskEngine(dir string, fsync FsyncPolicy, snapshotEvery int) (*diskEngine, error) {
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return nil, err
+	}
+	engine := &diskEngine{dir: dir, fsync: fsync, snapshotEvery: snapshotEvery, entries: make(map[string]Entry)}
+	if err := engine.load(); err != nil {
+		return nil, err
+	}
+	return engine, nil
+}
+
+// load
+// Reads the snapshot and replays the log into entries, and opens the log for appending after its last whole record.
+func (engine *diskEngine) load() error {
+	snapshot, err := os.Open(filepath.Join(engine.dir, snapshotFile))
+	if err == nil {
+		err = gob.NewDecoder(snapshot).Decode(&engine.entries)
+		snapshot.Close()
+		if err != nil {
+			return err
+		}
+	} else if !errors.Is(err, os.ErrNotExist) {
+		return err
+	}
+
+	log, err := os.OpenFile(filepath.Join(engine.dir, logFile), os.O_RDWR|os.O_CREATE, 0644)
+	if err != nil {
+		return err
+	}
+	info, err := log.Stat()
+	if err != nil {
+		log.Close()
+		return err
+	}
+	var end int64
+	for {
+		record, size, err := readRecord(log, info.Size()-end)
+		if err != nil {
+			// End of log, or a torn record from a crash.
+			break
+		}
+		engine.apply(record)
+		engine.logged++
+		end += size
+	}
+	if err := log.Truncate(end); err != nil {
+		log.Close()
+		return err
+	}
+	if _, err := log.Seek(end, io.SeekStart); err != nil {
+		log.Close()
+		return err
+	}
+	engine.log = log
+	return nil
+}
+
+// readRecord
+// Reads a framed record from r, which has remaining bytes left. Returns the record and its size on disk.
+func readRecord(r io.Reader, remaining int64) (walRecord, int64, error) {
+	var record walRecord
+	var header [8]byte
+	if _, err := io.ReadFull(r, header[:]); err != nil {
+		return record, 0, err
+	}
+	length := binary.LittleEndian.Uint32(header[:4])
+	if int64(length) > remaining-int64(len(header)) {
+		return record, 0, io.ErrUnexpectedEOF
+	}
+	payload := make([]byte, length)
+	if _, err := io.ReadFull(r, payload); err != nil {
+		return record, 0, err
+	}
+	if crc32.ChecksumIEEE(payload) != binary.LittleEndian.Uint32(header[4:]) {
+		return record, 0, errors.New("kvserver: corrupt log record")
+	}
+	err := gob.NewDecoder(bytes.NewReader(payload)).Decode(&record)
+	return record, int64(len(header)) + int64(length), err
+}
+
+// apply
+// Applies a log record to entries.
+func (engine *diskEngine) apply(record walRecord) {
+	if record.Entry == nil {
+		delete(engine.entries, record.Key)
+	} else {
+		engine.entries[record.Key] = *record.Entry
+	}
+}
+
+func (engine *diskEngine) Recover() (map[string]Entry, error) {
+	engine.mux.Lock()
+	defer engine.mux.Unlock()
+	entries := make(map[string]Entry, len(engine.entries))
+	for key, entry := range engine.entries {
+		entries[key] = entry
+	}
+	return entries, nil
+}
+
+func (engine *diskEngine) Write(key string, entry *Entry) error {
+	engine.mux.Lock()
+	defer engine.mux.Unlock()
+	if engine.log == nil {
+		return os.ErrClosed
+	} else if engine.failed != nil {
+		return engine.failed
+	}
+
+	var payload bytes.Buffer
+	record := walRecord{key, entry}
+	if err := gob.NewEncoder(&payload).Encode(record); err != nil {
+		return err
+	}
+	frame := make([]byte, 8, 8+payload.Len())
+	binary.LittleEndian.PutUint32(frame[:4], uint32(payload.Len()))
+	binary.LittleEndian.PutUint32(frame[4:], crc32.ChecksumIEEE(payload.Bytes()))
+	offset, err := engine.log.Seek(0, io.SeekCurrent)
+	if err != nil {
+		return err
+	}
+	if _, err := engine.log.Write(append(frame, payload.Bytes()...)); err != nil {
+		return engine.discardFrom(offset, err)
+	}
+	engine.apply(record)
+	engine.logged++
+	engine.dirty = true
+
+	if engine.snapshotEvery > 0 && engine.logged >= engine.snapshotEvery {
+		return engine.snapshot()
+	}
+	if engine.fsync == FsyncAlways {
+		return engine.sync()
+	}
+	return nil
+}
+
+// discardFrom
+// Cuts the log back to offset, where a write that failed with err started, so that later records do not follow a torn
+// one, at which recovery would stop. If that fails too, the engine refuses all further writes. Returns err.
+func (engine *diskEngine) discardFrom(offset int64, err error) error {
+	if truncErr := engine.log.Truncate(offset); truncErr != nil {
+		engine.failed = err
+	} else if _, seekErr := engine.log.Seek(offset, io.SeekStart); seekErr != nil {
+		engine.failed = err
+	}
+	return err
+}
+
+// snapshot
+// Writes all entries to a new snapshot, then empties the log.
+func (engine *diskEngine) snapshot() error {
+	path := filepath.Join(engine.dir, snapshotFile)
+	tmp, err := os.Create(path + ".tmp")
+	if err != nil {
+		return err
+	}
+	err = gob.NewEncoder(tmp).Encode(engine.entries)
+	if err == nil {
+		err = tmp.Sync()
+	}
+	if closeErr := tmp.Close(); err == nil {
+		err = closeErr
+	}
+	if err == nil {
+		err = os.Rename(path+".tmp", path)
+	}
+	if err == nil {
+		err = syncDir(engine.dir)
+	}
+	if err != nil {
+		return err
+	}
+
+	// The snapshot has everything in the log, so a crash from here on loses nothing.
+	if err := engine.log.Truncate(0); err != nil {
+		return err
+	}
+	if _, err := engine.log.Seek(0, io.SeekStart); err != nil {
+		return err
+	}
+	engine.logged = 0
+	return engine.sync()
+}
+
+// sync
+// Fsyncs the log.
+func (engine *diskEngine) sync() error {
+	engine.dirty = false
+	return engine.log.Sync()
+}
+
+// syncDir
+// Fsyncs directory dir, making renames in it durable.
+func syncDir(dir string) error {
+	d, err := os.Open(dir)
+	if err != nil {
+		return err
+	}
+	defer d.Close()
+	return d.Sync()
+}
+
+func (engine *diskEngine) Flush() error {
+	engine.mux.Lock()
+	defer engine.mux.Unlock()
+	if engine.log == nil || !engine.dirty || engine.fsync != FsyncInterval {
+		return nil
+	}
+	return engine.sync()
+}
+
+func (engine *diskEngine) Close() error {
+	engine.mux.Lock()
+	defer engine.mux.Unlock()
+	if engine.log == nil {
+		return os.ErrClosed
+	}
+	err := engine.log.Sync()
+	if closeErr := engine.log.Close(); err == nil {
+		err = closeErr
+	}
+	engine.log = nil
+	return err
+}
@@ -0,0 +1,98 @@
+package kvserver
+
+import (
+	"time"
+
+	"github.com/cmu440/kvcommon"
+)
+
+// Expiry
+//
+// A Put with a TTL stores its value with an expiry time, StoreValue.Expires: the write's timestamp plus the TTL. The
+// expiry is part of the write and is synced with it, so every replica expires the value at the same time, up to clock
+// skew. Expiring a value turns it into a tombstone at the write's own version, rather than making a new delete:
+// replicas that expire it at slightly different times, or receive the write only after it expired, still agree on the
+// key's version and value, and newer writes of the key win as usual.
+//
+// Values expire lazily, when a request reads their key, and periodically, on an ExpireSignal the actor sends itself
+// with TellAfter by the earliest expiry, at most every expireInterval. Actors with no expiring values send none.
+//
+// TTLs apply to keys in LastWriterWins conflict mode; writes of MultiValue keys ignore them.
+
+// Least time between two ExpireSignals.
+const expireInterval = 100 * time.Millisecond
+
+// ExpireSignal is the message type for expiring the values due. At is the expiry time it was scheduled for.
+type ExpireSignal struct {
+	At int64
+}
+
+// expiresAt
+// Returns the expiry time of a value written at timestamp to live for ttl, or zero for no expiry if ttl is not
+// positive.
+func expiresAt(timestamp int64, ttl time.Duration) int64 {
+	if ttl <= 0 {
+		return 0
+	}
+	return timestamp + ttl.Milliseconds()<<kvcommon.LogicalBits
+}
+
+// expired
+// Returns whether v is a value that expired by now, a hybrid logical clock timestamp.
+func (v StoreValue) expired(now int64) bool {
+	return !v.Deleted && v.Expires != 0 && v.Expires <= now
+}
+
+// expireIfDue
+// Turns the value of key into a tombstone if it expired.
+func (actor *queryActor) expireIfDue(key string) {
+	v, ok := actor.Store[key]
+	if !ok || !v.expired(kvcommon.HLCTimestamp(time.Now())) {
+		return
+	}
+	v.Value, v.Deleted = "", true
+	actor.Store[key] = v
+	actor.Tombstones[key] = true
+	delete(actor.Expiring, key)
+	actor.persist(key)
+	actor.changed(key)
+}
+
+// scheduleExpiry
+// Makes sure an ExpireSignal comes by expiry time expires, or expireInterval from now if that is later.
+func (actor *queryActor) scheduleExpiry(expires int64) {
+	if actor.ExpireAt != 0 && actor.ExpireAt <= expires {
+		return
+	}
+	actor.ExpireAt = expires
+	delay := time.Until(time.UnixMilli(expires >> kvcommon.LogicalBits))
+	actor.Context.TellAfter(actor.Context.Self, ExpireSignal{expires}, max(delay, expireInterval))
+}
+
+// expireDue
+// Expires the values due, and schedules an ExpireSignal for the earliest of the others.
+func (actor *queryActor) expireDue() {
+	now := kvcommon.HLCTimestamp(time.Now())
+	next := int64(0)
+	for key := range actor.Expiring {
+		v := actor.Store[key]
+		if v.expired(now) {
+			actor.expireIfDue(key)
+		} else if next == 0 || v.Expires < next {
+			next = v.Expires
+		}
+	}
+	if next != 0 {
+		actor.scheduleExpiry(next)
+	}
+}
+
+// onExpireSignal
+// Expires the values due on m.
+func (actor *queryActor) onExpireSignal(m ExpireSignal) {
+	// A signal superseded by an earlier one leaves scheduling to that one.
+	if m.At == actor.ExpireAt {
+		actor.ExpireAt = 0
+	}
+	actor.expireDue()
+}
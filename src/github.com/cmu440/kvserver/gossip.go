@@ -0,0 +1,78 @@
+package kvserver
+
+import (
+	"math/rand"
+
+	"github.com/cmu440/actor"
+)
+
+// Gossip
+//
+// By default, each sync goes to all other local query actors and to one query actor per remote server, which relays
+// it to the rest of its server. With Config.Gossip.Fanout set, syncs are instead spread by epidemic gossip: each sync
+// goes to Fanout random peers, and each query actor keeps gossiping an update it has learned of (its rumor) for
+// Config.Gossip.Rounds syncs. So the number of messages per sync is bounded no matter how many servers there are.
+//
+// Peers are picked among the other local query actors and the remote servers, each remote server counting as one peer
+// at a random query actor of it, so remote servers receive the same traffic however many query actors they have.
+//
+// In push-pull mode, peers picked also send their rumors back with their next sync, which spreads updates faster when
+// most query actors already know them.
+//
+// Gossip reaches all query actors with high probability; anti-entropy (see antientropy.go) repairs the rest.
+
+// gossipPeers
+// Returns Fanout random peers to gossip with.
+func (actor *queryActor) gossipPeers() []*actor.ActorRef {
+	local := len(actor.ActorsInfo) - 1
+	peers := actor.ActorsInfo[:0:0]
+	for _, i := range rand.Perm(local + len(actor.RemoteInfo)) {
+		if len(peers) == actor.Config.Gossip.Fanout {
+			break
+		}
+		if i >= local {
+			remote := actor.RemoteInfo[i-local]
+			peers = append(peers, remote[rand.Intn(len(remote))])
+		} else if i >= actor.Me {
+			peers = append(peers, actor.ActorsInfo[i+1])
+		} else {
+			peers = append(peers, actor.ActorsInfo[i])
+		}
+	}
+	return peers
+}
+
+// gossip
+// Sends the rumors in syn to random peers, and to up to as many peers that asked for them in push-pull mode, then ages
+// the rumors.
+func (actor *queryActor) gossip(syn SynMsg) {
+	rumors := len(syn.Data) > 0 || len(syn.Registers) > 0 || len(syn.CRDTs) > 0
+	if rumors || actor.Config.Gossip.PushPull {
+		for _, peer := range actor.gossipPeers() {
+			pull := syn
+			if actor.Config.Gossip.PushPull {
+				pull.Pull = actor.Context.Self
+			}
+			actor.Context.Tell(peer, actor.withAntiEntropy(peer, pull))
+			delete(actor.Pulls, peer.Uid())
+		}
+	}
+	replies := 0
+	for uid, peer := range actor.Pulls {
+		if rumors && replies < actor.Config.Gossip.Fanout {
+			actor.Context.Tell(peer, actor.withAntiEntropy(peer, syn))
+			replies++
+		}
+		delete(actor.Pulls, uid)
+	}
+
+	for key := range actor.Rumors {
+		actor.Rumors[key]--
+		if actor.Rumors[key] <= 0 {
+			delete(actor.Rumors, key)
+			delete(actor.Logs, key)
+			delete(actor.RegisterLogs, key)
+			delete(actor.CRDTLogs, key)
+		}
+	}
+}
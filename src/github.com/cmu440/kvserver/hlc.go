@@ -0,0 +1,37 @@
+package kvserver
+
+import (
+	"time"
+
+	"github.com/cmu440/kvcommon"
+)
+
+// hlc
+// A hybrid logical clock, packed as described for kvcommon.Version.Timestamp.
+//
+// Timestamps from now follow the wall clock while it moves forward, but are always later than any timestamp the
+// clock issued or observed before, so causally later writes get later timestamps even if wall clocks are skewed or
+// two writes share a millisecond.
+type hlc struct {
+	last int64
+}
+
+// now
+// Returns a timestamp for a local event, later than all earlier ones.
+func (clock *hlc) now() int64 {
+	physical := kvcommon.HLCTimestamp(time.Now())
+	if physical > clock.last {
+		clock.last = physical
+	} else {
+		clock.last++
+	}
+	return clock.last
+}
+
+// observe
+// Advances the clock past a timestamp received from another replica.
+func (clock *hlc) observe(timestamp int64) {
+	if timestamp > clock.last {
+		clock.last = timestamp
+	}
+}
@@ -0,0 +1,102 @@
+package kvserver
+
+import (
+	"sort"
+
+	"github.com/cmu440/kvcommon"
+)
+
+// Sibling
+// One of the concurrent writes kept for a key in MultiValue conflict mode.
+//
+// Each sibling is identified by its dot (Origin, Timestamp): the replica that wrote it and that replica's hybrid
+// logical clock at the time, which is unique and increasing per replica. Context holds the writes the sibling
+// supersedes, i.e. the causal context its writer had seen.
+type Sibling struct {
+	Value     string
+	Deleted   bool
+	Origin    string
+	Timestamp int64
+	Context   kvcommon.CausalContext
+}
+
+// covers
+// Returns whether context has seen sibling s.
+func covers(context kvcommon.CausalContext, s Sibling) bool {
+	return context[s.Origin] >= s.Timestamp
+}
+
+// registerContext
+// Returns a causal context covering all the siblings, and everything they supersede.
+func registerContext(siblings []Sibling) kvcommon.CausalContext {
+	context := make(kvcommon.CausalContext)
+	for _, s := range siblings {
+		context[s.Origin] = max(context[s.Origin], s.Timestamp)
+		for origin, timestamp := range s.Context {
+			context[origin] = max(context[origin], timestamp)
+		}
+	}
+	return context
+}
+
+// writeRegister
+// Returns the siblings after writing s, which supersedes the siblings s.Context covers.
+func writeRegister(siblings []Sibling, s Sibling) []Sibling {
+	result := []Sibling{s}
+	for _, old := range siblings {
+		if !covers(s.Context, old) {
+			result = append(result, old)
+		}
+	}
+	sortSiblings(result)
+	return result
+}
+
+// mergeRegisters
+// Returns the union of two replicas' siblings for a key, without those superseded by another sibling.
+func mergeRegisters(a []Sibling, b []Sibling) []Sibling {
+	all := append(append([]Sibling{}, a...), b...)
+	sortSiblings(all)
+	result := make([]Sibling, 0, len(all))
+	for i, s := range all {
+		if i > 0 && s.Origin == all[i-1].Origin && s.Timestamp == all[i-1].Timestamp {
+			// Same dot, same write.
+			continue
+		}
+		superseded := false
+		for _, other := range all {
+			if covers(other.Context, s) {
+				superseded = true
+				break
+			}
+		}
+		if !superseded {
+			result = append(result, s)
+		}
+	}
+	return result
+}
+
+// sortSiblings
+// Sorts siblings oldest first, in the order last-writer-wins would pick between them.
+func sortSiblings(siblings []Sibling) {
+	sort.Slice(siblings, func(i, j int) bool {
+		if siblings[i].Timestamp != siblings[j].Timestamp {
+			return siblings[i].Timestamp < siblings[j].Timestamp
+		}
+		return siblings[i].Origin > siblings[j].Origin
+	})
+}
+
+// resolveRegister
+// Returns the single value shown for the siblings by Get, List and conditional writes: the newest sibling that is not
+// deleted, or a tombstone if all are.
+func resolveRegister(siblings []Sibling) StoreValue {
+	var view StoreValue
+	for _, s := range siblings {
+		if !s.Deleted || view.Deleted || view.Origin == "" {
+			view = StoreValue{s.Origin, s.Timestamp, s.Value, s.Deleted, 0}
+		}
+	}
+	return view
+}
@@ -0,0 +1,365 @@
+package kvserver
+
+import (
+	"time"
+
+	"github.com/cmu440/actor"
+)
+
+// Partitioned mode
+//
+// With Config.Replicas set, each key is held only by its Config.Replicas owners, placed by a consistent hash ring over
+// all query actors of all servers (see ring.go):
+//   - A query actor sent a request for a key it does not own forwards it, in a Forward, to the key's primary owner,
+//     which answers the client directly.
+//   - Syncs and anti-entropy go only to the other owners of each key.
+//   - List and Scan gather the matching entries of all query actors, and MultiGet those of each key's primary owner,
+//     waiting at most scanTimeout for them.
+//   - When a server joins, every query actor hands off the keys that gained owners, and drops the keys it no longer
+//     owns. Keys written on a query actor that does not own them, because its ring was not up to date yet, are handed
+//     off with the next sync.
+
+// How long a List or Scan in partitioned mode waits for other query actors' entries.
+const scanTimeout = time.Second
+
+// Forward
+// The message type for a request forwarded to an owner of its key. The owner handles Request as if it were sent to
+// it, even if its own ring disagrees, so requests never bounce between query actors.
+type Forward struct {
+	Request any
+}
+
+// MScanPart
+// The message type for asking another query actor for its part of List, Scan or MultiGet request ID: up to Limit
+// entries with keys in [Start, End), the first ones in key order, or if Keys is set, the entries of Keys. Zero Limit
+// has no bound.
+type MScanPart struct {
+	ID     int
+	Start  string
+	End    string
+	Limit  int
+	Keys   []string
+	Sender *actor.ActorRef
+}
+
+// ScanPart
+// The message type for MScanPart responses: the entries, tombstones included, so that the newest entry of each key
+// wins.
+type ScanPart struct {
+	ID      int
+	Entries map[string]StoreValue
+}
+
+// ScanTimeout
+// The message type for giving up waiting for parts of List, Scan or MultiGet request ID.
+type ScanTimeout struct {
+	ID int
+}
+
+// pendingScan
+// A List, Scan or MultiGet request in partitioned mode, waiting for other query actors' parts.
+type pendingScan struct {
+	Sender *actor.ActorRef
+	// Whether to answer with a ListResult or a MultiGetResult, or else a ScanResult of up to Limit entries.
+	List     bool
+	MultiGet bool
+	Limit    int
+	Waiting  int
+	Entries  map[string]StoreValue
+}
+
+// partitioned
+// Returns whether the actor runs in partitioned mode.
+func (actor *queryActor) partitioned() bool {
+	return actor.Config.Replicas > 0
+}
+
+// allActors
+// Returns all query actors, local and remote, including the actor itself.
+func (actor *queryActor) allActors() []*actor.ActorRef {
+	all := append(actor.ActorsInfo[:0:0], actor.ActorsInfo...)
+	for _, remote := range actor.RemoteInfo {
+		all = append(all, remote...)
+	}
+	return all
+}
+
+// owners
+// Returns the query actors holding key in partitioned mode, primary first.
+func (actor *queryActor) owners(key string) []*actor.ActorRef {
+	return actor.Ring.owners(key, actor.Config.Replicas)
+}
+
+// owns
+// Returns whether the query actor with the given Uid holds key. Outside partitioned mode, every query actor does.
+func (actor *queryActor) owns(uid string, key string) bool {
+	if !actor.partitioned() {
+		return true
+	}
+	for _, owner := range actor.owners(key) {
+		if owner.Uid() == uid {
+			return true
+		}
+	}
+	return false
+}
+
+// requestKey
+// Returns the key of a client request, or false if message is not a request for a single key.
+func requestKey(message any) (string, bool) {
+	switch m := message.(type) {
+	case MGet:
+		return m.Key, true
+	case MPut:
+		return m.Key, true
+	case MDelete:
+		return m.Key, true
+	case MGetSiblings:
+		return m.Key, true
+	case MCRDT:
+		return m.Key, true
+	case MCondPut:
+		return m.Key, true
+	}
+	return "", false
+}
+
+// route
+// In partitioned mode, forwards message to the primary owner of its key if the actor does not own it. In any mode,
+// forwards a Get for a transaction to the key's home; see txn.go. Returns whether it did.
+func (actor *queryActor) route(message any) bool {
+	key, ok := requestKey(message)
+	if !ok {
+		return false
+	}
+	if m, isGet := message.(MGet); isGet && m.Home {
+		home := actor.home(key)
+		if home.Uid() == actor.Context.Self.Uid() {
+			return false
+		}
+		actor.Context.Tell(home, Forward{message})
+		return true
+	}
+	if actor.owns(actor.Context.Self.Uid(), key) {
+		return false
+	}
+	actor.Context.Tell(actor.home(key), Forward{message})
+	return true
+}
+
+// addEntry
+// Adds the actor's entry for key to syn.
+func (actor *queryActor) addEntry(syn *SynMsg, key string) {
+	v := actor.Store[key]
+	if siblings, ok := actor.Registers[key]; ok {
+		syn.Registers[key] = siblings
+	} else if state, ok := actor.CRDTs[key]; ok {
+		syn.CRDTs[key] = state
+	} else {
+		syn.Data[key] = MPut{
+			Key: key, Value: v.Value, Origin: v.Origin, Timestamp: v.Timestamp, Deleted: v.Deleted, Expires: v.Expires,
+		}
+	}
+}
+
+// forget
+// Drops key from the actor's state, after handing it off to its owners.
+func (actor *queryActor) forget(key string) {
+	delete(actor.Store, key)
+	actor.Index.remove(key)
+	delete(actor.Tombstones, key)
+	delete(actor.Expiring, key)
+	delete(actor.Registers, key)
+	delete(actor.CRDTs, key)
+	delete(actor.Logs, key)
+	delete(actor.RegisterLogs, key)
+	delete(actor.CRDTLogs, key)
+	delete(actor.Rumors, key)
+	actor.persist(key)
+}
+
+// ownerSync
+// A SynMsg to send to Ref.
+type ownerSync struct {
+	Ref *actor.ActorRef
+	Syn SynMsg
+}
+
+// sendToOwners
+// Sends the entries of keys to their other owners, a SynMsg per owner, and then forgets the keys the actor does not
+// own.
+func (actor *queryActor) sendToOwners(keys map[string]bool) {
+	self := actor.Context.Self.Uid()
+	syns := make(map[string]*ownerSync)
+	for key := range keys {
+		for _, owner := range actor.owners(key) {
+			if owner.Uid() == self {
+				continue
+			}
+			sync, ok := syns[owner.Uid()]
+			if !ok {
+				sync = &ownerSync{owner, SynMsg{
+					Data:      make(map[string]MPut),
+					Registers: make(map[string][]Sibling),
+					CRDTs:     make(map[string]CRDT),
+				}}
+				syns[owner.Uid()] = sync
+			}
+			actor.addEntry(&sync.Syn, key)
+		}
+	}
+	for _, sync := range syns {
+		actor.Context.Tell(sync.Ref, actor.withAntiEntropy(sync.Ref, sync.Syn))
+	}
+	for key := range keys {
+		if !actor.owns(self, key) {
+			actor.forget(key)
+		}
+	}
+}
+
+// syncPartitioned
+// Sends the changes since the last sync to the other owners of their keys.
+func (actor *queryActor) syncPartitioned() {
+	keys := make(map[string]bool)
+	for key := range actor.Logs {
+		keys[key] = true
+	}
+	for key := range actor.RegisterLogs {
+		keys[key] = true
+	}
+	for key := range actor.CRDTLogs {
+		keys[key] = true
+	}
+	actor.Logs = make(map[string]MPut)
+	actor.RegisterLogs = make(map[string]bool)
+	actor.CRDTLogs = make(map[string]bool)
+	actor.Rumors = make(map[string]int)
+	actor.sendToOwners(keys)
+}
+
+// rebalance
+// Rebuilds the ring, and the Merkle leaves of the keys shared with each peer, after query actors joined. In partitioned
+// mode, also hands off the keys that gained owners to their owners, and forgets those the actor no longer owns.
+func (actor *queryActor) rebalance() {
+	old := actor.Ring
+	actor.Ring = newRing(actor.allActors())
+	actor.rebuildMerkle()
+	if !actor.partitioned() {
+		return
+	}
+
+	moved := make(map[string]bool)
+	for key := range actor.Store {
+		previous := make(map[string]bool)
+		for _, owner := range old.owners(key, actor.Config.Replicas) {
+			previous[owner.Uid()] = true
+		}
+		for _, owner := range actor.owners(key) {
+			moved[key] = moved[key] || !previous[owner.Uid()]
+		}
+	}
+	actor.sendToOwners(moved)
+}
+
+// startScan
+// Starts gathering the entries with keys in [start, end) for scan from all query actors.
+func (actor *queryActor) startScan(scan *pendingScan, start string, end string) {
+	id := actor.NextScan
+	actor.NextScan++
+	// Each part's first Limit+1 keys include the first Limit+1 keys of all parts, so the result knows where the next
+	// page starts.
+	limit := 0
+	if !scan.List {
+		limit = scan.Limit + 1
+	}
+	scan.Entries = actor.rangeEntries(start, end, limit, true)
+	for _, ref := range actor.allActors() {
+		if ref.Uid() != actor.Context.Self.Uid() {
+			actor.Context.Tell(ref, MScanPart{id, start, end, limit, nil, actor.Context.Self})
+			scan.Waiting++
+		}
+	}
+	actor.Scans[id] = scan
+	if scan.Waiting == 0 {
+		actor.finishScan(id)
+		return
+	}
+	actor.Context.TellAfter(actor.Context.Self, ScanTimeout{id}, scanTimeout)
+}
+
+// startMultiGet
+// Starts gathering the entries of the keys of m from their primary owners.
+func (actor *queryActor) startMultiGet(m MMultiGet) {
+	id := actor.NextScan
+	actor.NextScan++
+	scan := &pendingScan{Sender: m.Sender, MultiGet: true, Entries: make(map[string]StoreValue)}
+	owners := actor.ActorsInfo[:0:0]
+	keys := make(map[string][]string)
+	for _, key := range m.Keys {
+		owner := actor.home(key)
+		if owner.Uid() == actor.Context.Self.Uid() {
+			if v, ok := actor.Store[key]; ok {
+				scan.Entries[key] = v
+			}
+			continue
+		}
+		if _, ok := keys[owner.Uid()]; !ok {
+			owners = append(owners, owner)
+		}
+		keys[owner.Uid()] = append(keys[owner.Uid()], key)
+	}
+	for _, owner := range owners {
+		actor.Context.Tell(owner, MScanPart{ID: id, Keys: keys[owner.Uid()], Sender: actor.Context.Self})
+		scan.Waiting++
+	}
+	actor.Scans[id] = scan
+	if scan.Waiting == 0 {
+		actor.finishScan(id)
+		return
+	}
+	actor.Context.TellAfter(actor.Context.Self, ScanTimeout{id}, scanTimeout)
+}
+
+// addScanPart
+// Merges part into its List, Scan or MultiGet request, keeping the newest entry of each key.
+func (actor *queryActor) addScanPart(part ScanPart) {
+	scan, ok := actor.Scans[part.ID]
+	if !ok {
+		return
+	}
+	for key, v := range part.Entries {
+		if old, ok := scan.Entries[key]; !ok || isNewer(MPut{Origin: v.Origin, Timestamp: v.Timestamp}, old) {
+			scan.Entries[key] = v
+		}
+	}
+	scan.Waiting--
+	if scan.Waiting == 0 {
+		actor.finishScan(part.ID)
+	}
+}
+
+// finishScan
+// Answers List, Scan or MultiGet request id with the entries gathered so far.
+func (actor *queryActor) finishScan(id int) {
+	scan, ok := actor.Scans[id]
+	if !ok {
+		return
+	}
+	delete(actor.Scans, id)
+	if scan.MultiGet {
+		actor.Context.Tell(scan.Sender, multiGetResult(scan.Entries))
+		return
+	}
+	if !scan.List {
+		actor.Context.Tell(scan.Sender, scanResult(scan.Entries, scan.Limit))
+		return
+	}
+	result := ListResult{Pair: make(map[string]string)}
+	for k, v := range scan.Entries {
+		if !v.Deleted {
+			result.Pair[k] = v.Value
+		}
+	}
+	actor.Context.Tell(scan.Sender, result)
+}
@@ -4,7 +4,8 @@ import (
 	"encoding/gob"
 	"fmt"
 	"github.com/cmu440/actor"
-	"strings"
+	"github.com/cmu440/kvcommon"
+	"strconv"
 	"time"
 )
 
@@ -20,38 +21,255 @@ func init() {
 	gob.Register(MList{})
 	gob.Register(PutResult{})
 	gob.Register(NotifyNewServer{})
+	gob.Register(MDelete{})
+	gob.Register(DeleteResult{})
+	gob.Register(GetVersionResult{})
+	gob.Register(MCondPut{})
+	gob.Register(CondPutResult{})
+	gob.Register(MGetSiblings{})
+	gob.Register(SiblingsResult{})
+	gob.Register(MCRDT{})
+	gob.Register(CRDTResult{})
+	gob.Register(WrongType{})
+	gob.Register(Forward{})
+	gob.Register(MScanPart{})
+	gob.Register(ScanPart{})
+	gob.Register(ScanTimeout{})
+	gob.Register(MScan{})
+	gob.Register(ScanResult{})
+	gob.Register(MReplica{})
+	gob.Register(ReplicaResult{})
+	gob.Register(ConsistencyTimeout{})
+	gob.Register(ConsistencyFailed{})
+	gob.Register(ReadTimeout{})
+	gob.Register(CatchUpFailed{})
+	gob.Register(MBatch{})
+	gob.Register(BatchResult{})
+	gob.Register(SpansOwners{})
+	gob.Register(MMultiGet{})
+	gob.Register(MultiGetResult{})
+	gob.Register(MCommit{})
+	gob.Register(CommitResult{})
+	gob.Register(MPrepare{})
+	gob.Register(PrepareResult{})
+	gob.Register(MDecide{})
+	gob.Register(DecideResult{})
+	gob.Register(TxnTimeout{})
+	gob.Register(LockTimeout{})
+	gob.Register(MWatch{})
+	gob.Register(WatchResult{})
+	gob.Register(WatchTimeout{})
+	gob.Register(ExpireSignal{})
 }
 
-// queryActor represents an actor that handles GET, PUT, and LIST requests.
+// queryActor represents an actor that handles GET, PUT, DELETE, and LIST requests.
 type queryActor struct {
 	ActorsInfo  []*actor.ActorRef
 	ActorSystem *actor.ActorSystem
-	Context     *actor.ActorContext
-	Logs        map[string]MPut
-	Me          int
-	RemoteInfo  [][]*actor.ActorRef
-	Store       map[string]StoreValue
+	// Time and turn of the last anti-entropy round, see startAntiEntropy.
+	AntiEntropyLast time.Time
+	AntiEntropyNext int
+	// Anti-entropy to send with the next sync, by Uid of the recipient.
+	AntiEntropyOut map[string]*pendingAntiEntropy
+	Clock          hlc
+	Config         Config
+	Context        *actor.ActorContext
+	// States of keys holding CRDTs. Store holds the value they show.
+	CRDTs map[string]CRDT
+	// Keys in CRDTs changed since the last sync.
+	CRDTLogs map[string]bool
+	// Where the actor writes its state through to, and the first error doing so while handling the current message.
+	Engine    Engine
+	EngineErr error
+	// The last changes of Store, for watches, and the cursor of the first one; see watch.go.
+	Events      []kvcommon.Event
+	EventsStart int
+	// The expiry time the next ExpireSignal was scheduled for, or zero if none; see expiry.go.
+	ExpireAt int64
+	// Keys in Store whose value has an expiry time and did not expire yet.
+	Expiring map[string]bool
+	// Writes of keys locked by prepared transactions, held until the locks are released, by key; see txn.go.
+	Held map[string][]any
+	// The keys of Store in order.
+	Index keyIndex
+	// Keys locked by prepared transactions, and the transaction holding each; see txn.go.
+	Locks map[string]TxnID
+	Logs  map[string]MPut
+	Me    int
+	// The leaves of the actor's Merkle trees, and the keys in each, for anti-entropy; see antientropy.go.
+	Merkle merkleIndex
+	// Get requests held until the actor catches up with their MGet.After, by ID, and the next ID.
+	NextRead int
+	// Get and Put requests being coordinated with other replicas, by ID, and the next ID.
+	NextRequest int
+	// In partitioned mode, List, Scan and MultiGet requests waiting for other query actors, by ID, and the next ID.
+	NextScan int
+	// Transactions the actor coordinates, by sequence number, and the next sequence number.
+	NextTxn int
+	// Watches held until there are changes for them, by ID, and the next ID.
+	NextWatch int
+	// Keys locked by each prepared transaction.
+	Prepared map[TxnID][]string
+	// In gossip push-pull mode, peers that asked for the actor's rumors, by Uid.
+	Pulls map[string]*actor.ActorRef
+	Reads map[int]MGet
+	// Siblings of keys in MultiValue conflict mode. Store holds the value resolveRegister shows for them.
+	Registers map[string][]Sibling
+	// Keys in Registers changed since the last sync.
+	RegisterLogs map[string]bool
+	RemoteInfo   [][]*actor.ActorRef
+	Requests     map[int]*pendingRequest
+	// The ring placing keys on query actors: their owners in partitioned mode, and their homes for transactions.
+	Ring ring
+	// In gossip mode, the number of syncs left to gossip each key in the logs for.
+	Rumors map[string]int
+	Scans  map[int]*pendingScan
+	Store  map[string]StoreValue
+	// Keys in Store whose value is a tombstone, for garbage collection.
+	Tombstones map[string]bool
+	Txns       map[int]*pendingTxn
+	Watches    map[int]MWatch
 }
 
 // StoreValue is the value stored in the store
 type StoreValue struct {
-	Sender    *actor.ActorRef
-	Timestamp int64 //millisecond resolution
+	// Uid of the query actor that wrote the value.
+	Origin    string
+	Timestamp int64 // hybrid logical clock, see kvcommon.Version
 	Value     string
+	// Deleted marks a tombstone: the key was deleted at Timestamp.
+	Deleted bool
+	// When the value expires, as a hybrid logical clock timestamp, or zero if never; see expiry.go.
+	Expires int64
 }
 
 // MGet is the message type for GET requests.
 type MGet struct {
-	Key    string
+	Key         string
+	Consistency kvcommon.Consistency
+	// A Version of key the answer must be at least as new as; see catchup.go.
+	After kvcommon.Version
+	// Whether to answer with a GetVersionResult instead of a GetResult.
+	Versioned bool
+	// Whether to serve the Get on the key's home query actor, which validates transactions on it; see txn.go.
+	Home   bool
 	Sender *actor.ActorRef
 }
 
 // MPut is the message type for PUT requests.
+// It is also the log entry type for syncing, where Deleted entries carry tombstones. Origin and Timestamp are set by
+// the query actor that first writes the entry.
 type MPut struct {
 	Key       string
 	Sender    *actor.ActorRef
+	Origin    string
 	Timestamp int64
 	Value     string
+	Deleted   bool
+	// For keys in MultiValue conflict mode, the siblings the write supersedes.
+	Context kvcommon.CausalContext
+	// For PUT requests, how many replicas must acknowledge the write; see consistency.go.
+	Consistency kvcommon.Consistency
+	// For PUT requests, a Version the write must supersede; see catchup.go.
+	After kvcommon.Version
+	// For PUT requests, how long the value lives; zero forever. For log entries, when the value expires; see expiry.go.
+	TTL     time.Duration
+	Expires int64
+}
+
+// MDelete is the message type for DELETE requests.
+type MDelete struct {
+	Key    string
+	Sender *actor.ActorRef
+}
+
+// DeleteResult is the message type for DELETE responses.
+type DeleteResult struct {
+	Ok bool
+}
+
+// GetVersionResult is the message type for responses to MGet requests with Versioned set.
+type GetVersionResult struct {
+	Ok      bool
+	Value   string
+	Version kvcommon.Version
+	// The version of the value read, tombstones included, for a later MGet.After or MPut.After; see catchup.go.
+	Token kvcommon.Version
+}
+
+// MGetSiblings is the message type for requests for all siblings of a key.
+type MGetSiblings struct {
+	Key    string
+	Sender *actor.ActorRef
+}
+
+// SiblingsResult is the message type for MGetSiblings responses.
+type SiblingsResult struct {
+	Values  []string
+	Context kvcommon.CausalContext
+}
+
+// CRDTOp is the kind of operation an MCRDT performs.
+type CRDTOp int
+
+const (
+	OpRead CRDTOp = iota
+	OpIncrement
+	OpSetAdd
+	OpSetRemove
+	OpMapSet
+	OpMapDelete
+)
+
+// MCRDT is the message type for CRDT operations.
+type MCRDT struct {
+	Key    string
+	Op     CRDTOp
+	Delta  int64
+	Member string
+	Field  string
+	Value  string
+	Sender *actor.ActorRef
+}
+
+// CRDTResult is the message type for MCRDT responses: the key's state after the operation.
+type CRDTResult struct {
+	Ok      bool
+	Type    CRDTType
+	Counter int64
+	Members []string
+	Entries map[string]string
+}
+
+// Condition is the kind of check an MCondPut makes before writing.
+type Condition int
+
+const (
+	// The key is absent.
+	CondAbsent Condition = iota
+	// The key's value is MCondPut.Expected.
+	CondValue
+	// The key's version is MCondPut.Version.
+	CondVersion
+)
+
+// MCondPut is the message type for conditional PUT requests.
+type MCondPut struct {
+	Key      string
+	Value    string
+	Cond     Condition
+	Expected string
+	Version  kvcommon.Version
+	Sender   *actor.ActorRef
+}
+
+// CondPutResult is the message type for conditional PUT responses.
+// If Ok, Version is the written value's version; otherwise Value, Present and Version describe the current value.
+type CondPutResult struct {
+	Ok      bool
+	Value   string
+	Present bool
+	Version kvcommon.Version
 }
 
 // MList is the message type for LIST requests.
@@ -73,11 +291,13 @@ type GetResult struct {
 
 // PutResult is the message type for PUT responses.
 type PutResult struct {
+	Version kvcommon.Version
 }
 
 // Init is the message type for initializing the queryActor
 type Init struct {
 	ActorsInfo []*actor.ActorRef
+	Config     Config
 	RemoteInfo [][]*actor.ActorRef
 	Me         int
 }
@@ -89,6 +309,14 @@ type SynSignal struct {
 // SynMsg is the message type for synchronization
 type SynMsg struct {
 	Data map[string]MPut
+	// Full sibling sets of keys in MultiValue conflict mode.
+	Registers map[string][]Sibling
+	// Full states of keys holding CRDTs.
+	CRDTs map[string]CRDT
+	// Anti-entropy from the sender, if any; see antientropy.go.
+	AntiEntropy *AntiEntropy
+	// In gossip push-pull mode, the sender, asking for the recipient's rumors with its next sync; see gossip.go.
+	Pull *actor.ActorRef
 }
 
 // NotifyNewServer is the message type for notifying that a new server came online
@@ -99,12 +327,325 @@ type NotifyNewServer struct {
 // "Constructor" for queryActors, used in ActorSystem.StartActor.
 func newQueryActor(context *actor.ActorContext) actor.Actor {
 	return &queryActor{
-		ActorsInfo: make([]*actor.ActorRef, 0),
-		Context:    context,
-		Logs:       make(map[string]MPut),
-		Me:         -1,
-		RemoteInfo: make([][]*actor.ActorRef, 0),
-		Store:      make(map[string]StoreValue),
+		ActorsInfo:     make([]*actor.ActorRef, 0),
+		AntiEntropyOut: make(map[string]*pendingAntiEntropy),
+		CRDTs:          make(map[string]CRDT),
+		CRDTLogs:       make(map[string]bool),
+		Context:        context,
+		Engine:         newMemoryEngine(),
+		Expiring:       make(map[string]bool),
+		Held:           make(map[string][]any),
+		Locks:          make(map[string]TxnID),
+		Logs:           make(map[string]MPut),
+		Me:             -1,
+		Merkle:         newMerkleIndex(),
+		Prepared:       make(map[TxnID][]string),
+		Pulls:          make(map[string]*actor.ActorRef),
+		Reads:          make(map[int]MGet),
+		Registers:      make(map[string][]Sibling),
+		RegisterLogs:   make(map[string]bool),
+		RemoteInfo:     make([][]*actor.ActorRef, 0),
+		Requests:       make(map[int]*pendingRequest),
+		Rumors:         make(map[string]int),
+		Scans:          make(map[int]*pendingScan),
+		Store:          make(map[string]StoreValue),
+		Tombstones:     make(map[string]bool),
+		Txns:           make(map[int]*pendingTxn),
+		Watches:        make(map[int]MWatch),
+	}
+}
+
+// get answers the Get request m.
+func (actor *queryActor) get(m MGet) {
+	if m.Consistency != kvcommon.One {
+		actor.coordinate(m.Key, m.Consistency, m.Sender, m)
+		return
+	}
+	actor.Context.Tell(m.Sender, actor.getResult(m))
+}
+
+// getResult returns the response to the Get request m from the actor's Store.
+func (actor *queryActor) getResult(m MGet) any {
+	v, exist := actor.Store[m.Key]
+	exist = exist && !v.Deleted
+	if m.Versioned {
+		return GetVersionResult{Ok: exist, Value: v.Value, Version: v.version(), Token: v.token()}
+	}
+	return GetResult{Value: v.Value, Ok: exist}
+}
+
+// merge merges the entries of syn from another replica into the actor's, and serves the held Get requests it caught
+// up with.
+func (actor *queryActor) merge(syn SynMsg) {
+	for _, data := range syn.Data {
+		actor.Clock.observe(data.Timestamp)
+		actor.apply(data)
+	}
+	for key, siblings := range syn.Registers {
+		for _, s := range siblings {
+			actor.Clock.observe(s.Timestamp)
+		}
+		actor.mergeRegister(key, siblings)
+	}
+	for key, state := range syn.CRDTs {
+		actor.Clock.observe(state.Timestamp)
+		old, exist := actor.CRDTs[key]
+		if merged := mergeCRDTs(old, state); !exist || !merged.equal(old) {
+			actor.setCRDT(key, merged)
+		}
+	}
+	if len(actor.Reads) > 0 {
+		actor.checkReads()
+	}
+}
+
+// isNewer returns whether the log entry data wins against the stored value v under last-writer-wins.
+func isNewer(data MPut, v StoreValue) bool {
+	if data.Timestamp != v.Timestamp {
+		return data.Timestamp > v.Timestamp
+	}
+	return data.Origin < v.Origin
+}
+
+// apply stores the log entry data if it wins against the current value, and logs it for the next sync.
+// Returns whether it was stored.
+//
+// Keys holding CRDTs keep them; entries for such keys are dropped.
+func (actor *queryActor) apply(data MPut) bool {
+	if _, ok := actor.CRDTs[data.Key]; ok {
+		return false
+	}
+	if v, ok := actor.Store[data.Key]; ok && !isNewer(data, v) {
+		return false
+	}
+	v := StoreValue{data.Origin, data.Timestamp, data.Value, data.Deleted, data.Expires}
+	if v.expired(kvcommon.HLCTimestamp(time.Now())) {
+		// Synced after it expired; store it as the other replicas do.
+		v.Value, v.Deleted = "", true
+		data.Value, data.Deleted = "", true
+	}
+	actor.Store[data.Key] = v
+	actor.Index.insert(data.Key)
+	if data.Deleted {
+		actor.Tombstones[data.Key] = true
+	} else {
+		delete(actor.Tombstones, data.Key)
+	}
+	if v.Expires != 0 && !v.Deleted {
+		actor.Expiring[data.Key] = true
+		actor.scheduleExpiry(v.Expires)
+	} else {
+		delete(actor.Expiring, data.Key)
+	}
+	actor.Logs[data.Key] = data
+	actor.Rumors[data.Key] = actor.Config.Gossip.Rounds
+	actor.persist(data.Key)
+	actor.changed(data.Key)
+	return true
+}
+
+// setRegister replaces the siblings of key, updates its value in Store to match, and logs it for the next sync.
+func (actor *queryActor) setRegister(key string, siblings []Sibling) {
+	actor.Registers[key] = siblings
+	view := resolveRegister(siblings)
+	actor.Store[key] = view
+	actor.Index.insert(key)
+	delete(actor.Expiring, key)
+	if view.Deleted {
+		actor.Tombstones[key] = true
+	} else {
+		delete(actor.Tombstones, key)
+	}
+	actor.RegisterLogs[key] = true
+	actor.Rumors[key] = actor.Config.Gossip.Rounds
+	actor.persist(key)
+	actor.changed(key)
+}
+
+// mergeRegister merges siblings of key from another replica into the actor's.
+func (actor *queryActor) mergeRegister(key string, siblings []Sibling) {
+	old := actor.Registers[key]
+	merged := mergeRegisters(old, siblings)
+	if len(merged) == len(old) {
+		same := true
+		for i := range merged {
+			same = same && merged[i].Origin == old[i].Origin && merged[i].Timestamp == old[i].Timestamp
+		}
+		if same {
+			return
+		}
+	}
+	actor.setRegister(key, merged)
+}
+
+// setCRDT replaces the state of a CRDT key, updates its value in Store to match, and logs it for the next sync.
+func (actor *queryActor) setCRDT(key string, state CRDT) {
+	actor.CRDTs[key] = state
+	actor.Store[key] = StoreValue{state.Origin, state.Timestamp, state.value(), false, 0}
+	actor.Index.insert(key)
+	delete(actor.Tombstones, key)
+	delete(actor.Expiring, key)
+	delete(actor.Registers, key)
+	actor.CRDTLogs[key] = true
+	actor.Rumors[key] = actor.Config.Gossip.Rounds
+	actor.persist(key)
+	actor.changed(key)
+}
+
+// updateCRDT applies the operation m to its key's CRDT, creating it if needed.
+// Returns false if the key holds a value of another type.
+//
+// An Increment of a key holding a plain integer, e.g. written before the key became a counter, makes it a counter
+// starting at that integer. The integer is counted under an ID of its version, the same on every replica that does
+// this, so merging their counters counts it once.
+func (actor *queryActor) updateCRDT(m MCRDT) (CRDT, bool) {
+	state, exist := actor.CRDTs[m.Key]
+	if m.Op == OpRead {
+		return state, exist
+	}
+	want := map[CRDTOp]CRDTType{
+		OpIncrement: CounterType, OpSetAdd: SetType, OpSetRemove: SetType, OpMapSet: MapType, OpMapDelete: MapType,
+	}[m.Op]
+	if exist && state.Type != want {
+		return state, false
+	}
+	var plain int64
+	v, hasPlain := actor.Store[m.Key]
+	hasPlain = !exist && hasPlain && !v.Deleted
+	if hasPlain {
+		var err error
+		if plain, err = strconv.ParseInt(v.Value, 10, 64); err != nil || m.Op != OpIncrement {
+			return state, false
+		}
+	}
+
+	// Update a copy, as the current state may still be referenced by a SynMsg being sent.
+	state = mergeCRDTs(state, state)
+	state.Type = want
+	state.Timestamp = actor.Clock.now()
+	state.Origin = actor.Context.Self.Uid()
+	switch m.Op {
+	case OpIncrement:
+		if hasPlain {
+			state.Counter.increment(fmt.Sprintf("plain@%s@%d", v.Origin, v.Timestamp), plain)
+		}
+		state.Counter.increment(state.Origin, m.Delta)
+	case OpSetAdd:
+		state.Set.add(m.Member, fmt.Sprintf("%s@%d", state.Origin, state.Timestamp))
+	case OpSetRemove:
+		state.Set.remove(m.Member)
+	case OpMapSet, OpMapDelete:
+		field := LWWField{m.Value, m.Op == OpMapDelete, state.Timestamp, state.Origin}
+		state.Map.set(m.Field, field)
+	}
+	actor.setCRDT(m.Key, state)
+	return state, true
+}
+
+// version returns the version of the stored value v, or the zero version if it is a tombstone.
+func (v StoreValue) version() kvcommon.Version {
+	if v.Deleted || v.Origin == "" {
+		return kvcommon.Version{}
+	}
+	return kvcommon.Version{Timestamp: v.Timestamp, Origin: v.Origin}
+}
+
+// write stamps data with a new timestamp from the actor's clock and applies it.
+// Because the clock is ahead of every value the actor has stored, the write always supersedes the key's current value.
+//
+// For keys in MultiValue conflict mode, the write instead becomes a new sibling, superseding those data.Context covers.
+//
+// Writes to keys holding CRDTs are dropped, returning the zero version.
+func (actor *queryActor) write(data MPut) kvcommon.Version {
+	return actor.writeAt(data, actor.Clock.now())
+}
+
+// writeAt is write at the given timestamp, which must be newer than every value the actor has stored.
+func (actor *queryActor) writeAt(data MPut, timestamp int64) kvcommon.Version {
+	if _, ok := actor.CRDTs[data.Key]; ok {
+		return kvcommon.Version{}
+	}
+	data.Timestamp = timestamp
+	data.Origin = actor.Context.Self.Uid()
+	data.Expires = expiresAt(timestamp, data.TTL)
+	if actor.Config.conflictMode(data.Key) == MultiValue {
+		s := Sibling{data.Value, data.Deleted, data.Origin, data.Timestamp, data.Context}
+		actor.setRegister(data.Key, writeRegister(actor.Registers[data.Key], s))
+	} else {
+		actor.apply(data)
+	}
+	return kvcommon.Version{Timestamp: data.Timestamp, Origin: data.Origin}
+}
+
+// holds returns whether the condition of m holds for the current value v of its key.
+func (m MCondPut) holds(v StoreValue, exist bool) bool {
+	switch m.Cond {
+	case CondAbsent:
+		return !exist
+	case CondValue:
+		return exist && v.Value == m.Expected
+	case CondVersion:
+		return v.version() == m.Version
+	}
+	return false
+}
+
+// collectTombstones forgets tombstones older than Config.TombstoneGrace. An expired value's tombstone counts from its
+// expiry.
+func (actor *queryActor) collectTombstones() {
+	cutoff := actor.tombstoneCutoff()
+	for key := range actor.Tombstones {
+		if v := actor.Store[key]; max(v.Timestamp, v.Expires) < cutoff {
+			delete(actor.Store, key)
+			actor.Index.remove(key)
+			delete(actor.Tombstones, key)
+			delete(actor.Registers, key)
+			delete(actor.RegisterLogs, key)
+			actor.persist(key)
+		}
+	}
+}
+
+// tombstoneCutoff returns the hybrid logical clock timestamp before which tombstones are collected.
+func (actor *queryActor) tombstoneCutoff() int64 {
+	return kvcommon.HLCTimestamp(time.Now().Add(-actor.Config.TombstoneGrace))
+}
+
+// persist writes the current entry of key through to the engine, and updates its Merkle index.
+func (actor *queryActor) persist(key string) {
+	actor.rehash(key)
+	var entry *Entry
+	if v, ok := actor.Store[key]; ok {
+		entry = &Entry{Value: v, Siblings: actor.Registers[key]}
+		if state, ok := actor.CRDTs[key]; ok {
+			entry.CRDT = &state
+		}
+	}
+	if err := actor.Engine.Write(key, entry); err != nil && actor.EngineErr == nil {
+		actor.EngineErr = err
+	}
+}
+
+// restore loads entries recovered from the engine into the actor's state.
+func (actor *queryActor) restore(entries map[string]Entry) {
+	for key, entry := range entries {
+		actor.Store[key] = entry.Value
+		actor.Index.insert(key)
+		actor.Clock.observe(entry.Value.Timestamp)
+		if entry.Value.Deleted {
+			actor.Tombstones[key] = true
+		} else if entry.Value.Expires != 0 {
+			actor.Expiring[key] = true
+		}
+		if entry.Siblings != nil {
+			actor.Registers[key] = entry.Siblings
+			for _, s := range entry.Siblings {
+				actor.Clock.observe(s.Timestamp)
+			}
+		}
+		if entry.CRDT != nil {
+			actor.CRDTs[key] = *entry.CRDT
+		}
 	}
 }
 
@@ -114,94 +655,289 @@ func newQueryActor(context *actor.ActorContext) actor.Actor {
 //  2. When a server receives a NotifyNewServer message, it will send a SynMsg message to the new server.
 //  3. When a server receives a SynMsg message, it will update its own store and logs.
 //  4. Every 100ms, a server will send a SynSignal message to itself
-//  5. When a server receives a SynSignal message, it will send a SynMsg message to all servers.
+//  5. When a server receives a SynSignal message, it will send a SynMsg message to all servers, or in gossip mode to
+//     Config.Gossip.Fanout random servers. See gossip.go.
+//  6. Every Config.AntiEntropyInterval, a server will compare Merkle trees with one other server in its SynMsg
+//     messages, and exchange the entries where they differ, to repair lost SynMsg messages. See antientropy.go.
+//
+// In partitioned mode, requests for keys the actor does not own are forwarded to their owners, and SynMsg messages go
+// only to the other owners of each key. See partition.go.
+//
+// Transactions are validated and committed by the home query actors of their keys, by two-phase commit. See txn.go.
 func (actor *queryActor) OnMessage(message any) error {
+	if f, ok := message.(Forward); ok {
+		message = f.Request
+	} else if actor.route(message) {
+		return nil
+	}
+	err := actor.handle(message)
+	// Held watches are answered after the whole message is handled, so they see all changes of a batch at once.
+	if len(actor.Watches) > 0 {
+		actor.serveWatches()
+	}
+	return err
+}
+
+// handle
+// Handles message as the actor's own.
+func (actor *queryActor) handle(message any) error {
+	if key, ok := requestKey(message); ok {
+		actor.expireIfDue(key)
+	}
 	switch m := message.(type) {
 	case NotifyNewServer:
 		actor.RemoteInfo = append(actor.RemoteInfo, m.Refs)
+		actor.rebalance()
+		if actor.partitioned() {
+			break
+		}
 		logs := make(map[string]MPut)
 
 		for k, v := range actor.Store {
-			logs[k] = MPut{Key: k, Value: v.Value, Sender: v.Sender, Timestamp: v.Timestamp}
+			if _, ok := actor.Registers[k]; !ok {
+				logs[k] = MPut{
+					Key: k, Value: v.Value, Origin: v.Origin, Timestamp: v.Timestamp, Deleted: v.Deleted, Expires: v.Expires,
+				}
+			}
 		}
 
 		for _, ref := range m.Refs {
-			actor.Context.Tell(ref, SynMsg{Data: logs})
+			actor.Context.Tell(ref, SynMsg{Data: logs, Registers: actor.Registers, CRDTs: actor.CRDTs})
 		}
 
 	case SynSignal:
-		for index, a := range actor.ActorsInfo {
-			if index != actor.Me {
-				actor.Context.Tell(a, SynMsg{Data: actor.Logs})
+		registers := make(map[string][]Sibling)
+		for key := range actor.RegisterLogs {
+			registers[key] = actor.Registers[key]
+		}
+		crdts := make(map[string]CRDT)
+		for key := range actor.CRDTLogs {
+			crdts[key] = actor.CRDTs[key]
+		}
+		syn := SynMsg{Data: actor.Logs, Registers: registers, CRDTs: crdts}
+		actor.startAntiEntropy()
+		if actor.partitioned() {
+			actor.syncPartitioned()
+		} else if actor.Config.Gossip.Fanout > 0 {
+			actor.gossip(syn)
+		} else {
+			for index, a := range actor.ActorsInfo {
+				if index != actor.Me {
+					actor.Context.Tell(a, actor.withAntiEntropy(a, syn))
+				}
 			}
+			for _, remote := range actor.RemoteInfo {
+				actor.Context.Tell(remote[0], actor.withAntiEntropy(remote[0], syn))
+			}
+			actor.Logs = make(map[string]MPut)
+			actor.RegisterLogs = make(map[string]bool)
+			actor.CRDTLogs = make(map[string]bool)
+			actor.Rumors = make(map[string]int)
+		}
+		// Anti-entropy for query actors the actor did not sync with.
+		for _, p := range actor.AntiEntropyOut {
+			actor.Context.Tell(p.Ref, actor.withAntiEntropy(p.Ref, SynMsg{}))
 		}
-		for _, remote := range actor.RemoteInfo {
-			actor.Context.Tell(remote[0], SynMsg{Data: actor.Logs})
+		actor.collectTombstones()
+		if err := actor.Engine.Flush(); err != nil && actor.EngineErr == nil {
+			actor.EngineErr = err
 		}
-		actor.Logs = make(map[string]MPut)
 		actor.Context.TellAfter(actor.ActorsInfo[actor.Me], SynSignal{}, 100*time.Millisecond)
 
 	case SynMsg:
-		for _, data := range m.Data {
-			doPut := false
-
-			if v, ok := actor.Store[data.Key]; ok {
-				if data.Timestamp > v.Timestamp {
-					doPut = true
-				} else if data.Timestamp == v.Timestamp && data.Sender.Uid() < v.Sender.Uid() {
-					doPut = true
-				}
-			} else {
-				doPut = true
-			}
-
-			if doPut {
-				actor.Store[data.Key] = StoreValue{data.Sender, data.Timestamp, data.Value}
-				actor.Logs[data.Key] = data
-			}
+		actor.merge(m)
+		if m.Pull != nil {
+			actor.Pulls[m.Pull.Uid()] = m.Pull
+		}
+		if m.AntiEntropy != nil {
+			actor.onAntiEntropy(m.AntiEntropy)
 		}
 
 	case Init:
 		actor.ActorsInfo = append(actor.ActorsInfo, m.ActorsInfo...)
+		actor.Config = m.Config
 		actor.RemoteInfo = append(actor.RemoteInfo, m.RemoteInfo...)
 		actor.Me = m.Me
 		actor.Context.Tell(actor.ActorsInfo[actor.Me], SynSignal{})
+		actor.AntiEntropyLast = time.Now()
+		actor.rebalance()
+		actor.expireDue()
 
 	case MGet:
-		v, exist := actor.Store[m.Key]
-		result := GetResult{Value: v.Value, Ok: exist}
-		actor.Context.Tell(m.Sender, result)
+		if !actor.caughtUp(m.Key, m.After) {
+			actor.waitForCatchUp(m)
+			break
+		}
+		actor.get(m)
+
+	case ReadTimeout:
+		actor.onReadTimeout(m.ID)
 
 	case MPut:
-		m.Timestamp = time.Now().UnixMilli()
-		doPut := false
-		if v, ok := actor.Store[m.Key]; ok {
-			if m.Timestamp > v.Timestamp {
-				doPut = true
-			} else if m.Timestamp == v.Timestamp && m.Sender.Uid() < v.Sender.Uid() {
-				doPut = true
+		if actor.holdIfLocked(message, m.Key) {
+			break
+		}
+		if state, ok := actor.CRDTs[m.Key]; ok {
+			actor.Context.Tell(m.Sender, WrongType{m.Key, state.Type})
+			break
+		}
+		m.Deleted = false
+		actor.Clock.observe(m.After.Timestamp)
+		result := PutResult{Version: actor.write(m)}
+		if m.Consistency != kvcommon.One {
+			actor.coordinate(m.Key, m.Consistency, m.Sender, result)
+			break
+		}
+		actor.Context.Tell(m.Sender, result)
+
+	case MGetSiblings:
+		siblings := actor.Registers[m.Key]
+		result := SiblingsResult{Values: make([]string, 0), Context: registerContext(siblings)}
+		if siblings == nil {
+			// A key in LastWriterWins conflict mode has at most one sibling.
+			if v, exist := actor.Store[m.Key]; exist {
+				siblings = []Sibling{{v.Value, v.Deleted, v.Origin, v.Timestamp, nil}}
 			}
-		} else {
-			doPut = true
 		}
-		if doPut {
-			actor.Store[m.Key] = StoreValue{m.Sender, m.Timestamp, m.Value}
-			actor.Logs[m.Key] = m
+		for _, s := range siblings {
+			if !s.Deleted {
+				result.Values = append(result.Values, s.Value)
+			}
 		}
-		result := PutResult{}
 		actor.Context.Tell(m.Sender, result)
 
+	case MCRDT:
+		state, ok := actor.updateCRDT(m)
+		result := CRDTResult{Ok: ok, Type: state.Type}
+		switch {
+		case !ok:
+		case state.Type == CounterType:
+			result.Counter = state.Counter.value()
+		case state.Type == SetType:
+			result.Members = state.Set.members()
+		case state.Type == MapType:
+			result.Entries = state.Map.entries()
+		}
+		actor.Context.Tell(m.Sender, result)
+
+	case MCondPut:
+		if actor.holdIfLocked(message, m.Key) {
+			break
+		}
+		// The check and the write happen in one message, so conditional writes are linearizable on this actor. Other
+		// replicas may accept conflicting ones; sync then keeps only the newest.
+		v, exist := actor.Store[m.Key]
+		exist = exist && !v.Deleted
+		if _, ok := actor.CRDTs[m.Key]; ok || !m.holds(v, exist) {
+			actor.Context.Tell(m.Sender, CondPutResult{Ok: false, Value: v.Value, Present: exist, Version: v.version()})
+			break
+		}
+		// The condition was checked against all siblings, so the write supersedes them.
+		context := registerContext(actor.Registers[m.Key])
+		version := actor.write(MPut{Key: m.Key, Value: m.Value, Sender: m.Sender, Context: context})
+		actor.Context.Tell(m.Sender, CondPutResult{Ok: true, Value: m.Value, Present: true, Version: version})
+
+	case MDelete:
+		if actor.holdIfLocked(message, m.Key) {
+			break
+		}
+		if _, ok := actor.CRDTs[m.Key]; ok {
+			// CRDTs cannot be deleted.
+			actor.Context.Tell(m.Sender, DeleteResult{Ok: false})
+			break
+		}
+		v, exist := actor.Store[m.Key]
+		// Write a tombstone even if the key is absent here, so the delete also wins against older Puts that have not
+		// reached this replica yet.
+		context := registerContext(actor.Registers[m.Key])
+		actor.write(MPut{Key: m.Key, Sender: m.Sender, Deleted: true, Context: context})
+		actor.Context.Tell(m.Sender, DeleteResult{Ok: exist && !v.Deleted})
+
 	case MList:
+		if actor.partitioned() {
+			actor.startScan(&pendingScan{Sender: m.Sender, List: true}, m.Prefix, prefixEnd(m.Prefix))
+			break
+		}
 		result := ListResult{Pair: make(map[string]string)}
-		for k, v := range actor.Store {
-			if strings.HasPrefix(k, m.Prefix) {
-				result.Pair[k] = v.Value
-			}
+		for k, v := range actor.rangeEntries(m.Prefix, prefixEnd(m.Prefix), 0, false) {
+			result.Pair[k] = v.Value
 		}
 		actor.Context.Tell(m.Sender, result)
 
+	case MScan:
+		limit := scanLimit(m.Limit)
+		if actor.partitioned() {
+			actor.startScan(&pendingScan{Sender: m.Sender, Limit: limit}, m.Start, m.End)
+			break
+		}
+		actor.Context.Tell(m.Sender, scanResult(actor.rangeEntries(m.Start, m.End, limit+1, false), limit))
+
+	case MBatch:
+		actor.applyBatch(m)
+
+	case MCommit:
+		actor.startCommit(m)
+
+	case MPrepare:
+		actor.onPrepare(m)
+
+	case PrepareResult:
+		actor.onPrepareResult(m)
+
+	case MDecide:
+		actor.onDecide(m)
+
+	case DecideResult:
+		actor.onDecideResult(m)
+
+	case TxnTimeout:
+		actor.onTxnTimeout(m)
+
+	case LockTimeout:
+		actor.release(m.ID)
+
+	case MWatch:
+		actor.watch(m)
+
+	case WatchTimeout:
+		actor.onWatchTimeout(m.ID)
+
+	case ExpireSignal:
+		actor.onExpireSignal(m)
+
+	case MMultiGet:
+		if actor.partitioned() {
+			actor.startMultiGet(m)
+			break
+		}
+		actor.Context.Tell(m.Sender, multiGetResult(actor.keyEntries(m.Keys)))
+
+	case MScanPart:
+		if m.Keys != nil {
+			actor.Context.Tell(m.Sender, ScanPart{m.ID, actor.keyEntries(m.Keys)})
+			break
+		}
+		actor.Context.Tell(m.Sender, ScanPart{m.ID, actor.rangeEntries(m.Start, m.End, m.Limit, true)})
+
+	case ScanPart:
+		actor.addScanPart(m)
+
+	case ScanTimeout:
+		actor.finishScan(m.ID)
+
+	case MReplica:
+		actor.onReplica(m)
+
+	case ReplicaResult:
+		actor.onReplicaResult(m)
+
+	case ConsistencyTimeout:
+		actor.onConsistencyTimeout(m.ID)
+
 	default:
-		return fmt.Errorf("Unexpected counterActor message type: %T", m)
+		return fmt.Errorf("Unexpected queryActor message type: %T", m)
 	}
-	return nil
+	err := actor.EngineErr
+	actor.EngineErr = nil
+	return err
 }
@@ -1,6 +1,9 @@
 package kvserver
 
 import (
+	"errors"
+	"time"
+
 	"github.com/cmu440/actor"
 	"github.com/cmu440/kvcommon"
 )
@@ -17,13 +20,51 @@ import (
 type queryReceiver struct {
 	ActorSystem *actor.ActorSystem
 	ActorRef    *actor.ActorRef
+	// How long each RPC waits for the query actor; Config.RequestTimeout. Zero waits without limit.
+	Timeout time.Duration
+}
+
+// errNoAnswer is returned by an RPC when the query actor does not answer within queryReceiver.Timeout.
+var errNoAnswer = errors.New("kvserver: query actor did not answer in time")
+
+// errDeadline is returned by an RPC when the query actor does not answer by the request's deadline.
+var errDeadline = errors.New("kvserver: query actor did not answer by the request's deadline")
+
+// awaitUntil
+// Returns the query actor's response from channel, or an error if the response is one or does not come within
+// rcvr.Timeout, or by deadline unless it is the zero Time. The query actor's response, if it comes later, is dropped.
+func (rcvr *queryReceiver) awaitUntil(channel <-chan any, deadline time.Time) (any, error) {
+	timeout, err := rcvr.Timeout, errNoAnswer
+	if !deadline.IsZero() && (timeout <= 0 || time.Until(deadline) < timeout) {
+		// A deadline that passed already gives up at once.
+		timeout, err = time.Until(deadline), errDeadline
+	}
+	var expired <-chan time.Time
+	if timeout > 0 || err == errDeadline {
+		timer := time.NewTimer(timeout)
+		defer timer.Stop()
+		expired = timer.C
+	}
+	select {
+	case response := <-channel:
+		if err, ok := response.(error); ok {
+			return nil, err
+		}
+		return response, nil
+	case <-expired:
+		return nil, err
+	}
 }
 
 // Get implements kvcommon.QueryReceiver.Get.
 func (rcvr *queryReceiver) Get(args kvcommon.GetArgs, reply *kvcommon.GetReply) error {
 	ref, channel := rcvr.ActorSystem.NewChannelRef()
-	rcvr.ActorSystem.Tell(rcvr.ActorRef, MGet{Key: args.Key, Sender: ref})
-	tmp := <-channel
+	m := MGet{Key: args.Key, Consistency: args.Consistency, After: args.After, Sender: ref}
+	rcvr.ActorSystem.TellTraced(rcvr.ActorRef, m)
+	tmp, err := rcvr.awaitUntil(channel, args.Deadline)
+	if err != nil {
+		return err
+	}
 	reply.Value = tmp.(GetResult).Value
 	reply.Ok = tmp.(GetResult).Ok
 	return nil
@@ -33,17 +74,259 @@ func (rcvr *queryReceiver) Get(args kvcommon.GetArgs, reply *kvcommon.GetReply)
 func (rcvr *queryReceiver) List(args kvcommon.ListArgs, reply *kvcommon.ListReply) error {
 	ref, channel := rcvr.ActorSystem.NewChannelRef()
 
-	rcvr.ActorSystem.Tell(rcvr.ActorRef, MList{Prefix: args.Prefix, Sender: ref})
-	tmp := <-channel
+	rcvr.ActorSystem.TellTraced(rcvr.ActorRef, MList{Prefix: args.Prefix, Sender: ref})
+	tmp, err := rcvr.awaitUntil(channel, args.Deadline)
+	if err != nil {
+		return err
+	}
 	reply.Entries = tmp.(ListResult).Pair
 	return nil
 }
 
+// Scan implements kvcommon.QueryReceiver.Scan.
+func (rcvr *queryReceiver) Scan(args kvcommon.ScanArgs, reply *kvcommon.ScanReply) error {
+	ref, channel := rcvr.ActorSystem.NewChannelRef()
+
+	rcvr.ActorSystem.TellTraced(rcvr.ActorRef, MScan{Start: args.Start, End: args.End, Limit: args.Limit, Sender: ref})
+	tmp, err := rcvr.awaitUntil(channel, args.Deadline)
+	if err != nil {
+		return err
+	}
+	result := tmp.(ScanResult)
+	reply.Entries = result.Entries
+	reply.Next = result.Next
+	reply.More = result.More
+	return nil
+}
+
+// MultiGet implements kvcommon.QueryReceiver.MultiGet.
+func (rcvr *queryReceiver) MultiGet(args kvcommon.MultiGetArgs, reply *kvcommon.MultiGetReply) error {
+	ref, channel := rcvr.ActorSystem.NewChannelRef()
+
+	rcvr.ActorSystem.TellTraced(rcvr.ActorRef, MMultiGet{Keys: args.Keys, Sender: ref})
+	tmp, err := rcvr.awaitUntil(channel, args.Deadline)
+	if err != nil {
+		return err
+	}
+	reply.Entries = tmp.(MultiGetResult).Entries
+	return nil
+}
+
+// Watch implements kvcommon.QueryReceiver.Watch.
+func (rcvr *queryReceiver) Watch(args kvcommon.WatchArgs, reply *kvcommon.WatchReply) error {
+	ref, channel := rcvr.ActorSystem.NewChannelRef()
+
+	m := MWatch{Prefix: args.Prefix, Actor: args.Actor, Cursor: args.Cursor, After: args.After, Sender: ref}
+	rcvr.ActorSystem.TellTraced(rcvr.ActorRef, m)
+	tmp, err := rcvr.awaitUntil(channel, args.Deadline)
+	if err != nil {
+		return err
+	}
+	result := tmp.(WatchResult)
+	reply.Events = result.Events
+	reply.Actor = result.Actor
+	reply.Cursor = result.Cursor
+	return nil
+}
+
 // Put implements kvcommon.QueryReceiver.Put.
 func (rcvr *queryReceiver) Put(args kvcommon.PutArgs, reply *kvcommon.PutReply) error {
-	ref, _ := rcvr.ActorSystem.NewChannelRef()
-	//currentTime := time.Now().UnixMilli()
+	ref, channel := rcvr.ActorSystem.NewChannelRef()
+
+	m := MPut{
+		Key: args.Key, Value: args.Value, Consistency: args.Consistency, After: args.After, TTL: args.TTL, Sender: ref,
+	}
+	rcvr.ActorSystem.TellTraced(rcvr.ActorRef, m)
+	tmp, err := rcvr.awaitUntil(channel, args.Deadline)
+	if err != nil {
+		return err
+	}
+	reply.Version = tmp.(PutResult).Version
+	return nil
+}
+
+// ApplyBatch implements kvcommon.QueryReceiver.ApplyBatch.
+func (rcvr *queryReceiver) ApplyBatch(args kvcommon.BatchArgs, reply *kvcommon.BatchReply) error {
+	ref, channel := rcvr.ActorSystem.NewChannelRef()
+
+	rcvr.ActorSystem.TellTraced(rcvr.ActorRef, MBatch{Ops: args.Ops, Sender: ref})
+	tmp, err := rcvr.awaitUntil(channel, args.Deadline)
+	if err != nil {
+		return err
+	}
+	reply.Version = tmp.(BatchResult).Version
+	return nil
+}
+
+// Commit implements kvcommon.QueryReceiver.Commit.
+func (rcvr *queryReceiver) Commit(args kvcommon.CommitArgs, reply *kvcommon.CommitReply) error {
+	ref, channel := rcvr.ActorSystem.NewChannelRef()
+
+	rcvr.ActorSystem.TellTraced(rcvr.ActorRef, MCommit{Reads: args.Reads, Ops: args.Ops, Sender: ref})
+	tmp, err := rcvr.awaitUntil(channel, args.Deadline)
+	if err != nil {
+		return err
+	}
+	reply.Ok = tmp.(CommitResult).Ok
+	return nil
+}
+
+// Delete implements kvcommon.QueryReceiver.Delete.
+func (rcvr *queryReceiver) Delete(args kvcommon.DeleteArgs, reply *kvcommon.DeleteReply) error {
+	ref, channel := rcvr.ActorSystem.NewChannelRef()
+
+	rcvr.ActorSystem.TellTraced(rcvr.ActorRef, MDelete{Key: args.Key, Sender: ref})
+	tmp, err := rcvr.awaitUntil(channel, args.Deadline)
+	if err != nil {
+		return err
+	}
+	reply.Ok = tmp.(DeleteResult).Ok
+	return nil
+}
+
+// GetVersion implements kvcommon.QueryReceiver.GetVersion.
+func (rcvr *queryReceiver) GetVersion(args kvcommon.GetVersionArgs, reply *kvcommon.GetVersionReply) error {
+	ref, channel := rcvr.ActorSystem.NewChannelRef()
+
+	m := MGet{Key: args.Key, After: args.After, Versioned: true, Home: args.Home, Sender: ref}
+	rcvr.ActorSystem.TellTraced(rcvr.ActorRef, m)
+	tmp, err := rcvr.awaitUntil(channel, args.Deadline)
+	if err != nil {
+		return err
+	}
+	result := tmp.(GetVersionResult)
+	reply.Value = result.Value
+	reply.Ok = result.Ok
+	reply.Version = result.Version
+	reply.Token = result.Token
+	return nil
+}
+
+// PutVersion implements kvcommon.QueryReceiver.PutVersion.
+func (rcvr *queryReceiver) PutVersion(args kvcommon.PutArgs, reply *kvcommon.PutVersionReply) error {
+	ref, channel := rcvr.ActorSystem.NewChannelRef()
+
+	m := MPut{
+		Key: args.Key, Value: args.Value, Consistency: args.Consistency, After: args.After, TTL: args.TTL, Sender: ref,
+	}
+	rcvr.ActorSystem.TellTraced(rcvr.ActorRef, m)
+	tmp, err := rcvr.awaitUntil(channel, args.Deadline)
+	if err != nil {
+		return err
+	}
+	reply.Version = tmp.(PutResult).Version
+	return nil
+}
+
+// GetSiblings implements kvcommon.QueryReceiver.GetSiblings.
+func (rcvr *queryReceiver) GetSiblings(args kvcommon.GetSiblingsArgs, reply *kvcommon.GetSiblingsReply) error {
+	ref, channel := rcvr.ActorSystem.NewChannelRef()
+
+	rcvr.ActorSystem.TellTraced(rcvr.ActorRef, MGetSiblings{Key: args.Key, Sender: ref})
+	tmp, err := rcvr.awaitUntil(channel, args.Deadline)
+	if err != nil {
+		return err
+	}
+	reply.Values = tmp.(SiblingsResult).Values
+	reply.Context = tmp.(SiblingsResult).Context
+	return nil
+}
+
+// PutWithContext implements kvcommon.QueryReceiver.PutWithContext.
+func (rcvr *queryReceiver) PutWithContext(args kvcommon.PutWithContextArgs, reply *kvcommon.PutReply) error {
+	ref, channel := rcvr.ActorSystem.NewChannelRef()
+
+	rcvr.ActorSystem.TellTraced(rcvr.ActorRef, MPut{Key: args.Key, Value: args.Value, Context: args.Context, Sender: ref})
+	tmp, err := rcvr.awaitUntil(channel, args.Deadline)
+	if err != nil {
+		return err
+	}
+	reply.Version = tmp.(PutResult).Version
+	return nil
+}
+
+// PutIfAbsent implements kvcommon.QueryReceiver.PutIfAbsent.
+func (rcvr *queryReceiver) PutIfAbsent(args kvcommon.PutIfAbsentArgs, reply *kvcommon.ConditionalPutReply) error {
+	return rcvr.condPut(MCondPut{Key: args.Key, Value: args.Value, Cond: CondAbsent}, args.Deadline, reply)
+}
+
+// CompareAndSet implements kvcommon.QueryReceiver.CompareAndSet.
+func (rcvr *queryReceiver) CompareAndSet(args kvcommon.CompareAndSetArgs, reply *kvcommon.ConditionalPutReply) error {
+	m := MCondPut{Key: args.Key, Value: args.Value, Cond: CondValue, Expected: args.Expected}
+	return rcvr.condPut(m, args.Deadline, reply)
+}
+
+// PutIfVersion implements kvcommon.QueryReceiver.PutIfVersion.
+func (rcvr *queryReceiver) PutIfVersion(args kvcommon.PutIfVersionArgs, reply *kvcommon.ConditionalPutReply) error {
+	m := MCondPut{Key: args.Key, Value: args.Value, Cond: CondVersion, Version: args.Version}
+	return rcvr.condPut(m, args.Deadline, reply)
+}
+
+// condPut
+// Sends the conditional write m to the query actor and fills in reply from its result, giving up at deadline.
+func (rcvr *queryReceiver) condPut(m MCondPut, deadline time.Time, reply *kvcommon.ConditionalPutReply) error {
+	ref, channel := rcvr.ActorSystem.NewChannelRef()
+	m.Sender = ref
+
+	rcvr.ActorSystem.TellTraced(rcvr.ActorRef, m)
+	tmp, err := rcvr.awaitUntil(channel, deadline)
+	if err != nil {
+		return err
+	}
+	result := tmp.(CondPutResult)
+	reply.Ok = result.Ok
+	reply.Value = result.Value
+	reply.Present = result.Present
+	reply.Version = result.Version
+	return nil
+}
+
+// Increment implements kvcommon.QueryReceiver.Increment.
+func (rcvr *queryReceiver) Increment(args kvcommon.IncrementArgs, reply *kvcommon.CRDTReply) error {
+	return rcvr.crdt(MCRDT{Key: args.Key, Op: OpIncrement, Delta: args.Delta}, args.Deadline, reply)
+}
+
+// SetAdd implements kvcommon.QueryReceiver.SetAdd.
+func (rcvr *queryReceiver) SetAdd(args kvcommon.SetArgs, reply *kvcommon.CRDTReply) error {
+	return rcvr.crdt(MCRDT{Key: args.Key, Op: OpSetAdd, Member: args.Member}, args.Deadline, reply)
+}
+
+// SetRemove implements kvcommon.QueryReceiver.SetRemove.
+func (rcvr *queryReceiver) SetRemove(args kvcommon.SetArgs, reply *kvcommon.CRDTReply) error {
+	return rcvr.crdt(MCRDT{Key: args.Key, Op: OpSetRemove, Member: args.Member}, args.Deadline, reply)
+}
+
+// MapSet implements kvcommon.QueryReceiver.MapSet.
+func (rcvr *queryReceiver) MapSet(args kvcommon.MapArgs, reply *kvcommon.CRDTReply) error {
+	return rcvr.crdt(MCRDT{Key: args.Key, Op: OpMapSet, Field: args.Field, Value: args.Value}, args.Deadline, reply)
+}
+
+// MapDelete implements kvcommon.QueryReceiver.MapDelete.
+func (rcvr *queryReceiver) MapDelete(args kvcommon.MapArgs, reply *kvcommon.CRDTReply) error {
+	return rcvr.crdt(MCRDT{Key: args.Key, Op: OpMapDelete, Field: args.Field}, args.Deadline, reply)
+}
+
+// GetCRDT implements kvcommon.QueryReceiver.GetCRDT.
+func (rcvr *queryReceiver) GetCRDT(args kvcommon.GetCRDTArgs, reply *kvcommon.CRDTReply) error {
+	return rcvr.crdt(MCRDT{Key: args.Key, Op: OpRead}, args.Deadline, reply)
+}
+
+// crdt
+// Sends the CRDT operation m to the query actor and fills in reply from its result, giving up at deadline.
+func (rcvr *queryReceiver) crdt(m MCRDT, deadline time.Time, reply *kvcommon.CRDTReply) error {
+	ref, channel := rcvr.ActorSystem.NewChannelRef()
+	m.Sender = ref
 
-	rcvr.ActorSystem.Tell(rcvr.ActorRef, MPut{Key: args.Key, Value: args.Value, Sender: ref})
+	rcvr.ActorSystem.TellTraced(rcvr.ActorRef, m)
+	tmp, err := rcvr.awaitUntil(channel, deadline)
+	if err != nil {
+		return err
+	}
+	result := tmp.(CRDTResult)
+	reply.Ok = result.Ok
+	reply.Type = result.Type.String()
+	reply.Counter = result.Counter
+	reply.Members = result.Members
+	reply.Entries = result.Entries
 	return nil
 }
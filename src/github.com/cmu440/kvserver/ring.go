@@ -0,0 +1,74 @@
+package kvserver
+
+import (
+	"fmt"
+	"hash/fnv"
+	"sort"
+
+	"github.com/cmu440/actor"
+)
+
+// Virtual nodes per query actor on the ring, to spread keys evenly.
+const ringVirtualNodes = 64
+
+// ring
+// A consistent hash ring over query actors, placing each key on the Config.Replicas query actors that follow the key's
+// hash on the ring. When query actors join, only the keys whose hashes fall next to the newcomers' virtual nodes move.
+type ring struct {
+	hashes []uint64
+	// Query actor at each hash.
+	refs map[uint64]*actor.ActorRef
+	// Number of distinct query actors on the ring.
+	size int
+}
+
+// ringHash
+// Returns the position of s on the ring. FNV-1a barely changes the high bits of the hash between strings that differ
+// only in their last bytes, e.g. "key1" and "key2", which would put them next to each other on the ring; the
+// splitmix64 finalizer spreads them.
+func ringHash(s string) uint64 {
+	h := fnv.New64a()
+	h.Write([]byte(s))
+	x := h.Sum64()
+	x = (x ^ (x >> 30)) * 0xbf58476d1ce4e5b9
+	x = (x ^ (x >> 27)) * 0x94d049bb133111eb
+	return x ^ (x >> 31)
+}
+
+// newRing
+// Returns the ring over the given query actors.
+func newRing(refs []*actor.ActorRef) ring {
+	r := ring{refs: make(map[uint64]*actor.ActorRef), size: len(refs)}
+	for _, ref := range refs {
+		for i := 0; i < ringVirtualNodes; i++ {
+			hash := ringHash(fmt.Sprintf("%s#%d", ref.Uid(), i))
+			r.hashes = append(r.hashes, hash)
+			r.refs[hash] = ref
+		}
+	}
+	sort.Slice(r.hashes, func(i, j int) bool { return r.hashes[i] < r.hashes[j] })
+	return r
+}
+
+// owners
+// Returns the n query actors holding key, primary first; none if the ring is empty.
+func (r ring) owners(key string, n int) []*actor.ActorRef {
+	if len(r.hashes) == 0 {
+		return nil
+	}
+	n = min(n, r.size)
+	owners := make([]*actor.ActorRef, 0, n)
+	hash := ringHash(key)
+	start := sort.Search(len(r.hashes), func(i int) bool { return r.hashes[i] >= hash })
+	for i := 0; len(owners) < n; i++ {
+		ref := r.refs[r.hashes[(start+i)%len(r.hashes)]]
+		duplicate := false
+		for _, owner := range owners {
+			duplicate = duplicate || owner.Uid() == ref.Uid()
+		}
+		if !duplicate {
+			owners = append(owners, ref)
+		}
+	}
+	return owners
+}
@@ -0,0 +1,196 @@
+package kvserver
+
+import (
+	"sort"
+
+	"github.com/cmu440/actor"
+	"github.com/cmu440/kvcommon"
+)
+
+// Most entries a Scan returns at once.
+const maxScanLimit = 1000
+
+// MScan is the message type for SCAN requests: up to Limit entries with keys in [Start, End), in key order. An empty
+// End has no bound.
+type MScan struct {
+	Start  string
+	End    string
+	Limit  int
+	Sender *actor.ActorRef
+}
+
+// ScanResult is the message type for SCAN responses. If More, the scan continues from key Next.
+type ScanResult struct {
+	Entries []kvcommon.KeyValue
+	Next    string
+	More    bool
+}
+
+// Most levels of a keyIndex.
+const indexMaxLevels = 32
+
+// keyIndex
+// The keys of a query actor's Store in sorted order, so that scans visit only the keys in their range. A skip list, so
+// that inserts and removes take O(log n) expected time. The zero keyIndex is empty.
+type keyIndex struct {
+	// Sentinel before the first key, linked on every level; allocated by the first insert.
+	head *indexNode
+	// Number of levels in use.
+	levels int
+	// State of the generator of node levels.
+	seed uint64
+}
+
+// indexNode
+// A key of a keyIndex, with its links to the next keys on each of its levels.
+type indexNode struct {
+	key  string
+	next []*indexNode
+}
+
+// find
+// Returns the last node before key on each level in use; the node after the one on level 0 is key's, if in the index.
+func (index *keyIndex) find(key string) (before [indexMaxLevels]*indexNode) {
+	node := index.head
+	for level := index.levels - 1; level >= 0; level-- {
+		for node.next[level] != nil && node.next[level].key < key {
+			node = node.next[level]
+		}
+		before[level] = node
+	}
+	return before
+}
+
+// insert
+// Adds key to the index, if not in it yet.
+func (index *keyIndex) insert(key string) {
+	if index.head == nil {
+		index.head = &indexNode{next: make([]*indexNode, indexMaxLevels)}
+		index.levels = 1
+	}
+	before := index.find(key)
+	if next := before[0].next[0]; next != nil && next.key == key {
+		return
+	}
+	levels := index.randomLevels()
+	for ; index.levels < levels; index.levels++ {
+		before[index.levels] = index.head
+	}
+	node := &indexNode{key: key, next: make([]*indexNode, levels)}
+	for level := 0; level < levels; level++ {
+		node.next[level] = before[level].next[level]
+		before[level].next[level] = node
+	}
+}
+
+// remove
+// Removes key from the index, if in it.
+func (index *keyIndex) remove(key string) {
+	if index.head == nil {
+		return
+	}
+	before := index.find(key)
+	node := before[0].next[0]
+	if node == nil || node.key != key {
+		return
+	}
+	for level := range node.next {
+		before[level].next[level] = node.next[level]
+	}
+	for index.levels > 1 && index.head.next[index.levels-1] == nil {
+		index.levels--
+	}
+}
+
+// scan
+// Calls visit on the keys in [start, end) in order, until it returns false. An empty end has no bound. visit may
+// remove the key it is called on.
+func (index *keyIndex) scan(start string, end string, visit func(key string) bool) {
+	if index.head == nil {
+		return
+	}
+	for node := index.find(start)[0].next[0]; node != nil; node = node.next[0] {
+		if end != "" && node.key >= end {
+			return
+		}
+		if !visit(node.key) {
+			return
+		}
+	}
+}
+
+// randomLevels
+// Returns the number of levels of a new node: 1, plus one more with probability 1/4 each, up to indexMaxLevels.
+func (index *keyIndex) randomLevels() int {
+	// xorshift64, seeded with any nonzero constant: the levels need not be unpredictable, only spread.
+	if index.seed == 0 {
+		index.seed = 0x9e3779b97f4a7c15
+	}
+	index.seed ^= index.seed << 13
+	index.seed ^= index.seed >> 7
+	index.seed ^= index.seed << 17
+	levels := 1
+	for bits := index.seed; levels < indexMaxLevels && bits&3 == 0; bits >>= 2 {
+		levels++
+	}
+	return levels
+}
+
+// prefixEnd
+// Returns the least key greater than all keys starting with prefix, or "" if there is none, as the end of a scan of the
+// keys with prefix.
+func prefixEnd(prefix string) string {
+	end := []byte(prefix)
+	for i := len(end) - 1; i >= 0; i-- {
+		if end[i] < 0xff {
+			end[i]++
+			return string(end[:i+1])
+		}
+	}
+	return ""
+}
+
+// scanLimit
+// Returns the number of entries to return for a Scan asking for limit.
+func scanLimit(limit int) int {
+	if limit <= 0 || limit > maxScanLimit {
+		return maxScanLimit
+	}
+	return limit
+}
+
+// rangeEntries
+// Returns up to limit of the actor's entries with keys in [start, end), the first ones in key order, with tombstones
+// if withDeleted. Zero limit has no bound.
+func (actor *queryActor) rangeEntries(start string, end string, limit int, withDeleted bool) map[string]StoreValue {
+	entries := make(map[string]StoreValue)
+	actor.Index.scan(start, end, func(key string) bool {
+		actor.expireIfDue(key)
+		if v := actor.Store[key]; withDeleted || !v.Deleted {
+			entries[key] = v
+		}
+		return limit == 0 || len(entries) < limit
+	})
+	return entries
+}
+
+// scanResult
+// Returns the ScanResult for up to limit of entries, the first ones in key order, skipping tombstones.
+func scanResult(entries map[string]StoreValue, limit int) ScanResult {
+	keys := make([]string, 0, len(entries))
+	for key := range entries {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	result := ScanResult{Entries: make([]kvcommon.KeyValue, 0)}
+	if len(keys) > limit {
+		result.Next, result.More = keys[limit], true
+		keys = keys[:limit]
+	}
+	for _, key := range keys {
+		if v := entries[key]; !v.Deleted {
+			result.Entries = append(result.Entries, kvcommon.KeyValue{Key: key, Value: v.Value})
+		}
+	}
+	return result
+}
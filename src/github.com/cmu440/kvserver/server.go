@@ -20,6 +20,8 @@ import (
 type Server struct {
 	AS        *actor.ActorSystem
 	ActorInfo []*actor.ActorRef
+	engines   []Engine
+	listeners []net.Listener
 }
 
 // OPTIONAL: Error handler for ActorSystem.OnError.
@@ -44,11 +46,37 @@ func errorHandler(err error) {
 // Before returning, NewServer starts the ActorSystem, all query actors, and all query RPC servers.
 // If there is an error starting anything, that error is returned instead.
 func NewServer(startPort int, queryActorCount int, remoteDescs []string) (server *Server, desc string, err error) {
+	return NewServerWithConfig(startPort, queryActorCount, remoteDescs, DefaultConfig())
+}
+
+// NewServerWithConfig Same as NewServer, but with the given Config instead of DefaultConfig().
+//
+// If config.DataDir is set, each query actor first recovers the state it had there before a crash or Close.
+func NewServerWithConfig(startPort int, queryActorCount int, remoteDescs []string, config Config) (server *Server, desc string, err error) {
 	// Tips:
 	// - The "HTTP service" example in the net/rpc docs does not support multiple RPC servers in the same process.
 	// Instead, use the following template to start RPC servers (adapted from
 	// https://groups.google.com/g/Golang-Nuts/c/JTn3LV_bd5M/m/cMO_DLyHPeUJ ):
+	engines := make([]Engine, 0)
+	recovered := make([]map[string]Entry, 0)
+	for i := 0; i < queryActorCount; i++ {
+		engine, err := openEngine(config, i)
+		var entries map[string]Entry
+		if err == nil {
+			entries, err = engine.Recover()
+		}
+		if err != nil {
+			for _, e := range engines {
+				e.Close()
+			}
+			return nil, "", err
+		}
+		engines = append(engines, engine)
+		recovered = append(recovered, entries)
+	}
+
 	actorsInfo := make([]*actor.ActorRef, 0)
+	listeners := make([]net.Listener, 0)
 	actorSystem, _ := actor.NewActorSystem(startPort)
 
 	for i := 1; i < queryActorCount+1; i++ {
@@ -59,6 +87,9 @@ func NewServer(startPort int, queryActorCount int, remoteDescs []string) (server
 			return nil, "", err
 		}
 		ln, _ := net.Listen("tcp", ":"+strconv.Itoa(startPort+i))
+		if ln != nil {
+			listeners = append(listeners, ln)
+		}
 		go func() {
 			for {
 				conn, err := ln.Accept()
@@ -69,7 +100,14 @@ func NewServer(startPort int, queryActorCount int, remoteDescs []string) (server
 			}
 		}()
 		q.ActorSystem = actorSystem
-		rf := actorSystem.StartActor(newQueryActor)
+		q.Timeout = config.RequestTimeout
+		engine, entries := engines[i-1], recovered[i-1]
+		rf := actorSystem.StartActor(func(context *actor.ActorContext) actor.Actor {
+			q := newQueryActor(context).(*queryActor)
+			q.Engine = engine
+			q.restore(entries)
+			return q
+		})
 		q.ActorRef = rf
 		actorsInfo = append(actorsInfo, rf)
 	}
@@ -89,7 +127,7 @@ func NewServer(startPort int, queryActorCount int, remoteDescs []string) (server
 	}
 
 	for index, ref := range actorsInfo {
-		actorSystem.Tell(ref, Init{ActorsInfo: actorsInfo, Me: index, RemoteInfo: RemoteServers})
+		actorSystem.Tell(ref, Init{ActorsInfo: actorsInfo, Config: config, Me: index, RemoteInfo: RemoteServers})
 	}
 	for _, oldServer := range RemoteServers {
 		for _, oldActor := range oldServer {
@@ -97,7 +135,7 @@ func NewServer(startPort int, queryActorCount int, remoteDescs []string) (server
 		}
 	}
 
-	s := Server{AS: actorSystem, ActorInfo: actorsInfo}
+	s := Server{AS: actorSystem, ActorInfo: actorsInfo, engines: engines, listeners: listeners}
 
 	jsonData, err := json.Marshal(s.ActorInfo)
 	if err != nil {
@@ -109,12 +147,15 @@ func NewServer(startPort int, queryActorCount int, remoteDescs []string) (server
 	return &s, desc, nil
 }
 
-// Close OPTIONAL: Closes the server, including its actor system nd all RPC servers.
+// Close Closes the server, including its actor system, all RPC servers, and the query actors' storage engines.
 //
-// You are not required to implement this function for full credit; the tests end by calling Close but do not check
-// that it does anything. However, you may find it useful to implement this so that you can run multiple/repeated
-// tests in the same "go test" command without cross-test interference (in particular, old test servers' squatting on ports.)
-//
-// Likewise, you may find it useful to close a partially-started server's resources if there is an error in NewServer.
+// With Config.DataDir set, a server started later with the same DataDir recovers the closed server's state.
 func (server *Server) Close() {
+	server.AS.Close()
+	for _, ln := range server.listeners {
+		ln.Close()
+	}
+	for _, engine := range server.engines {
+		engine.Close()
+	}
 }
@@ -0,0 +1,78 @@
+package kvserver
+
+import (
+	"fmt"
+	"path/filepath"
+)
+
+// Engine
+// Storage for a query actor's state.
+//
+// The query actor keeps its state in memory to answer queries, and writes every change to a key through to its Engine.
+// When a server starts, each query actor recovers its state from its Engine.
+//
+// An Engine is used by one query actor at a time, except that Close may be called concurrently with the others.
+type Engine interface {
+	// Recover
+	// Returns the entries written before, by key.
+	Recover() (map[string]Entry, error)
+
+	// Write
+	// Records key's entry, or that key was removed if entry is nil.
+	Write(key string, entry *Entry) error
+
+	// Flush
+	// Called after every sync; makes earlier writes durable if the Engine's policy defers that.
+	Flush() error
+
+	// Close
+	// Flushes and releases the Engine. Later calls fail.
+	Close() error
+}
+
+// Entry
+// Everything a query actor stores for a key.
+type Entry struct {
+	Value StoreValue
+	// For keys in MultiValue conflict mode.
+	Siblings []Sibling
+	// For keys holding CRDTs.
+	CRDT *CRDT
+}
+
+// openEngine
+// Returns the Engine for query actor me as set up by config: in memory if config.DataDir is empty, otherwise on disk
+// in a directory of its own under config.DataDir.
+func openEngine(config Config, me int) (Engine, error) {
+	if config.DataDir == "" {
+		return newMemoryEngine(), nil
+	}
+	dir := filepath.Join(config.DataDir, fmt.Sprintf("actor%d", me))
+	return openDiskEngine(dir, config.Fsync, config.SnapshotEvery)
+}
+
+// memoryEngine
+// An Engine that stores nothing, so state lives only in the query actor's memory and is lost with the server. Used when
+// Config.DataDir is empty.
+type memoryEngine struct {
+}
+
+func newMemoryEngine() *memoryEngine {
+	return &memoryEngine{}
+}
+
+func (engine *memoryEngine) Recover() (map[string]Entry, error) {
+	return make(map[string]Entry), nil
+}
+
+func (engine *memoryEngine) Write(key string, entry *Entry) error {
+	return nil
+}
+
+func (engine *memoryEngine) Flush() error {
+	return nil
+}
+
+func (engine *memoryEngine) Close() error {
+	return nil
+}
@@ -0,0 +1,334 @@
+package kvserver
+
+import (
+	"github.com/cmu440/actor"
+	"github.com/cmu440/kvcommon"
+)
+
+// Transactions
+//
+// A client transaction reads keys, noting the version of each (tombstones included, see StoreValue.token), and
+// buffers its writes until it commits. Each key has a home: its primary query actor on the ring of all query actors.
+// Transactions read a key on its home (MGet.Home), and the home validates and applies their writes of it.
+//
+// The query actor a commit is sent to coordinates it by two-phase commit among the homes of the transaction's keys:
+//  1. It sends each home an MPrepare with the transaction's reads and written keys there. The home votes yes if no
+//     other transaction locked any of the keys and the versions read are still current, and then locks the keys.
+//  2. If all homes vote yes within Config.TxnTimeout, it sends each home an MDecide with the writes there, which the
+//     home applies like a batch (see batch.go), and acknowledges. The coordinator resends the MDecide to the homes
+//     that did not acknowledge it every Config.TxnTimeout, and answers once all homes did. Otherwise it sends all
+//     homes an MDecide to abort, which releases the locks, and answers that the transaction aborted.
+//
+// Each vote carries the home's clock, and a commit decision a timestamp later than all of them, at which every home
+// applies its writes. So the writes supersede whatever the homes had when they voted, and a home that gets the
+// decision again, e.g. because its acknowledgement was lost, writes the same versions again, which changes nothing.
+//
+// A home releases the locks of a transaction it hears nothing more of after twice Config.TxnTimeout, so a lost
+// decision does not block its keys forever.
+//
+// While a transaction locks a key on its home, the home holds plain writes of the key (Puts, conditional writes,
+// Deletes and batches) until the locks are released, and then handles them after the transaction's writes. So a write
+// that reached the home before it voted makes the transaction abort, and one that reached it later is applied after
+// the commit, e.g. a CompareAndSet then sees the committed value. Plain writes served by other replicas are not held.
+
+// TxnID
+// Identifies a transaction: the Uid of its coordinator, and a sequence number there.
+type TxnID struct {
+	Coordinator string
+	Seq         int
+}
+
+// MCommit is the message type for transaction commit requests.
+type MCommit struct {
+	Reads  []kvcommon.TxnRead
+	Ops    []kvcommon.BatchOp
+	Sender *actor.ActorRef
+}
+
+// CommitResult is the message type for MCommit responses. Ok is false if the transaction aborted.
+type CommitResult struct {
+	Ok bool
+}
+
+// MPrepare is the message type for asking a home to validate and lock its keys of transaction ID: the keys read, with
+// the versions read, and the keys written.
+type MPrepare struct {
+	ID          TxnID
+	Reads       []kvcommon.TxnRead
+	Writes      []string
+	Coordinator *actor.ActorRef
+}
+
+// PrepareResult is the message type for a home's vote on an MPrepare, with the home's clock.
+type PrepareResult struct {
+	ID        TxnID
+	Ok        bool
+	Timestamp int64
+}
+
+// MDecide is the message type for the coordinator's decision on transaction ID: commit, writing Ops at Timestamp, or
+// abort.
+type MDecide struct {
+	ID          TxnID
+	Commit      bool
+	Ops         []kvcommon.BatchOp
+	Timestamp   int64
+	Coordinator *actor.ActorRef
+}
+
+// DecideResult is the message type for a home's acknowledgement of a commit decision. Home is the home's Uid.
+type DecideResult struct {
+	ID   TxnID
+	Home string
+}
+
+// TxnTimeout is the message type for giving up waiting for the votes of the homes of the coordinator's transaction
+// Seq, or if Commit, for resending the decision to the homes that did not acknowledge it.
+type TxnTimeout struct {
+	Seq    int
+	Commit bool
+}
+
+// LockTimeout is the message type for releasing the locks of transaction ID.
+type LockTimeout struct {
+	ID TxnID
+}
+
+// txnPart
+// A transaction's reads and writes of the keys of one home.
+type txnPart struct {
+	Home  *actor.ActorRef
+	Reads []kvcommon.TxnRead
+	Ops   []kvcommon.BatchOp
+	// Whether the home acknowledged the commit decision.
+	Acked bool
+}
+
+// pendingTxn
+// A transaction being committed by its coordinator.
+type pendingTxn struct {
+	Sender *actor.ActorRef
+	// Parts by Uid of their home.
+	Parts map[string]*txnPart
+	// Whether the transaction was decided to commit.
+	Committed bool
+	// Number of votes, or after the decision to commit, acknowledgements, still to come.
+	Waiting int
+	// The latest clock of the homes that voted, and after the decision to commit, the timestamp of the writes.
+	Timestamp int64
+}
+
+// home
+// Returns the query actor that validates transactions on key, which is also its primary owner in partitioned mode.
+// Before the ring is built, that is the actor itself.
+func (actor *queryActor) home(key string) *actor.ActorRef {
+	if owners := actor.Ring.owners(key, 1); len(owners) > 0 {
+		return owners[0]
+	}
+	return actor.Context.Self
+}
+
+// part
+// Returns the part of txn for home, adding it if there is none.
+func (txn *pendingTxn) part(home *actor.ActorRef) *txnPart {
+	p, ok := txn.Parts[home.Uid()]
+	if !ok {
+		p = &txnPart{Home: home}
+		txn.Parts[home.Uid()] = p
+	}
+	return p
+}
+
+// startCommit
+// Starts committing the transaction m as its coordinator, asking the homes of its keys to prepare.
+func (actor *queryActor) startCommit(m MCommit) {
+	seq := actor.NextTxn
+	actor.NextTxn++
+	txn := &pendingTxn{Sender: m.Sender, Parts: make(map[string]*txnPart)}
+	for _, read := range m.Reads {
+		p := txn.part(actor.home(read.Key))
+		p.Reads = append(p.Reads, read)
+	}
+	for _, op := range m.Ops {
+		p := txn.part(actor.home(op.Key))
+		p.Ops = append(p.Ops, op)
+	}
+	if len(txn.Parts) == 0 {
+		actor.Context.Tell(m.Sender, CommitResult{Ok: true})
+		return
+	}
+
+	id := TxnID{actor.Context.Self.Uid(), seq}
+	for _, p := range txn.Parts {
+		writes := make([]string, 0, len(p.Ops))
+		for _, op := range p.Ops {
+			writes = append(writes, op.Key)
+		}
+		actor.Context.Tell(p.Home, MPrepare{id, p.Reads, writes, actor.Context.Self})
+	}
+	txn.Waiting = len(txn.Parts)
+	actor.Txns[seq] = txn
+	actor.Context.TellAfter(actor.Context.Self, TxnTimeout{Seq: seq}, actor.Config.TxnTimeout)
+}
+
+// onPrepare
+// Votes on m as the home of its keys, locking them if the vote is yes.
+func (actor *queryActor) onPrepare(m MPrepare) {
+	keys := append(m.Writes[:0:0], m.Writes...)
+	for _, read := range m.Reads {
+		keys = append(keys, read.Key)
+	}
+	ok := true
+	for _, key := range keys {
+		if id, locked := actor.Locks[key]; locked && id != m.ID {
+			ok = false
+		}
+	}
+	for _, read := range m.Reads {
+		if actor.Store[read.Key].token() != read.Version {
+			ok = false
+		}
+	}
+	if ok {
+		for _, key := range keys {
+			actor.Locks[key] = m.ID
+		}
+		actor.Prepared[m.ID] = keys
+		actor.Context.TellAfter(actor.Context.Self, LockTimeout{m.ID}, 2*actor.Config.TxnTimeout)
+	}
+	actor.Context.Tell(m.Coordinator, PrepareResult{m.ID, ok, actor.Clock.now()})
+}
+
+// onPrepareResult
+// Counts a home's vote, deciding once one is no or all are yes.
+func (actor *queryActor) onPrepareResult(r PrepareResult) {
+	txn, ok := actor.Txns[r.ID.Seq]
+	if !ok || txn.Committed {
+		return
+	}
+	if !r.Ok {
+		actor.decide(r.ID.Seq, false)
+		return
+	}
+	txn.Waiting--
+	txn.Timestamp = max(txn.Timestamp, r.Timestamp)
+	if txn.Waiting == 0 {
+		actor.decide(r.ID.Seq, true)
+	}
+}
+
+// decide
+// Sends the decision on transaction seq to its homes. An aborted transaction is answered right away, a committed one
+// once its homes acknowledged the decision.
+func (actor *queryActor) decide(seq int, commit bool) {
+	txn := actor.Txns[seq]
+	if commit {
+		actor.Clock.observe(txn.Timestamp)
+		txn.Timestamp = actor.Clock.now()
+	}
+	for _, p := range txn.Parts {
+		actor.sendDecision(seq, txn, p, commit)
+	}
+	if !commit {
+		delete(actor.Txns, seq)
+		actor.Context.Tell(txn.Sender, CommitResult{Ok: false})
+		return
+	}
+	txn.Committed = true
+	txn.Waiting = len(txn.Parts)
+	actor.Context.TellAfter(actor.Context.Self, TxnTimeout{Seq: seq, Commit: true}, actor.Config.TxnTimeout)
+}
+
+// sendDecision
+// Sends the decision on transaction seq to the home of its part p.
+func (actor *queryActor) sendDecision(seq int, txn *pendingTxn, p *txnPart, commit bool) {
+	decision := MDecide{ID: TxnID{actor.Context.Self.Uid(), seq}, Commit: commit, Coordinator: actor.Context.Self}
+	if commit {
+		decision.Ops = p.Ops
+		decision.Timestamp = txn.Timestamp
+	}
+	actor.Context.Tell(p.Home, decision)
+}
+
+// onDecide
+// Applies the decision m as the home of its keys, and releases their locks.
+func (actor *queryActor) onDecide(m MDecide) {
+	// A commit is applied even if the locks timed out: all homes voted yes, so the writes must not be lost.
+	if m.Commit && len(m.Ops) > 0 {
+		actor.Clock.observe(m.Timestamp)
+		actor.writeBatchAt(m.Ops, m.Timestamp)
+	}
+	actor.release(m.ID)
+	if m.Commit {
+		actor.Context.Tell(m.Coordinator, DecideResult{m.ID, actor.Context.Self.Uid()})
+	}
+}
+
+// onDecideResult
+// Counts a home's acknowledgement of a commit, answering the client once all homes acknowledged.
+func (actor *queryActor) onDecideResult(r DecideResult) {
+	txn, ok := actor.Txns[r.ID.Seq]
+	if !ok || !txn.Committed {
+		return
+	}
+	p := txn.Parts[r.Home]
+	if p == nil || p.Acked {
+		return
+	}
+	p.Acked = true
+	txn.Waiting--
+	if txn.Waiting == 0 {
+		delete(actor.Txns, r.ID.Seq)
+		actor.Context.Tell(txn.Sender, CommitResult{Ok: true})
+	}
+}
+
+// onTxnTimeout
+// Aborts transaction m.Seq if votes are missing, or resends the commit decision to the homes that did not acknowledge
+// it. The client is answered only once all homes did; if that takes too long, its RPC fails, leaving the outcome
+// unknown to it.
+func (actor *queryActor) onTxnTimeout(m TxnTimeout) {
+	txn, ok := actor.Txns[m.Seq]
+	if !ok || txn.Committed != m.Commit {
+		return
+	}
+	if !txn.Committed {
+		actor.decide(m.Seq, false)
+		return
+	}
+	for _, p := range txn.Parts {
+		if !p.Acked {
+			actor.sendDecision(m.Seq, txn, p, true)
+		}
+	}
+	actor.Context.TellAfter(actor.Context.Self, TxnTimeout{Seq: m.Seq, Commit: true}, actor.Config.TxnTimeout)
+}
+
+// release
+// Releases the locks of transaction id, and handles the writes held for its keys again, after the messages already
+// waiting for the actor.
+func (actor *queryActor) release(id TxnID) {
+	for _, key := range actor.Prepared[id] {
+		if actor.Locks[key] == id {
+			delete(actor.Locks, key)
+			for _, message := range actor.Held[key] {
+				actor.Context.Tell(actor.Context.Self, message)
+			}
+			delete(actor.Held, key)
+		}
+	}
+	delete(actor.Prepared, id)
+}
+
+// holdIfLocked
+// Holds the write message of keys if a prepared transaction locks any of them, until release. Returns whether it held
+// message.
+func (actor *queryActor) holdIfLocked(message any, keys ...string) bool {
+	for _, key := range keys {
+		if _, locked := actor.Locks[key]; locked {
+			actor.Held[key] = append(actor.Held[key], message)
+			return true
+		}
+	}
+	return false
+}
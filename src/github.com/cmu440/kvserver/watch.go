@@ -0,0 +1,159 @@
+package kvserver
+
+import (
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/cmu440/actor"
+	"github.com/cmu440/kvcommon"
+)
+
+// Watches
+//
+// A query actor records every change it applies to its Store, whether a local write or a synced one, as an Event
+// numbered in order, and keeps the last watchBuffer of them. Clients watch by long polling: an MWatch asks for the
+// changes of keys with a prefix after a cursor, a number on the actor. If there are none yet, the actor holds the
+// request until there are, or watchPoll passed.
+//
+// A watch that is new, moved to another query actor, or fell more than watchBuffer changes behind (because its client
+// did not poll for a while, e.g. as its consumer was slow) catches up from the Store instead: it gets the current value
+// of each key with the prefix whose version is newer than the newest one the watch has seen, oldest first. A watch
+// that has seen nothing catches up from the zero Version, so it gets every present key with the prefix. Several
+// changes of a key in the meantime then come as one event, so a slow watcher costs the actor no more than watchBuffer
+// events. A synced write can be applied later than writes with newer versions, so a catch-up may miss changes that
+// following the cursor would have shown.
+//
+// In partitioned mode, a query actor sees the changes of the keys it owns only.
+
+const (
+	// Number of changes a query actor keeps for watches.
+	watchBuffer = 1000
+	// How long a query actor holds a watch with no changes for it.
+	watchPoll = time.Second
+)
+
+// MWatch is the message type for WATCH requests; see kvcommon.WatchArgs.
+type MWatch struct {
+	Prefix string
+	Actor  string
+	Cursor int
+	After  kvcommon.Version
+	Sender *actor.ActorRef
+}
+
+// WatchResult is the message type for WATCH responses; see kvcommon.WatchReply.
+type WatchResult struct {
+	Events []kvcommon.Event
+	Actor  string
+	Cursor int
+}
+
+// WatchTimeout is the message type for answering held watch ID with no changes.
+type WatchTimeout struct {
+	ID int
+}
+
+// changed
+// Records the change of key to its current value in Store, for watches.
+func (actor *queryActor) changed(key string) {
+	v := actor.Store[key]
+	actor.Events = append(actor.Events, kvcommon.Event{Key: key, Value: v.Value, Deleted: v.Deleted, Version: v.token()})
+	if len(actor.Events) > 2*watchBuffer {
+		drop := len(actor.Events) - watchBuffer
+		actor.Events = append(actor.Events[:0:0], actor.Events[drop:]...)
+		actor.EventsStart += drop
+	}
+}
+
+// eventsEnd
+// Returns the cursor after the last change recorded.
+func (actor *queryActor) eventsEnd() int {
+	return actor.EventsStart + len(actor.Events)
+}
+
+// watch
+// Answers the watch m if there are changes for it, or holds it until there are.
+func (actor *queryActor) watch(m MWatch) {
+	self := actor.Context.Self.Uid()
+	if m.Actor != self || m.Cursor < actor.EventsStart || m.Cursor > actor.eventsEnd() {
+		events := actor.catchUp(m.Prefix, m.After)
+		m.Actor, m.Cursor = self, actor.eventsEnd()
+		if len(events) > 0 {
+			actor.Context.Tell(m.Sender, WatchResult{events, m.Actor, m.Cursor})
+			return
+		}
+	} else if actor.answerWatch(m) {
+		return
+	}
+	id := actor.NextWatch
+	actor.NextWatch++
+	actor.Watches[id] = m
+	actor.Context.TellAfter(actor.Context.Self, WatchTimeout{id}, watchPoll)
+}
+
+// catchUp
+// Returns an event for the current value of each key with prefix whose version is newer than after, oldest first.
+// Tombstones are skipped after the zero Version.
+func (actor *queryActor) catchUp(prefix string, after kvcommon.Version) []kvcommon.Event {
+	events := make([]kvcommon.Event, 0)
+	actor.Index.scan(prefix, prefixEnd(prefix), func(key string) bool {
+		actor.expireIfDue(key)
+		v := actor.Store[key]
+		if v.token().Newer(after) && !(v.Deleted && after == kvcommon.Version{}) {
+			events = append(events, kvcommon.Event{Key: key, Value: v.Value, Deleted: v.Deleted, Version: v.token()})
+		}
+		return true
+	})
+	sort.SliceStable(events, func(i, j int) bool {
+		return events[j].Version.Newer(events[i].Version)
+	})
+	return events
+}
+
+// answerWatch
+// Answers the watch m with the recorded changes for it after its cursor, if there are any. Returns whether it did.
+func (actor *queryActor) answerWatch(m MWatch) bool {
+	events := make([]kvcommon.Event, 0)
+	for _, event := range actor.Events[m.Cursor-actor.EventsStart:] {
+		if strings.HasPrefix(event.Key, m.Prefix) {
+			events = append(events, event)
+		}
+	}
+	if len(events) == 0 {
+		return false
+	}
+	actor.Context.Tell(m.Sender, WatchResult{events, m.Actor, actor.eventsEnd()})
+	return true
+}
+
+// serveWatches
+// Answers the held watches that have changes for them.
+func (actor *queryActor) serveWatches() {
+	for id, m := range actor.Watches {
+		switch {
+		case m.Cursor == actor.eventsEnd():
+		case m.Cursor < actor.EventsStart:
+			// More than watchBuffer changes while held.
+			delete(actor.Watches, id)
+			actor.Context.Tell(m.Sender, WatchResult{actor.catchUp(m.Prefix, m.After), m.Actor, actor.eventsEnd()})
+		case actor.answerWatch(m):
+			delete(actor.Watches, id)
+		default:
+			// None of the changes since the cursor are for the watch.
+			m.Cursor = actor.eventsEnd()
+			actor.Watches[id] = m
+		}
+	}
+}
+
+// onWatchTimeout
+// Answers held watch id with no changes, if it is still held.
+func (actor *queryActor) onWatchTimeout(id int) {
+	m, ok := actor.Watches[id]
+	if !ok {
+		return
+	}
+	delete(actor.Watches, id)
+	actor.Context.Tell(m.Sender, WatchResult{make([]kvcommon.Event, 0), m.Actor, actor.eventsEnd()})
+}
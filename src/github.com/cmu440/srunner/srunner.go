@@ -5,16 +5,27 @@ package main
 import (
 	"flag"
 	"fmt"
+	"net"
+	"net/http"
 	"os"
 
 	"github.com/cmu440/kvserver"
 )
 
 var (
-	port  = flag.Int("port", 6000, "starting port number")
-	count = flag.Int("count", 1, "request actor count")
+	port    = flag.Int("port", 6000, "starting port number")
+	count   = flag.Int("count", 1, "request actor count")
+	metrics = flag.String("metrics", "", "address to serve Prometheus metrics on, e.g. :9090 (disabled if empty)")
+	dataDir = flag.String("datadir", "", "directory to keep data in and recover it from on restart (in memory only if empty)")
+	fsync   = flag.String("fsync", "interval", "when to fsync writes to -datadir: always, interval or never")
 )
 
+var fsyncPolicies = map[string]kvserver.FsyncPolicy{
+	"always":   kvserver.FsyncAlways,
+	"interval": kvserver.FsyncInterval,
+	"never":    kvserver.FsyncNever,
+}
+
 func init() {
 	// Usage string.
 	flag.Usage = func() {
@@ -25,8 +36,17 @@ func init() {
 
 func main() {
 	flag.Parse()
+	config := kvserver.DefaultConfig()
+	config.DataDir = *dataDir
+	policy, ok := fsyncPolicies[*fsync]
+	if !ok {
+		fmt.Printf("Unknown -fsync policy %q\n", *fsync)
+		os.Exit(2)
+	}
+	config.Fsync = policy
+
 	fmt.Println("Starting server...")
-	_, desc, err := kvserver.NewServer(*port, *count, flag.Args())
+	server, desc, err := kvserver.NewServerWithConfig(*port, *count, flag.Args(), config)
 	if err != nil {
 		fmt.Printf("Failed to start Server on ports %d-%d: %s\n", *port, *port+*count, err)
 		os.Exit(3)
@@ -34,6 +54,21 @@ func main() {
 	fmt.Printf("Actor system running on port %d\n", *port)
 	fmt.Printf("Request servers running on ports %d-%d\n", *port+1, *port+*count)
 	fmt.Printf("Description for future servers: %q\n", desc)
+	if *metrics != "" {
+		// Listen before serving, so that a bad address or a taken port fails the start.
+		ln, err := net.Listen("tcp", *metrics)
+		if err != nil {
+			fmt.Printf("Failed to serve metrics on %s: %s\n", *metrics, err)
+			os.Exit(4)
+		}
+		fmt.Printf("Serving metrics on http://%s/metrics\n", *metrics)
+		mux := http.NewServeMux()
+		mux.Handle("/metrics", server.AS.MetricsHandler())
+		go func() {
+			err := http.Serve(ln, mux)
+			fmt.Printf("Metrics server stopped: %s\n", err)
+		}()
+	}
 	// Run forever
 	select {}
 }
@@ -0,0 +1,122 @@
+// Key-value store tests for anti-entropy.
+
+package tests
+
+import (
+	"fmt"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/cmu440/actor"
+	"github.com/cmu440/kvserver"
+)
+
+const antiEntropyInterval = time.Duration(200) * time.Millisecond
+
+// Starts a server with queryActorCount query actors running anti-entropy
+// every interval (never if zero), and returns a client per actor.
+func setupTestAntiEntropy(t *testing.T, queryActorCount int, interval time.Duration) ([]clientWr, serverWr) {
+	config := kvserver.DefaultConfig()
+	config.AntiEntropyInterval = interval
+
+	port := newPort()
+	server, desc, err := kvserver.NewServerWithConfig(port, queryActorCount, []string{}, config)
+	if err != nil {
+		t.Fatalf("Failed to start server on ports %d-%d: %s", port, port+queryActorCount, err)
+	}
+	clients := make([]clientWr, queryActorCount)
+	for i := 0; i < queryActorCount; i++ {
+		clients[i] = newClient(fmt.Sprintf("localhost:%d", port+1+i), fmt.Sprintf("actor %d", i))
+	}
+	return clients, serverWr{server, desc, actor.LastActorSystem()}
+}
+
+// Makes server lose every sync message to its query actor with index.
+func dropSyncTo(server serverWr, index int) {
+	uid := server.s.ActorInfo[index].Uid()
+	server.system.SetDropFilter(func(ref *actor.ActorRef, message any) bool {
+		_, isSync := message.(kvserver.SynMsg)
+		return isSync && ref.Uid() == uid
+	})
+}
+
+func TestAntiEntropyRepairsLostSync(t *testing.T) {
+	fmt.Printf("=== %s: %s\n", t.Name(), "Anti-entropy repairs a replica that lost sync messages")
+
+	clients, server := setupTestAntiEntropy(t, 3, antiEntropyInterval)
+	defer teardownTestLocalSync(clients, server)
+
+	put(t, true, clients[0], "loc/alice", "fence")
+	waitForSync(t, localSyncDeadline)
+
+	dropSyncTo(server, 2)
+	put(t, true, clients[0], "loc/alice", "bridge")
+	put(t, true, clients[1], "loc/bob", "fence")
+	del(t, true, clients[1], "loc/alice", true)
+	clients[0].c.Increment("balance/alice", 5)
+	waitForSync(t, localSyncDeadline)
+	get(t, true, clients[2], "loc/alice", "fence", true)
+	get(t, true, clients[2], "loc/bob", "", false)
+
+	// Each actor runs anti-entropy with actor 2 at least every other interval.
+	server.system.SetDropFilter(nil)
+	waitForSync(t, 3*antiEntropyInterval+localSyncDeadline)
+	for _, client := range clients {
+		get(t, true, client, "loc/alice", "", false)
+		get(t, true, client, "loc/bob", "fence", true)
+		get(t, true, client, "balance/alice", "5", true)
+	}
+}
+
+func TestAntiEntropyDisabled(t *testing.T) {
+	fmt.Printf("=== %s: %s\n", t.Name(), "Without anti-entropy, lost sync messages stay lost")
+
+	clients, server := setupTestAntiEntropy(t, 2, 0)
+	defer teardownTestLocalSync(clients, server)
+
+	dropSyncTo(server, 1)
+	put(t, true, clients[0], "loc/alice", "fence")
+	waitForSync(t, localSyncDeadline)
+	server.system.SetDropFilter(nil)
+	waitForSync(t, 3*antiEntropyInterval+localSyncDeadline)
+	get(t, true, clients[1], "loc/alice", "", false)
+}
+
+func TestAntiEntropyAgreeingReplicas(t *testing.T) {
+	fmt.Printf("=== %s: %s\n", t.Name(), "Replicas that agree exchange only the roots of their Merkle trees")
+
+	clients, server := setupTestAntiEntropy(t, 3, antiEntropyInterval)
+	defer teardownTestLocalSync(clients, server)
+
+	put(t, true, clients[0], "loc/alice", "fence")
+	put(t, true, clients[1], "loc/bob", "bridge")
+	put(t, true, clients[2], "loc/carol", "tower")
+	del(t, true, clients[0], "loc/alice", true)
+	clients[0].c.Increment("balance/bob", 5)
+	clients[1].c.Increment("balance/bob", -2)
+	clients[2].c.SetAdd("items/bob", "sword")
+	clients[0].c.MapSet("stats/bob", "level", "3")
+	waitForSync(t, localSyncDeadline)
+
+	// Counts anti-entropy that went past the roots.
+	var deeper atomic.Int32
+	server.system.SetDropFilter(func(ref *actor.ActorRef, message any) bool {
+		if syn, ok := message.(kvserver.SynMsg); ok && syn.AntiEntropy != nil {
+			if len(syn.AntiEntropy.Pull) > 0 {
+				deeper.Add(1)
+			}
+			for _, exchange := range syn.AntiEntropy.Exchanges {
+				if exchange.Level > 0 {
+					deeper.Add(1)
+				}
+			}
+		}
+		return false
+	})
+	waitForSync(t, 3*antiEntropyInterval)
+	if n := deeper.Load(); n != 0 {
+		t.Fatalf("[ERROR] Agreeing replicas exchanged %d messages below the roots, expected none", n)
+	}
+	get(t, true, clients[2], "balance/bob", "3", true)
+}
@@ -0,0 +1,161 @@
+// Key-value store tests for batches and multi-key operations.
+
+package tests
+
+import (
+	"errors"
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/cmu440/kvclient"
+)
+
+// Checks that MultiGet of keys on client gives exactly expEntries.
+func multiGet(t *testing.T, client clientWr, keys []string, expEntries map[string]string) {
+	entries, err := client.c.MultiGet(keys)
+	if err != nil {
+		t.Fatalf("[ERROR] (%s) MultiGet(%v) returned error: %s", client.name, keys, err)
+	}
+	if len(entries) != len(expEntries) {
+		t.Fatalf("[ERROR] (%s) MultiGet(%v) gave %v, but expected %v", client.name, keys, entries, expEntries)
+	}
+	for key, value := range expEntries {
+		if entries[key] != value {
+			t.Fatalf("[ERROR] (%s) MultiGet(%v) gave %v, but expected %v", client.name, keys, entries, expEntries)
+		}
+	}
+}
+
+// Polls MultiGet of keys on client until it gives all of them with value,
+// checking that it never gives only some of them.
+func waitForBatch(t *testing.T, client clientWr, keys []string, value string, deadline time.Duration) {
+	for start := time.Now(); time.Since(start) < deadline; time.Sleep(20 * time.Millisecond) {
+		entries, err := client.c.MultiGet(keys)
+		if err != nil {
+			t.Fatalf("[ERROR] (%s) MultiGet(%v) returned error: %s", client.name, keys, err)
+		}
+		seen := 0
+		for _, key := range keys {
+			if entries[key] == value {
+				seen++
+			}
+		}
+		if seen == len(keys) {
+			return
+		}
+		if seen > 0 {
+			t.Fatalf("[ERROR] (%s) MultiGet(%v) gave a partial batch: %v", client.name, keys, entries)
+		}
+	}
+	t.Fatalf("[ERROR] (%s) batch of %v never arrived", client.name, keys)
+}
+
+func TestBatchMultiPutAndGet(t *testing.T) {
+	fmt.Printf("=== %s: %s\n", t.Name(), "MultiPut, ApplyBatch and MultiGet write and read several keys at once")
+
+	clients, server := setupTestLocalSync(t, 2)
+	defer teardownTestLocalSync(clients, server)
+
+	entries := map[string]string{"alice": "Undergraduate", "loc/alice": "5", "loc/bob": "3"}
+	if err := clients[0].c.MultiPut(entries); err != nil {
+		t.Fatalf("[ERROR] MultiPut failed: %s", err)
+	}
+	keys := []string{"alice", "loc/alice", "loc/bob", "loc/carol"}
+	multiGet(t, clients[0], keys, entries)
+
+	version, err := clients[0].c.ApplyBatch([]kvclient.BatchOp{
+		{Key: "loc/alice", Value: "fence"},
+		{Key: "loc/bob", Delete: true},
+		{Key: "loc/carol", Value: "bridge"},
+		{Key: "loc/carol", Value: "river"},
+	})
+	if err != nil {
+		t.Fatalf("[ERROR] ApplyBatch failed: %s", err)
+	}
+	entries = map[string]string{"alice": "Undergraduate", "loc/alice": "fence", "loc/carol": "river"}
+	multiGet(t, clients[0], keys, entries)
+
+	// The batch's Version is a write token for each of its keys.
+	value, ok, err := clients[1].c.GetAfter("loc/carol", version)
+	if err != nil || !ok || value != "river" {
+		t.Fatalf("[ERROR] GetAfter gave (%q, %t, %v), expected (\"river\", true, nil)", value, ok, err)
+	}
+	waitForSync(t, localSyncDeadline)
+	multiGet(t, clients[1], keys, entries)
+}
+
+func TestBatchSyncedWhole(t *testing.T) {
+	fmt.Printf("=== %s: %s\n", t.Name(), "2 servers: the other server never sees part of a batch")
+
+	clients, servers := setupTestRemoteSync(t, 2, 1)
+	defer teardownTestRemoteSync(clients, servers)
+
+	keys := []string{"alice", "loc/alice", "balance/alice", "bag/alice"}
+	for i := 0; i < 5; i++ {
+		value := fmt.Sprintf("v%d", i)
+		entries := make(map[string]string)
+		for _, key := range keys {
+			entries[key] = value
+		}
+		if err := clients[0].c.MultiPut(entries); err != nil {
+			t.Fatalf("[ERROR] MultiPut failed: %s", err)
+		}
+		waitForBatch(t, clients[1], keys, value, remoteSyncDeadline)
+	}
+}
+
+func TestBatchAntiEntropy(t *testing.T) {
+	fmt.Printf("=== %s: %s\n", t.Name(), "Anti-entropy repairs a lost batch whole")
+
+	clients, server := setupTestAntiEntropy(t, 2, antiEntropyInterval)
+	defer teardownTestLocalSync(clients, server)
+
+	dropSyncTo(server, 1)
+	keys := []string{}
+	entries := make(map[string]string)
+	for i := 0; i < 16; i++ {
+		key := fmt.Sprintf("key%d", i)
+		keys = append(keys, key)
+		entries[key] = "batch"
+	}
+	if err := clients[0].c.MultiPut(entries); err != nil {
+		t.Fatalf("[ERROR] MultiPut failed: %s", err)
+	}
+	waitForSync(t, localSyncDeadline)
+	multiGet(t, clients[1], keys, map[string]string{})
+
+	server.system.SetDropFilter(nil)
+	waitForBatch(t, clients[1], keys, "batch", 3*antiEntropyInterval+localSyncDeadline)
+}
+
+func TestBatchPartitioned(t *testing.T) {
+	fmt.Printf("=== %s: %s\n", t.Name(), "3 servers, 1 replica: batches across owners are refused, MultiGet gathers keys")
+
+	clients, servers := setupTestPartitioned(t, 3, 1, 1)
+	defer teardownTestRemoteSync(clients, servers)
+
+	keys := []string{}
+	entries := make(map[string]string)
+	for i := 0; i < 12; i++ {
+		key := fmt.Sprintf("key%d", i)
+		keys = append(keys, key)
+		entries[key] = "value-" + key
+	}
+	if err := clients[0].c.MultiPut(entries); !errors.Is(err, kvclient.ErrBatchSpansOwners) {
+		t.Fatalf("[ERROR] MultiPut across owners returned error %v, expected %v", err, kvclient.ErrBatchSpansOwners)
+	}
+	waitForSync(t, remoteSyncDeadline)
+	multiGet(t, clients[1], keys, map[string]string{})
+
+	// A batch of one key has one set of owners.
+	for key, value := range entries {
+		if err := clients[0].c.MultiPut(map[string]string{key: value}); err != nil {
+			t.Fatalf("[ERROR] MultiPut failed: %s", err)
+		}
+	}
+	waitForSync(t, remoteSyncDeadline)
+	for _, client := range clients {
+		multiGet(t, client, append(keys, "missing"), entries)
+	}
+}
@@ -0,0 +1,175 @@
+// Key-value store tests for conditional writes.
+
+package tests
+
+import (
+	"fmt"
+	"strconv"
+	"sync"
+	"testing"
+
+	"github.com/cmu440/kvclient"
+)
+
+func getVersion(t *testing.T, client clientWr, key string, expValue string, expOk bool) kvclient.Version {
+	value, version, ok, err := client.c.GetWithVersion(key)
+	if err != nil {
+		t.Fatalf("[ERROR] (%s) GetWithVersion(%q) returned error: %s", client.name, key, err)
+	}
+	if ok != expOk || value != expValue {
+		t.Errorf("[ERROR] (%s) GetWithVersion(%q) gave (%q, %t), but expected (%q, %t)",
+			client.name, key, value, ok, expValue, expOk)
+	}
+	if ok == (version == kvclient.Version{}) {
+		t.Errorf("[ERROR] (%s) GetWithVersion(%q) gave version %+v with ok=%t", client.name, key, version, ok)
+	}
+	return version
+}
+
+func expectCond(t *testing.T, client clientWr, desc string, ok bool, err error, expOk bool) {
+	if err != nil {
+		t.Fatalf("[ERROR] (%s) %s returned error: %s", client.name, desc, err)
+	}
+	if ok != expOk {
+		t.Errorf("[ERROR] (%s) %s gave ok=%t, but expected %t", client.name, desc, ok, expOk)
+	}
+}
+
+func TestConditionalOneActor(t *testing.T) {
+	fmt.Printf("=== %s: %s\n", t.Name(), "PutIfAbsent, CompareAndSet and PutIfVersion check their conditions")
+
+	clients, server := setupTestLocalSync(t, 1)
+	defer teardownTestLocalSync(clients, server)
+	c := clients[0]
+
+	ok, err := c.c.PutIfAbsent("balance/alice", "0")
+	expectCond(t, c, "PutIfAbsent on absent key", ok, err, true)
+	ok, err = c.c.PutIfAbsent("balance/alice", "10")
+	expectCond(t, c, "PutIfAbsent on present key", ok, err, false)
+	get(t, true, c, "balance/alice", "0", true)
+
+	ok, err = c.c.CompareAndSet("balance/alice", "5", "10")
+	expectCond(t, c, "CompareAndSet with wrong expected value", ok, err, false)
+	ok, err = c.c.CompareAndSet("balance/alice", "0", "10")
+	expectCond(t, c, "CompareAndSet with right expected value", ok, err, true)
+	ok, err = c.c.CompareAndSet("balance/bob", "", "10")
+	expectCond(t, c, "CompareAndSet on absent key", ok, err, false)
+
+	version := getVersion(t, c, "balance/alice", "10", true)
+	// Same value, but a new version.
+	put(t, true, c, "balance/alice", "10")
+	_, ok, err = c.c.PutIfVersion("balance/alice", version, "20")
+	expectCond(t, c, "PutIfVersion with stale version", ok, err, false)
+	version = getVersion(t, c, "balance/alice", "10", true)
+	newVersion, ok, err := c.c.PutIfVersion("balance/alice", version, "20")
+	expectCond(t, c, "PutIfVersion with current version", ok, err, true)
+	if got := getVersion(t, c, "balance/alice", "20", true); got != newVersion {
+		t.Errorf("[ERROR] PutIfVersion returned version %+v, but Get returned %+v", newVersion, got)
+	}
+
+	del(t, true, c, "balance/alice", true)
+	getVersion(t, c, "balance/alice", "", false)
+	_, ok, err = c.c.PutIfVersion("balance/alice", kvclient.Version{}, "1")
+	expectCond(t, c, "PutIfVersion with zero version on deleted key", ok, err, true)
+	ok, err = c.c.PutIfAbsent("balance/alice", "2")
+	expectCond(t, c, "PutIfAbsent after PutIfVersion", ok, err, false)
+	get(t, true, c, "balance/alice", "1", true)
+}
+
+func TestConditionalConcurrentIncrements(t *testing.T) {
+	fmt.Printf("=== %s: %s\n", t.Name(), "Concurrent CompareAndSet loops on one actor lose no updates")
+
+	const sessions = 8
+	const increments = 20
+
+	port := newPort()
+	server := newServer(t, port, 1, []string{})
+	defer server.s.Close()
+	address := fmt.Sprintf("localhost:%d", port+1)
+
+	var wg sync.WaitGroup
+	for i := 0; i < sessions; i++ {
+		wg.Add(1)
+		go func(client clientWr) {
+			defer wg.Done()
+			defer client.c.Close()
+			for j := 0; j < increments; j++ {
+				for {
+					value, ok, err := client.c.Get("count")
+					if err != nil {
+						t.Errorf("[ERROR] (%s) Get returned error: %s", client.name, err)
+						return
+					}
+					current := 0
+					if ok {
+						current, _ = strconv.Atoi(value)
+						ok, err = client.c.CompareAndSet("count", value, strconv.Itoa(current+1))
+					} else {
+						ok, err = client.c.PutIfAbsent("count", "1")
+					}
+					if err != nil {
+						t.Errorf("[ERROR] (%s) conditional write returned error: %s", client.name, err)
+						return
+					}
+					if ok {
+						break
+					}
+				}
+			}
+		}(newClient(address, fmt.Sprintf("session %d", i)))
+	}
+	wg.Wait()
+
+	client := newClient(address, "checker")
+	defer client.c.Close()
+	get(t, true, client, "count", strconv.Itoa(sessions*increments), true)
+}
+
+func TestConditionalAcrossReplicas(t *testing.T) {
+	fmt.Printf("=== %s: %s\n", t.Name(), "Conflicting conditional writes on two replicas both succeed; the newer one wins")
+
+	clients, server := setupTestLocalSync(t, 2)
+	defer teardownTestLocalSync(clients, server)
+
+	// Both replicas must accept their write before either syncs, which a
+	// slow run may miss; retry on a fresh key a few times if so.
+	for attempt := 0; attempt < 5; attempt++ {
+		key := fmt.Sprintf("loc/alice%d", attempt)
+		clients[0].c.PutIfAbsent(key, "5")
+		waitForSync(t, localSyncDeadline)
+		version := getVersion(t, clients[1], key, "5", true)
+
+		var versionA, versionB kvclient.Version
+		var okA, okB bool
+		var errA, errB error
+		var wg sync.WaitGroup
+		wg.Add(2)
+		go func() {
+			defer wg.Done()
+			versionA, okA, errA = clients[0].c.PutIfVersion(key, version, "fence")
+		}()
+		go func() {
+			defer wg.Done()
+			versionB, okB, errB = clients[1].c.PutIfVersion(key, version, "bridge")
+		}()
+		wg.Wait()
+		if errA != nil || errB != nil {
+			t.Fatalf("[ERROR] PutIfVersion returned errors %v, %v", errA, errB)
+		}
+		if !okA || !okB {
+			t.Logf("Attempt %d: a write synced before the other replica's PutIfVersion", attempt)
+			continue
+		}
+
+		winner := "fence"
+		if versionB.Newer(versionA) {
+			winner = "bridge"
+		}
+		waitForSync(t, localSyncDeadline)
+		for _, client := range clients {
+			get(t, true, client, key, winner, true)
+		}
+		return
+	}
+	t.Errorf("[ERROR] Conflicting PutIfVersions never both succeeded")
+}
@@ -0,0 +1,121 @@
+// Key-value store tests for consistency levels.
+
+package tests
+
+import (
+	"fmt"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/cmu440/actor"
+	"github.com/cmu440/kvclient"
+	"github.com/cmu440/kvserver"
+)
+
+// Starts a server with queryActorCount query actors that lose all sync
+// messages while the returned flag is set, and returns a client per actor.
+// Anti-entropy is off, so only consistency levels spread writes then.
+func setupTestConsistency(t *testing.T, queryActorCount int, timeout time.Duration) ([]clientWr, serverWr, *atomic.Bool) {
+	config := kvserver.DefaultConfig()
+	config.AntiEntropyInterval = 0
+	config.ConsistencyTimeout = timeout
+
+	port := newPort()
+	server, desc, err := kvserver.NewServerWithConfig(port, queryActorCount, []string{}, config)
+	if err != nil {
+		t.Fatalf("Failed to start server on ports %d-%d: %s", port, port+queryActorCount, err)
+	}
+	clients := make([]clientWr, queryActorCount)
+	for i := 0; i < queryActorCount; i++ {
+		clients[i] = newClient(fmt.Sprintf("localhost:%d", port+1+i), fmt.Sprintf("actor %d", i))
+	}
+	system := actor.LastActorSystem()
+	dropping := &atomic.Bool{}
+	dropping.Store(true)
+	system.SetDropFilter(func(ref *actor.ActorRef, message any) bool {
+		_, isSync := message.(kvserver.SynMsg)
+		return isSync && dropping.Load()
+	})
+	return clients, serverWr{server, desc, system}, dropping
+}
+
+func getAt(t *testing.T, client clientWr, level kvclient.Consistency, key string, expValue string, expOk bool) {
+	queryLogf(t, true, "(%s) Calling client.GetWithConsistency(%q, %s)", client.name, key, level)
+	value, ok, err := client.c.GetWithConsistency(key, level)
+	if err != nil {
+		t.Fatalf("[ERROR] (%s) GetWithConsistency(%q, %s) returned error: %s", client.name, key, level, err)
+	}
+	if value != expValue || ok != expOk {
+		t.Fatalf("[ERROR] (%s) GetWithConsistency(%q, %s) gave (%q, %t), but expected (%q, %t)",
+			client.name, key, level, value, ok, expValue, expOk)
+	}
+}
+
+func TestConsistencyQuorumAndAll(t *testing.T) {
+	fmt.Printf("=== %s: %s\n", t.Name(), "Without syncs, QUORUM reads see QUORUM writes and ALL writes reach all replicas")
+
+	clients, server, _ := setupTestConsistency(t, 3, time.Second)
+	defer teardownTestLocalSync(clients, server)
+
+	if err := clients[0].c.PutWithConsistency("loc/alice", "fence", kvclient.Quorum); err != nil {
+		t.Fatalf("[ERROR] PutWithConsistency failed: %s", err)
+	}
+	for _, client := range clients {
+		getAt(t, client, kvclient.Quorum, "loc/alice", "fence", true)
+	}
+
+	if err := clients[1].c.PutWithConsistency("loc/bob", "bridge", kvclient.All); err != nil {
+		t.Fatalf("[ERROR] PutWithConsistency failed: %s", err)
+	}
+	for _, client := range clients {
+		get(t, true, client, "loc/bob", "bridge", true)
+	}
+}
+
+func TestConsistencyReadRepair(t *testing.T) {
+	fmt.Printf("=== %s: %s\n", t.Name(), "An ALL read repairs replicas that missed a write")
+
+	clients, server, dropping := setupTestConsistency(t, 3, time.Second)
+	defer teardownTestLocalSync(clients, server)
+
+	put(t, true, clients[0], "loc/alice", "fence")
+	get(t, true, clients[0], "loc/alice", "fence", true)
+	// The sync with the write was lost, and is not sent again.
+	waitForSync(t, localSyncDeadline)
+	dropping.Store(false)
+	waitForSync(t, localSyncDeadline)
+	get(t, true, clients[2], "loc/alice", "", false)
+
+	getAt(t, clients[1], kvclient.All, "loc/alice", "fence", true)
+	waitForSync(t, localSyncDeadline)
+	get(t, true, clients[1], "loc/alice", "fence", true)
+	get(t, true, clients[2], "loc/alice", "fence", true)
+}
+
+func TestConsistencyTimeout(t *testing.T) {
+	fmt.Printf("=== %s: %s\n", t.Name(), "Requests fail if too few replicas answer in time")
+
+	clients, server, _ := setupTestConsistency(t, 3, time.Duration(200)*time.Millisecond)
+	defer teardownTestLocalSync(clients, server)
+	lost := server.s.ActorInfo[2].Uid()
+	server.system.SetDropFilter(func(ref *actor.ActorRef, message any) bool {
+		_, isReplica := message.(kvserver.MReplica)
+		return isReplica && ref.Uid() == lost
+	})
+
+	// Two of three replicas still make a quorum.
+	if err := clients[0].c.PutWithConsistency("loc/alice", "fence", kvclient.Quorum); err != nil {
+		t.Fatalf("[ERROR] PutWithConsistency failed: %s", err)
+	}
+	getAt(t, clients[1], kvclient.Quorum, "loc/alice", "fence", true)
+
+	if err := clients[0].c.PutWithConsistency("loc/alice", "bridge", kvclient.All); err == nil {
+		t.Fatalf("[ERROR] PutWithConsistency at ALL succeeded with a replica unreachable")
+	}
+	if _, _, err := clients[1].c.GetWithConsistency("loc/alice", kvclient.All); err == nil {
+		t.Fatalf("[ERROR] GetWithConsistency at ALL succeeded with a replica unreachable")
+	}
+	// The failed write stays on the replicas it reached.
+	getAt(t, clients[1], kvclient.Quorum, "loc/alice", "bridge", true)
+}
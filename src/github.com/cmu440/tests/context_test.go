@@ -0,0 +1,165 @@
+// Key-value store tests for context deadlines and cancellation.
+
+package tests
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/rpc"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/cmu440/actor"
+	"github.com/cmu440/kvclient"
+	"github.com/cmu440/kvcommon"
+	"github.com/cmu440/kvserver"
+)
+
+// How long the tests' contexts last.
+const contextTimeout = time.Duration(200) * time.Millisecond
+
+// Checks that a call made at start returned about expected later.
+func expectElapsed(t *testing.T, desc string, start time.Time, expected time.Duration) {
+	if elapsed := time.Since(start); elapsed < expected || elapsed > expected+localSyncDeadline {
+		t.Fatalf("[ERROR] %s returned after %s, expected about %s", desc, elapsed, expected)
+	}
+}
+
+// Checks that a call made at start returned err want about expected later.
+func expectCtxErr(t *testing.T, desc string, err error, want error, start time.Time, expected time.Duration) {
+	if !errors.Is(err, want) {
+		t.Fatalf("[ERROR] %s returned error %v, expected %v", desc, err, want)
+	}
+	expectElapsed(t, desc, start, expected)
+}
+
+func TestContextStalledServer(t *testing.T) {
+	fmt.Printf("=== %s: %s\n", t.Name(), "Calls to a stalled server return at their deadline or on cancellation")
+
+	client, server := setupTestClient(t)
+	defer teardownTestClient(client, server)
+	calls := make(chan rpcCall, 3)
+	go func() {
+		for call := range server.callCh {
+			calls <- call
+		}
+	}()
+
+	ctx, cancel := context.WithTimeout(context.Background(), contextTimeout)
+	defer cancel()
+	want, _ := ctx.Deadline()
+	start := time.Now()
+	_, _, err := client.GetCtx(ctx, "foo")
+	expectCtxErr(t, "GetCtx", err, context.DeadlineExceeded, start, contextTimeout)
+	call := <-calls
+	if args := call.args.(kvcommon.GetArgs); !args.Deadline.Equal(want) {
+		t.Fatalf("[ERROR] Server got deadline %s, expected %s", args.Deadline, want)
+	}
+
+	ctx, cancel = context.WithCancel(context.Background())
+	time.AfterFunc(contextTimeout, cancel)
+	start = time.Now()
+	err = client.PutCtx(ctx, "foo", "bar")
+	expectCtxErr(t, "PutCtx", err, context.Canceled, start, contextTimeout)
+	if args := (<-calls).args.(kvcommon.PutArgs); !args.Deadline.IsZero() {
+		t.Fatalf("[ERROR] Server got deadline %s for a context without one", args.Deadline)
+	}
+
+	// A context done already fails the call at once.
+	_, err = client.ListCtx(ctx, "foo")
+	expectCtxErr(t, "ListCtx", err, context.Canceled, time.Now(), 0)
+
+	// The stalled calls end late and their replies are dropped; later calls dial a new connection.
+	call.replyCh <- kvcommon.GetReply{Value: "late", Ok: true}
+	go func() {
+		call := <-calls
+		call.replyCh <- kvcommon.GetReply{Value: "bar", Ok: true}
+	}()
+	expectGet(t, client, "foo", "bar")
+}
+
+func TestContextServerDeadline(t *testing.T) {
+	fmt.Printf("=== %s: %s\n", t.Name(), "The server stops waiting for its query actor at the request's deadline")
+
+	config := kvserver.DefaultConfig()
+	config.ConsistencyTimeout = 10 * time.Second
+	config.RequestTimeout = 20 * time.Second
+	port := newPort()
+	server, desc, err := kvserver.NewServerWithConfig(port, 2, []string{}, config)
+	if err != nil {
+		t.Fatalf("Failed to start server on ports %d-%d: %s", port, port+2, err)
+	}
+	system := actor.LastActorSystem()
+	wr := serverWr{server, desc, system}
+	clients := []clientWr{newClient(fmt.Sprintf("localhost:%d", port+1), "actor 0")}
+	defer teardownTestLocalSync(clients, wr)
+	// Reads at ALL wait for a replica that never answers.
+	system.SetDropFilter(func(ref *actor.ActorRef, message any) bool {
+		_, isReplica := message.(kvserver.MReplica)
+		return isReplica
+	})
+
+	conn, err := rpc.Dial("tcp", fmt.Sprintf("localhost:%d", port+1))
+	if err != nil {
+		t.Fatalf("[ERROR] Dial failed: %s", err)
+	}
+	defer conn.Close()
+	start := time.Now()
+	args := kvcommon.GetArgs{Key: "foo", Consistency: kvclient.All, Deadline: start.Add(contextTimeout)}
+	err = conn.Call("QueryReceiver.Get", args, &kvcommon.GetReply{})
+	if err == nil || !strings.Contains(err.Error(), "deadline") {
+		t.Fatalf("[ERROR] Get returned error %v, expected a deadline error", err)
+	}
+	expectElapsed(t, "Get", start, contextTimeout)
+}
+
+func TestContextServerDeadlineAll(t *testing.T) {
+	fmt.Printf("=== %s: %s\n", t.Name(), "Every RPC stops waiting for its query actor at the request's deadline")
+
+	config := kvserver.DefaultConfig()
+	config.RequestTimeout = 20 * time.Second
+	port := newPort()
+	server, desc, err := kvserver.NewServerWithConfig(port, 1, []string{}, config)
+	if err != nil {
+		t.Fatalf("Failed to start server on ports %d-%d: %s", port, port+1, err)
+	}
+	system := actor.LastActorSystem()
+	wr := serverWr{server, desc, system}
+	clients := []clientWr{newClient(fmt.Sprintf("localhost:%d", port+1), "actor 0")}
+	defer teardownTestLocalSync(clients, wr)
+	// The query actor never gets the requests.
+	system.SetDropFilter(func(ref *actor.ActorRef, message any) bool {
+		switch message.(type) {
+		case kvserver.MGet, kvserver.MCRDT, kvserver.MScan:
+			return true
+		}
+		return false
+	})
+
+	conn, err := rpc.Dial("tcp", fmt.Sprintf("localhost:%d", port+1))
+	if err != nil {
+		t.Fatalf("[ERROR] Dial failed: %s", err)
+	}
+	defer conn.Close()
+	calls := []struct {
+		method string
+		args   func(deadline time.Time) any
+		reply  any
+	}{
+		{"GetVersion", func(d time.Time) any { return kvcommon.GetVersionArgs{Key: "foo", Deadline: d} },
+			&kvcommon.GetVersionReply{}},
+		{"Increment", func(d time.Time) any { return kvcommon.IncrementArgs{Key: "foo", Delta: 1, Deadline: d} },
+			&kvcommon.CRDTReply{}},
+		{"Scan", func(d time.Time) any { return kvcommon.ScanArgs{Start: "a", Deadline: d} }, &kvcommon.ScanReply{}},
+	}
+	for _, call := range calls {
+		start := time.Now()
+		err = conn.Call("QueryReceiver."+call.method, call.args(start.Add(contextTimeout)), call.reply)
+		if err == nil || !strings.Contains(err.Error(), "deadline") {
+			t.Fatalf("[ERROR] %s returned error %v, expected a deadline error", call.method, err)
+		}
+		expectElapsed(t, call.method, start, contextTimeout)
+	}
+}
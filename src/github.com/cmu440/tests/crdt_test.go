@@ -0,0 +1,167 @@
+// Key-value store tests for CRDT values.
+
+package tests
+
+import (
+	"errors"
+	"fmt"
+	"reflect"
+	"strconv"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/cmu440/kvclient"
+)
+
+func TestCRDTCounter(t *testing.T) {
+	fmt.Printf("=== %s: %s\n", t.Name(), "Concurrent increments on all actors converge to their sum")
+
+	const increments = 25
+
+	clients, server := setupTestLocalSync(t, 3)
+	defer teardownTestLocalSync(clients, server)
+
+	var wg sync.WaitGroup
+	for _, client := range clients {
+		for _, delta := range []int64{3, -1} {
+			wg.Add(1)
+			go func(client clientWr, delta int64) {
+				defer wg.Done()
+				for i := 0; i < increments; i++ {
+					if _, ok, err := client.c.Increment("balance/alice", delta); err != nil || !ok {
+						t.Errorf("[ERROR] (%s) Increment returned (%t, %v)", client.name, ok, err)
+						return
+					}
+				}
+			}(client, delta)
+		}
+	}
+	wg.Wait()
+
+	expected := int64(len(clients) * increments * (3 - 1))
+	waitForSync(t, localSyncDeadline)
+	for _, client := range clients {
+		value, ok, err := client.c.Counter("balance/alice")
+		if err != nil || !ok || value != expected {
+			t.Errorf("[ERROR] (%s) Counter gave (%d, %t, %v), but expected %d", client.name, value, ok, err, expected)
+		}
+		get(t, true, client, "balance/alice", strconv.FormatInt(expected, 10), true)
+	}
+}
+
+func TestCRDTCounterFromPlain(t *testing.T) {
+	fmt.Printf("=== %s: %s\n", t.Name(), "Increments of a plain integer on all actors start a counter from it once")
+
+	clients, server := setupTestLocalSync(t, 3)
+	defer teardownTestLocalSync(clients, server)
+
+	// A balance from before balances were counters.
+	put(t, true, clients[0], "balance/alice", "1000")
+	waitForSync(t, localSyncDeadline)
+	// Actors that got another's counter by sync before incrementing see its increments too.
+	for _, client := range clients {
+		value, ok, err := client.c.Increment("balance/alice", 5)
+		if err != nil || !ok || value < 1005 || value > 1015 {
+			t.Errorf("[ERROR] (%s) Increment gave (%d, %t, %v), but expected 1005 to 1015", client.name, value, ok, err)
+		}
+	}
+	waitForSync(t, localSyncDeadline)
+	for _, client := range clients {
+		get(t, true, client, "balance/alice", "1015", true)
+	}
+}
+
+func TestCRDTSet(t *testing.T) {
+	fmt.Printf("=== %s: %s\n", t.Name(), "Set adds win over concurrent removes")
+
+	clients, server := setupTestLocalSync(t, 2)
+	defer teardownTestLocalSync(clients, server)
+
+	clients[0].c.SetAdd("party/alice", "bob")
+	clients[0].c.SetAdd("party/alice", "carol")
+	waitForSync(t, localSyncDeadline)
+
+	// Actor 1 removes bob and carol; meanwhile actor 0 adds bob again.
+	clients[1].c.SetRemove("party/alice", "bob")
+	clients[1].c.SetRemove("party/alice", "carol")
+	clients[0].c.SetAdd("party/alice", "bob")
+	clients[0].c.SetAdd("party/alice", "dave")
+	waitForSync(t, localSyncDeadline)
+
+	for _, client := range clients {
+		members, ok, err := client.c.SetMembers("party/alice")
+		if err != nil || !ok || !reflect.DeepEqual(members, []string{"bob", "dave"}) {
+			t.Errorf("[ERROR] (%s) SetMembers gave (%q, %t, %v), but expected [bob dave]", client.name, members, ok, err)
+		}
+		get(t, true, client, "party/alice", `["bob","dave"]`, true)
+	}
+
+	clients[1].c.SetRemove("party/alice", "bob")
+	clients[1].c.SetRemove("party/alice", "dave")
+	members, ok, _ := clients[1].c.SetMembers("party/alice")
+	if !ok || len(members) != 0 {
+		t.Errorf("[ERROR] SetMembers of emptied set gave (%q, %t)", members, ok)
+	}
+}
+
+func TestCRDTMapAndTypes(t *testing.T) {
+	fmt.Printf("=== %s: %s\n", t.Name(), "Map fields merge independently, and keys keep their type")
+
+	clients, server := setupTestLocalSync(t, 2)
+	defer teardownTestLocalSync(clients, server)
+
+	clients[0].c.MapSet("profile/alice", "edu", "PhD")
+	clients[0].c.MapSet("profile/alice", "loc", "5")
+	clients[1].c.MapSet("profile/alice", "title", "Dr.")
+	waitForSync(t, localSyncDeadline)
+	clients[1].c.MapDelete("profile/alice", "loc")
+	waitForSync(t, localSyncDeadline)
+	for _, client := range clients {
+		entries, ok, err := client.c.MapEntries("profile/alice")
+		expected := map[string]string{"edu": "PhD", "title": "Dr."}
+		if err != nil || !ok || !reflect.DeepEqual(entries, expected) {
+			t.Errorf("[ERROR] (%s) MapEntries gave (%q, %t, %v), but expected %q", client.name, entries, ok, err, expected)
+		}
+	}
+
+	c := clients[0]
+	if ok, _ := c.c.SetAdd("profile/alice", "x"); ok {
+		t.Errorf("[ERROR] SetAdd on a map succeeded")
+	}
+	if _, ok, _ := c.c.Counter("profile/alice"); ok {
+		t.Errorf("[ERROR] Counter of a map succeeded")
+	}
+	put(t, true, c, "loc/alice", "fence")
+	if _, ok, _ := c.c.Increment("loc/alice", 1); ok {
+		t.Errorf("[ERROR] Increment of a plain value succeeded")
+	}
+
+	c.c.Increment("balance/alice", 7)
+	if err := c.c.Put("balance/alice", "1000"); !errors.Is(err, kvclient.ErrWrongType) {
+		t.Errorf("[ERROR] Put of a counter returned error %v, expected %v", err, kvclient.ErrWrongType)
+	}
+	del(t, true, c, "balance/alice", false)
+	if ok, _ := c.c.CompareAndSet("balance/alice", "7", "1000"); ok {
+		t.Errorf("[ERROR] CompareAndSet of a counter succeeded")
+	}
+	get(t, true, c, "balance/alice", "7", true)
+}
+
+func TestCRDTCounterRemote(t *testing.T) {
+	fmt.Printf("=== %s: %s\n", t.Name(), "Increments on different servers converge")
+
+	clients, servers := setupTestRemoteSync(t, 3, 1)
+	defer teardownTestRemoteSync(clients, servers)
+	time.Sleep(time.Duration(4*remoteServerLatencyMs) * time.Millisecond)
+
+	for i, client := range clients {
+		for j := 0; j <= i; j++ {
+			client.c.Increment("comp/alice/gpu", 1)
+		}
+	}
+	waitForSync(t, remoteSyncDeadline)
+	for _, client := range clients {
+		get(t, true, client, "comp/alice/gpu", "6", true)
+	}
+}
@@ -0,0 +1,89 @@
+// Key-value store tests for Delete and tombstone syncing.
+
+package tests
+
+import (
+	"fmt"
+	"testing"
+	"time"
+)
+
+func del(t *testing.T, verbose bool, client clientWr, key string, expOk bool) bool {
+	queryLogf(t, verbose, "(%s) Calling client.Delete(%q)", client.name, key)
+	ok, err := client.c.Delete(key)
+	if err != nil {
+		t.Errorf("[ERROR] (%s) Delete(%q) returned error: %s", client.name, key, err)
+		return false
+	}
+	queryLogf(t, verbose, "(%s) Delete(%q) returned %t", client.name, key, ok)
+	if ok != expOk {
+		t.Errorf("[ERROR] (%s) Delete(%q) gave ok=%t, but expected %t", client.name, key, ok, expOk)
+		return false
+	}
+	return true
+}
+
+func TestDeleteOneActor(t *testing.T) {
+	fmt.Printf("=== %s: %s\n", t.Name(), "Delete hides a key from Get and List on one actor")
+
+	clients, server := setupTestLocalSync(t, 1)
+	defer teardownTestLocalSync(clients, server)
+
+	put(t, true, clients[0], "loc/alice", "5")
+	put(t, true, clients[0], "loc/bob", "3")
+	get(t, true, clients[0], "loc/alice", "5", true)
+
+	del(t, true, clients[0], "loc/alice", true)
+	get(t, true, clients[0], "loc/alice", "", false)
+	list(t, true, clients[0], "loc/", map[string]string{"loc/bob": "3"})
+	del(t, true, clients[0], "loc/alice", false)
+	del(t, true, clients[0], "loc/nobody", false)
+
+	put(t, true, clients[0], "loc/alice", "7")
+	get(t, true, clients[0], "loc/alice", "7", true)
+}
+
+func TestDeleteLocalSync(t *testing.T) {
+	fmt.Printf("=== %s: %s\n", t.Name(), "Deletes sync to all actors and lose to newer Puts")
+
+	clients, server := setupTestLocalSync(t, 4)
+	defer teardownTestLocalSync(clients, server)
+
+	put(t, true, clients[0], "comp/alice/gpu", "2")
+	waitForSync(t, localSyncDeadline)
+	for _, client := range clients {
+		get(t, true, client, "comp/alice/gpu", "2", true)
+	}
+
+	del(t, true, clients[2], "comp/alice/gpu", true)
+	waitForSync(t, localSyncDeadline)
+	for _, client := range clients {
+		get(t, true, client, "comp/alice/gpu", "", false)
+		list(t, true, client, "comp/", map[string]string{})
+	}
+
+	put(t, true, clients[3], "comp/alice/gpu", "1")
+	waitForSync(t, localSyncDeadline)
+	for _, client := range clients {
+		get(t, true, client, "comp/alice/gpu", "1", true)
+	}
+}
+
+func TestDeleteRemoteBeatsOlderPut(t *testing.T) {
+	fmt.Printf("=== %s: %s\n", t.Name(), "A delete on a replica that hasn't seen the Put yet still wins")
+
+	clients, servers := setupTestRemoteSync(t, 2, 1)
+	defer teardownTestRemoteSync(clients, servers)
+	time.Sleep(time.Duration(4*remoteServerLatencyMs) * time.Millisecond)
+
+	put(t, true, clients[0], "loc/carol", "9")
+	// Ensure a later timestamp, but delete long before the Put can arrive.
+	time.Sleep(5 * time.Millisecond)
+	del(t, true, clients[1], "loc/carol", false)
+
+	waitForSync(t, remoteSyncDeadline)
+	for _, client := range clients {
+		get(t, true, client, "loc/carol", "", false)
+		list(t, true, client, "loc/", map[string]string{})
+	}
+}
@@ -0,0 +1,159 @@
+// Tests for running actors with the different Dispatchers.
+
+package tests
+
+import (
+	"encoding/gob"
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/cmu440/actor"
+)
+
+const dispatcherDeadline = time.Duration(5) * time.Second
+
+// Actor that records the Names it receives, in order, and sends the
+// record to a ReportRef on request.
+type recordActor struct {
+	context *actor.ActorContext
+	names   []string
+}
+
+func newRecordActor(context *actor.ActorContext) actor.Actor {
+	return &recordActor{context: context}
+}
+
+type RecordName struct {
+	Name string
+}
+
+type RecordDump struct {
+	ReportRef *actor.ActorRef
+}
+
+func (actor *recordActor) OnMessage(message any) error {
+	switch m := message.(type) {
+	case RecordName:
+		actor.names = append(actor.names, m.Name)
+	case RecordDump:
+		actor.context.Tell(m.ReportRef, actor.names)
+	}
+	return nil
+}
+
+// Actor that takes a while to handle each message, then forwards it to
+// a recordActor.
+type slowActor struct {
+	context *actor.ActorContext
+}
+
+func newSlowActor(context *actor.ActorContext) actor.Actor {
+	return &slowActor{context}
+}
+
+type SlowWork struct {
+	Name     string
+	Recorder *actor.ActorRef
+}
+
+func (actor *slowActor) OnMessage(message any) error {
+	m := message.(SlowWork)
+	// Tests only: actors should never block like this.
+	time.Sleep(2 * time.Millisecond)
+	actor.context.Tell(m.Recorder, RecordName{m.Name})
+	return nil
+}
+
+func init() {
+	gob.Register(RecordName{})
+	gob.Register(RecordDump{})
+	gob.Register(SlowWork{})
+}
+
+// Runs count sendActor -> receiveActor pairs on the given dispatcher and
+// checks that every receiver gets its messages in order.
+func runTestDispatcherOrder(t *testing.T, system *actor.ActorSystem, dispatcher actor.Dispatcher, pairs int) {
+	reportChs := make([]<-chan any, pairs)
+	for i := 0; i < pairs; i++ {
+		var reportRef *actor.ActorRef
+		reportRef, reportChs[i] = system.NewChannelRef()
+		receiverRef := system.StartActorWith(newReceiveActor, dispatcher)
+		system.Tell(receiverRef, ReceiveActorInit{Count: remoteTellCount, CheckOrder: true, ReportRef: reportRef})
+		senderRef := system.StartActorWith(newSendActor, dispatcher)
+		system.Tell(senderRef, SendActorCmd{Target: receiverRef, Count: remoteTellCount})
+	}
+
+	timeoutCh := time.After(dispatcherDeadline)
+	for i, reportCh := range reportChs {
+		select {
+		case report := <-reportCh:
+			if errSt, ok := report.(string); ok {
+				t.Fatalf("Pair %d: %s", i, errSt)
+			}
+		case <-timeoutCh:
+			t.Fatalf("Pair %d did not finish within %s", i, dispatcherDeadline)
+		}
+	}
+}
+
+func TestDispatcherPoolOrder(t *testing.T) {
+	fmt.Printf("=== %s: %s\n", t.Name(), "Many actors on a small worker pool each handle their messages in order")
+
+	systems := setupTestRemoteTell(t)
+	defer teardownTestRemoteTell(systems)
+
+	runTestDispatcherOrder(t, systems[0], systems[0].NewPoolDispatcher(4, 2), 500)
+}
+
+func TestDispatcherPinnedOrder(t *testing.T) {
+	fmt.Printf("=== %s: %s\n", t.Name(), "Actors on pinned threads handle their messages in order")
+
+	systems := setupTestRemoteTell(t)
+	defer teardownTestRemoteTell(systems)
+
+	runTestDispatcherOrder(t, systems[0], actor.NewPinnedDispatcher(), 4)
+}
+
+func TestDispatcherPoolFairness(t *testing.T) {
+	fmt.Printf("=== %s: %s\n", t.Name(), "A one-worker pool interleaves a busy actor with a newly-scheduled one")
+
+	systems := setupTestRemoteTell(t)
+	defer teardownTestRemoteTell(systems)
+	system := systems[0]
+
+	recorderRef := system.StartActor(newRecordActor)
+	pool := system.NewPoolDispatcher(1, 1)
+	busyRef := system.StartActorWith(newSlowActor, pool)
+	otherRef := system.StartActorWith(newSlowActor, pool)
+
+	const busyCount = 20
+	for i := 0; i < busyCount; i++ {
+		system.Tell(busyRef, SlowWork{Name: "busy", Recorder: recorderRef})
+	}
+	system.Tell(otherRef, SlowWork{Name: "other", Recorder: recorderRef})
+
+	// Wait for all work to be recorded.
+	time.Sleep(time.Duration(2*(busyCount+1)) * time.Millisecond)
+	var names []string
+	deadline := time.Now().Add(dispatcherDeadline)
+	for len(names) < busyCount+1 {
+		if time.Now().After(deadline) {
+			t.Fatalf("Only %d of %d messages handled within %s", len(names), busyCount+1, dispatcherDeadline)
+		}
+		reportRef, reportCh := system.NewChannelRef()
+		system.Tell(recorderRef, RecordDump{reportRef})
+		names = (<-reportCh).([]string)
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	for i, name := range names {
+		if name == "other" {
+			if i == busyCount {
+				t.Errorf("Newly-scheduled actor waited for all %d of the busy actor's messages", busyCount)
+			}
+			return
+		}
+	}
+	t.Errorf("Newly-scheduled actor's message was never handled: %v", names)
+}
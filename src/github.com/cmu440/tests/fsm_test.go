@@ -0,0 +1,162 @@
+// Tests for the actor/fsm package.
+
+package tests
+
+import (
+	"encoding/gob"
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/cmu440/actor"
+	"github.com/cmu440/actor/fsm"
+	"github.com/cmu440/actor/testkit"
+)
+
+const doorOpenTimeout = time.Duration(100) * time.Millisecond
+
+// A door that closes itself doorOpenTimeout after being opened, and reports
+// its transitions to a watcher.
+type doorData struct {
+	Opens   int
+	Watcher *actor.ActorRef
+}
+
+type DoorWatch struct {
+	Watcher *actor.ActorRef
+}
+
+type DoorOpen struct{}
+
+type DoorClose struct{}
+
+type DoorTransition struct {
+	From  fsm.State
+	To    fsm.State
+	Opens int
+}
+
+func init() {
+	gob.Register(DoorWatch{})
+	gob.Register(DoorOpen{})
+	gob.Register(DoorClose{})
+	gob.Register(DoorTransition{})
+}
+
+func newDoorActor(context *actor.ActorContext) actor.Actor {
+	return fsm.New(context, "closed", doorData{}).
+		When("closed", func(m *fsm.FSM[doorData], message any) error {
+			switch message.(type) {
+			case DoorOpen:
+				data := m.Data()
+				data.Opens++
+				m.SetData(data)
+				m.Goto("open")
+			case DoorClose:
+				// Already closed.
+			default:
+				return fmt.Errorf("closed door got %T", message)
+			}
+			return nil
+		}).
+		WhenWithTimeout("open", doorOpenTimeout, func(m *fsm.FSM[doorData], message any) error {
+			switch message.(type) {
+			case DoorClose, fsm.StateTimeout:
+				m.Goto("closed")
+			case DoorOpen:
+				// Re-entering restarts the timeout.
+				m.Goto("open")
+			default:
+				return fmt.Errorf("open door got %T", message)
+			}
+			return nil
+		}).
+		WhenUnhandled(func(m *fsm.FSM[doorData], message any) error {
+			return fmt.Errorf("unhandled %T", message)
+		}).
+		OnTransition(func(m *fsm.FSM[doorData], from fsm.State, to fsm.State) {
+			if m.Data().Watcher != nil {
+				m.Context().Tell(m.Data().Watcher, DoorTransition{from, to, m.Data().Opens})
+			}
+		}).
+		Build()
+}
+
+// Wraps newDoorActor so that DoorWatch is handled in any state.
+func newWatchedDoorActor(context *actor.ActorContext) actor.Actor {
+	return &watchedDoor{newDoorActor(context).(*fsm.FSM[doorData])}
+}
+
+type watchedDoor struct {
+	door *fsm.FSM[doorData]
+}
+
+func (actor *watchedDoor) OnMessage(message any) error {
+	if m, ok := message.(DoorWatch); ok {
+		data := actor.door.Data()
+		data.Watcher = m.Watcher
+		actor.door.SetData(data)
+		return nil
+	}
+	return actor.door.OnMessage(message)
+}
+
+func TestFSMTransitions(t *testing.T) {
+	fmt.Printf("=== %s: %s\n", t.Name(), "FSM handlers transition between states and keep state data")
+
+	system := testkit.NewSystem(t)
+	probe := testkit.NewTestProbe(t, system)
+	door := testkit.NewTestActorRef(t, system, newWatchedDoorActor)
+	machine := door.Actor().(*watchedDoor).door
+
+	door.Receive(DoorWatch{probe.Ref()})
+	door.Receive(DoorClose{})
+	if machine.State() != "closed" {
+		t.Fatalf("State %q after DoorClose, expected closed", machine.State())
+	}
+	door.Receive(DoorOpen{})
+	probe.ExpectMsg(DoorTransition{"closed", "open", 1})
+	door.Receive(DoorClose{})
+	probe.ExpectMsg(DoorTransition{"open", "closed", 1})
+	door.Receive(DoorOpen{})
+	probe.ExpectMsg(DoorTransition{"closed", "open", 2})
+
+	if err := door.ReceiveErr(DoorWatch{}); err != nil {
+		t.Errorf("DoorWatch returned error %s", err)
+	}
+	if err := door.ReceiveErr("knock"); err == nil {
+		t.Errorf("Unexpected message in open state did not return an error")
+	}
+	if machine.State() != "open" || machine.Data().Opens != 2 {
+		t.Errorf("Failed handler changed the machine: state %q, data %+v", machine.State(), machine.Data())
+	}
+}
+
+func TestFSMTimeout(t *testing.T) {
+	fmt.Printf("=== %s: %s\n", t.Name(), "FSM state timeouts fire via TellAfter, and stale ones are dropped")
+
+	system := testkit.NewSystem(t)
+	probe := testkit.NewTestProbe(t, system)
+	door := system.StartActor(newWatchedDoorActor)
+	system.Tell(door, DoorWatch{probe.Ref()})
+
+	system.Tell(door, DoorOpen{})
+	probe.ExpectMsg(DoorTransition{"closed", "open", 1})
+	probe.ExpectMsgWithin(4*doorOpenTimeout, DoorTransition{"open", "closed", 1})
+
+	// Open, then close and re-open before the first timeout: only the
+	// second opening's timeout may close the door.
+	system.Tell(door, DoorOpen{})
+	probe.ExpectMsg(DoorTransition{"closed", "open", 2})
+	time.Sleep(doorOpenTimeout / 2)
+	system.Tell(door, DoorClose{})
+	system.Tell(door, DoorOpen{})
+	probe.ExpectMsg(DoorTransition{"open", "closed", 2})
+	probe.ExpectMsg(DoorTransition{"closed", "open", 3})
+	opened := time.Now()
+	probe.ExpectMsgWithin(4*doorOpenTimeout, DoorTransition{"open", "closed", 3})
+	if elapsed := time.Since(opened); elapsed < doorOpenTimeout*3/4 {
+		t.Errorf("Door closed %s after re-opening; a stale timeout fired", elapsed)
+	}
+	probe.ExpectNoMsg(2 * doorOpenTimeout)
+}
@@ -0,0 +1,94 @@
+// Key-value store tests for gossip dissemination.
+
+package tests
+
+import (
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/cmu440/actor"
+	"github.com/cmu440/kvserver"
+	"github.com/cmu440/staff"
+)
+
+var testGossip = kvserver.Gossip{Fanout: 2, Rounds: 4, PushPull: true}
+
+// Starts a server per entry of actorCounts with that many query actors,
+// spreading updates by gossip, and returns a client per actor.
+func setupTestGossip(t *testing.T, actorCounts []int, gossip kvserver.Gossip) ([]clientWr, []serverWr) {
+	staff.SetArtiLatencyMs(remoteServerLatencyMs)
+	config := kvserver.DefaultConfig()
+	config.Gossip = gossip
+
+	servers := make([]serverWr, len(actorCounts))
+	descsSoFar := []string{}
+	clients := []clientWr{}
+	for i, count := range actorCounts {
+		port := newPort()
+		server, desc, err := kvserver.NewServerWithConfig(port, count, append([]string{}, descsSoFar...), config)
+		if err != nil {
+			t.Fatalf("Failed to start server on ports %d-%d: %s", port, port+count, err)
+		}
+		servers[i] = serverWr{server, desc, actor.LastActorSystem()}
+		descsSoFar = append(descsSoFar, desc)
+
+		for j := 0; j < count; j++ {
+			address := fmt.Sprintf("localhost:%d", port+1+j)
+			clients = append(clients, newClient(address, fmt.Sprintf("server %d, actor %d", i, j)))
+		}
+	}
+	time.Sleep(time.Duration(4*remoteServerLatencyMs) * time.Millisecond)
+	return clients, servers
+}
+
+func TestGossipConverges(t *testing.T) {
+	fmt.Printf("=== %s: %s\n", t.Name(), "10 servers x 2 actors: Put on all actors, Get on all, with bounded messages per sync")
+
+	actorCounts := make([]int, 10)
+	for i := range actorCounts {
+		actorCounts[i] = 2
+	}
+	start := time.Now()
+	clients, servers := setupTestGossip(t, actorCounts, testGossip)
+	defer teardownTestRemoteSync(clients, servers)
+
+	tracePutAllGet(t, clients, remoteSyncDeadline, "")
+
+	// Per sync, each actor tells itself the next SynSignal, gossips to
+	// Fanout peers, answers up to Fanout pulls, and may send anti-entropy to
+	// one more actor.
+	messages := 0
+	for i, server := range servers {
+		stats := server.system.Stats()
+		messages += stats.MessagesSentActor
+		if stats.MaxMessageRate > maxMessageRate {
+			t.Errorf("(Server %d) Max message rate per sender->receiver pair exceeded: %.2f > %.2f/sec", i, stats.MaxMessageRate, maxMessageRate)
+		}
+	}
+	syncs := float64(len(clients)) * (time.Since(start).Seconds()/0.1 + 1)
+	perSync := float64(messages) / syncs
+	t.Logf("Messages per actor per sync: %.2f", perSync)
+	if limit := float64(2*testGossip.Fanout + 2); perSync > limit {
+		t.Errorf("Messages per actor per sync exceeded: %.2f > %.2f", perSync, limit)
+	}
+}
+
+func TestGossipNoBcast(t *testing.T) {
+	fmt.Printf("=== %s: %s\n", t.Name(), "Gossip to servers with 1 and 4 actors should have similar network usage")
+
+	clients, servers := setupTestGossip(t, []int{1, 1, 4}, testGossip)
+	defer teardownTestRemoteSync(clients, servers)
+
+	for round := 0; round < 3; round++ {
+		tracePutGet(t, clients, remoteSyncDeadline, fmt.Sprint(round))
+	}
+
+	stats1 := servers[1].system.Stats()
+	stats4 := servers[2].system.Stats()
+	t.Logf("Bytes sent to 1-actor server: %d", stats1.RemoteBytesReceived)
+	t.Logf("Bytes sent to 4-actor server: %d", stats4.RemoteBytesReceived)
+	if stats4.RemoteBytesReceived > 2*stats1.RemoteBytesReceived {
+		t.Errorf("1-actor server received %d bytes, 4-actor server received %d bytes, expected these to be similar", stats1.RemoteBytesReceived, stats4.RemoteBytesReceived)
+	}
+}
@@ -0,0 +1,109 @@
+// Key-value store tests for hybrid logical clock versions.
+
+package tests
+
+import (
+	"fmt"
+	"net/rpc"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/cmu440/kvclient"
+	"github.com/cmu440/kvcommon"
+)
+
+func TestHLCSameMillisecond(t *testing.T) {
+	fmt.Printf("=== %s: %s\n", t.Name(), "Back-to-back Puts on one actor get increasing versions")
+
+	clients, server := setupTestLocalSync(t, 1)
+	defer teardownTestLocalSync(clients, server)
+	c := clients[0]
+
+	var last kvclient.Version
+	for i := 0; i < 50; i++ {
+		version, err := c.c.PutWithVersion("balance/alice", strconv.Itoa(i))
+		if err != nil {
+			t.Fatalf("[ERROR] PutWithVersion returned error: %s", err)
+		}
+		if !version.Newer(last) {
+			t.Fatalf("[ERROR] Put %d got version %+v, not newer than %+v", i, version, last)
+		}
+		if i > 0 && version.Origin != last.Origin {
+			t.Errorf("[ERROR] Puts to one actor got origins %q and %q", last.Origin, version.Origin)
+		}
+		last = version
+	}
+	if elapsed := time.Since(last.Time()); elapsed < 0 || elapsed > time.Minute {
+		t.Errorf("[ERROR] Version time %s is far from now", last.Time())
+	}
+	get(t, true, c, "balance/alice", "49", true)
+}
+
+func TestHLCReadYourWrites(t *testing.T) {
+	fmt.Printf("=== %s: %s\n", t.Name(), "Versions work as read-your-writes tokens and order causally later writes")
+
+	clients, server := setupTestLocalSync(t, 2)
+	defer teardownTestLocalSync(clients, server)
+
+	token, err := clients[0].c.PutWithVersion("loc/alice", "fence")
+	if err != nil {
+		t.Fatalf("[ERROR] PutWithVersion returned error: %s", err)
+	}
+
+	// Wait until actor 1 has seen the write.
+	deadline := time.Now().Add(localSyncDeadline)
+	for {
+		value, version, _, err := clients[1].c.GetWithVersion("loc/alice")
+		if err != nil {
+			t.Fatalf("[ERROR] GetWithVersion returned error: %s", err)
+		}
+		if !token.Newer(version) {
+			if value != "fence" {
+				t.Errorf("[ERROR] Read with version %+v not older than the token gave %q", version, value)
+			}
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("[ERROR] Write with token %+v never reached actor 1", token)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	// Actor 1's clock has observed the write, so its own write is newer and
+	// wins everywhere, whatever the wall clock says.
+	version, err := clients[1].c.PutWithVersion("loc/alice", "bridge")
+	if err != nil {
+		t.Fatalf("[ERROR] PutWithVersion returned error: %s", err)
+	}
+	if !version.Newer(token) {
+		t.Errorf("[ERROR] Causally later write got version %+v, not newer than %+v", version, token)
+	}
+	waitForSync(t, localSyncDeadline)
+	for _, client := range clients {
+		get(t, true, client, "loc/alice", "bridge", true)
+	}
+}
+
+func TestHLCPutVersionAfter(t *testing.T) {
+	fmt.Printf("=== %s: %s\n", t.Name(), "PutVersion supersedes its After version, even one from a clock far ahead")
+
+	port := newPort()
+	server := newServer(t, port, 1, []string{})
+	defer server.s.Close()
+
+	conn, err := rpc.Dial("tcp", fmt.Sprintf("localhost:%d", port+1))
+	if err != nil {
+		t.Fatalf("[ERROR] Dial failed: %s", err)
+	}
+	defer conn.Close()
+	after := kvcommon.Version{Timestamp: kvcommon.HLCTimestamp(time.Now().Add(time.Hour)), Origin: "ahead"}
+	args := kvcommon.PutArgs{Key: "loc/alice", Value: "fence", After: after}
+	reply := kvcommon.PutVersionReply{}
+	if err := conn.Call("QueryReceiver.PutVersion", args, &reply); err != nil {
+		t.Fatalf("[ERROR] PutVersion returned error: %s", err)
+	}
+	if !reply.Version.Newer(after) {
+		t.Fatalf("[ERROR] PutVersion got version %+v, not newer than its After %+v", reply.Version, after)
+	}
+}
@@ -0,0 +1,118 @@
+// Tests for ActorSystem.Metrics and its Prometheus handler.
+
+package tests
+
+import (
+	"fmt"
+	"io"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/cmu440/actor"
+)
+
+const metricsDeadline = time.Duration(2) * time.Second
+
+// Polls system.Metrics() until cond holds, or fails the test.
+func waitForMetrics(t *testing.T, system *actor.ActorSystem, desc string, cond func(m actor.Metrics) bool) actor.Metrics {
+	deadline := time.Now().Add(metricsDeadline)
+	for {
+		m := system.Metrics()
+		if cond(m) {
+			return m
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("Metrics did not show %s within %s: %+v", desc, metricsDeadline, m)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+func TestMetricsActors(t *testing.T) {
+	fmt.Printf("=== %s: %s\n", t.Name(), "Per-actor and per-link metrics count messages, errors and processing time")
+
+	systems := setupTestRemoteTell(t)
+	defer teardownTestRemoteTell(systems)
+
+	reportRef, reportCh := systems[1].NewChannelRef()
+	receiverRef := systems[1].StartActor(newReceiveActor)
+	systems[1].Tell(receiverRef, ReceiveActorInit{Count: remoteTellCount, ReportRef: reportRef})
+	senderRef := systems[0].StartActor(newSendActor)
+	systems[0].Tell(senderRef, SendActorCmd{Target: receiverRef, Count: remoteTellCount})
+	select {
+	case <-reportCh:
+	case <-time.After(metricsDeadline):
+		t.Fatalf("Did not receive report within %s", metricsDeadline)
+	}
+
+	sender := waitForMetrics(t, systems[0], "sender's messages", func(m actor.Metrics) bool {
+		return len(m.Actors) == 1 && m.Actors[0].MessagesIn == 1
+	})
+	if sender.Actors[0].MessagesOut != remoteTellCount {
+		t.Errorf("Sender MessagesOut = %d, expected %d", sender.Actors[0].MessagesOut, remoteTellCount)
+	}
+	if len(sender.Links) != 1 || sender.Links[0].Address != receiverRef.Address {
+		t.Fatalf("Expected one link to %s, got %+v", receiverRef.Address, sender.Links)
+	}
+	if sender.Links[0].MessagesSent != remoteTellCount || sender.Links[0].BytesSent == 0 {
+		t.Errorf("Link metrics %+v, expected %d messages and nonzero bytes", sender.Links[0], remoteTellCount)
+	}
+
+	receiver := waitForMetrics(t, systems[1], "receiver's messages", func(m actor.Metrics) bool {
+		return len(m.Actors) == 1 && m.Actors[0].MessagesIn == remoteTellCount+1
+	})
+	a := receiver.Actors[0]
+	if a.Actor != receiverRef.Uid() {
+		t.Errorf("Actor label %q, expected %q", a.Actor, receiverRef.Uid())
+	}
+	if a.MessagesOut != 1 || a.Errors != 0 || a.MailboxDepth != 0 {
+		t.Errorf("Receiver metrics %+v, expected 1 message out, no errors, empty mailbox", a)
+	}
+	if total := a.ProcessingCounts[len(a.ProcessingCounts)-1]; total != a.MessagesIn {
+		t.Errorf("Processing histogram counts %d calls, expected %d", total, a.MessagesIn)
+	}
+	if receiver.RemoteBytesReceived == 0 {
+		t.Errorf("RemoteBytesReceived is 0")
+	}
+}
+
+func TestMetricsPrometheus(t *testing.T) {
+	fmt.Printf("=== %s: %s\n", t.Name(), "MetricsHandler serves Prometheus text format")
+
+	systems := setupTestRemoteTell(t)
+	defer teardownTestRemoteTell(systems)
+
+	reportRef, reportCh := systems[0].NewChannelRef()
+	receiverRef := systems[0].StartActor(newReceiveActor)
+	systems[0].Tell(receiverRef, ReceiveActorInit{Count: 1, ReportRef: reportRef})
+	systems[0].Tell(receiverRef, 1)
+	<-reportCh
+	waitForMetrics(t, systems[0], "processed messages", func(m actor.Metrics) bool {
+		return len(m.Actors) == 1 && m.Actors[0].ProcessingCounts[len(m.Actors[0].ProcessingCounts)-1] == 2
+	})
+
+	recorder := httptest.NewRecorder()
+	systems[0].MetricsHandler().ServeHTTP(recorder, httptest.NewRequest("GET", "/metrics", nil))
+	if ct := recorder.Header().Get("Content-Type"); !strings.HasPrefix(ct, "text/plain") {
+		t.Errorf("Content-Type %q, expected text/plain", ct)
+	}
+	body, _ := io.ReadAll(recorder.Body)
+	text := string(body)
+
+	label := fmt.Sprintf("actor=%q", receiverRef.Uid())
+	for _, want := range []string{
+		"# TYPE actor_messages_in_total counter",
+		"# TYPE actor_processing_seconds histogram",
+		`actor_system_messages_sent_total{source="external"} 2`,
+		fmt.Sprintf("actor_messages_in_total{%s} 2", label),
+		fmt.Sprintf("actor_messages_out_total{%s} 1", label),
+		fmt.Sprintf(`actor_processing_seconds_bucket{%s,le="+Inf"} 2`, label),
+		fmt.Sprintf("actor_processing_seconds_count{%s} 2", label),
+	} {
+		if !strings.Contains(text, want) {
+			t.Errorf("Metrics output missing %q:\n%s", want, text)
+		}
+	}
+}
@@ -0,0 +1,117 @@
+// Key-value store tests for the MultiValue conflict mode.
+
+package tests
+
+import (
+	"fmt"
+	"reflect"
+	"sort"
+	"testing"
+
+	"github.com/cmu440/kvclient"
+	"github.com/cmu440/kvserver"
+)
+
+// Starts a server with queryActorCount query actors, keeping siblings for
+// keys under "cart/", and returns a client per actor.
+func setupTestMultiValue(t *testing.T, queryActorCount int) ([]clientWr, *kvserver.Server) {
+	config := kvserver.DefaultConfig()
+	config.ConflictModes = map[string]kvserver.ConflictMode{"cart/": kvserver.MultiValue}
+
+	port := newPort()
+	server, _, err := kvserver.NewServerWithConfig(port, queryActorCount, []string{}, config)
+	if err != nil {
+		t.Fatalf("Failed to start server on ports %d-%d: %s", port, port+queryActorCount, err)
+	}
+	clients := make([]clientWr, queryActorCount)
+	for i := 0; i < queryActorCount; i++ {
+		clients[i] = newClient(fmt.Sprintf("localhost:%d", port+1+i), fmt.Sprintf("actor %d", i))
+	}
+	return clients, server
+}
+
+func getSiblings(t *testing.T, client clientWr, key string, expValues []string) kvclient.CausalContext {
+	values, context, err := client.c.GetSiblings(key)
+	if err != nil {
+		t.Fatalf("[ERROR] (%s) GetSiblings(%q) returned error: %s", client.name, key, err)
+	}
+	// Siblings are ordered by version, which depends on clocks; compare as sets.
+	sort.Strings(values)
+	expValues = append([]string{}, expValues...)
+	sort.Strings(expValues)
+	if len(values) != len(expValues) || (len(values) > 0 && !reflect.DeepEqual(values, expValues)) {
+		t.Errorf("[ERROR] (%s) GetSiblings(%q) gave %q, but expected %q", client.name, key, values, expValues)
+	}
+	return context
+}
+
+func putWithContext(t *testing.T, client clientWr, key string, value string, context kvclient.CausalContext) {
+	if err := client.c.PutWithContext(key, value, context); err != nil {
+		t.Fatalf("[ERROR] (%s) PutWithContext(%q, %q) returned error: %s", client.name, key, value, err)
+	}
+}
+
+func TestMultiValueSiblings(t *testing.T) {
+	fmt.Printf("=== %s: %s\n", t.Name(), "Concurrent Puts are kept as siblings until resolved")
+
+	clients, server := setupTestMultiValue(t, 2)
+	defer teardownTestLocalSync(clients, serverWr{s: server})
+
+	// Before syncing: concurrent writes to a multi-value and an LWW key.
+	putWithContext(t, clients[0], "cart/alice", "apple", nil)
+	putWithContext(t, clients[1], "cart/alice", "pear", nil)
+	putWithContext(t, clients[0], "loc/alice", "fence", nil)
+	putWithContext(t, clients[1], "loc/alice", "bridge", nil)
+	waitForSync(t, localSyncDeadline)
+
+	// Get shows the same newest sibling everywhere.
+	newest, _, _ := clients[0].c.Get("cart/alice")
+	if newest != "apple" && newest != "pear" {
+		t.Errorf("[ERROR] Get gave %q, not a sibling", newest)
+	}
+	lww, _, _ := clients[0].c.Get("loc/alice")
+	for _, client := range clients {
+		getSiblings(t, client, "cart/alice", []string{"apple", "pear"})
+		get(t, true, client, "cart/alice", newest, true)
+		getSiblings(t, client, "loc/alice", []string{lww})
+	}
+
+	// A blind Put on the same actor is concurrent with its earlier siblings too.
+	putWithContext(t, clients[0], "cart/alice", "plum", nil)
+	context := getSiblings(t, clients[0], "cart/alice", []string{"apple", "pear", "plum"})
+
+	putWithContext(t, clients[0], "cart/alice", "apple,pear,plum", context)
+	getSiblings(t, clients[0], "cart/alice", []string{"apple,pear,plum"})
+	waitForSync(t, localSyncDeadline)
+	for _, client := range clients {
+		getSiblings(t, client, "cart/alice", []string{"apple,pear,plum"})
+	}
+}
+
+func TestMultiValueConcurrentResolve(t *testing.T) {
+	fmt.Printf("=== %s: %s\n", t.Name(), "Resolving keeps siblings the context has not seen")
+
+	clients, server := setupTestMultiValue(t, 3)
+	defer teardownTestLocalSync(clients, serverWr{s: server})
+
+	putWithContext(t, clients[0], "cart/bob", "apple", nil)
+	putWithContext(t, clients[1], "cart/bob", "pear", nil)
+	waitForSync(t, localSyncDeadline)
+	context := getSiblings(t, clients[2], "cart/bob", []string{"apple", "pear"})
+
+	// Actor 2 resolves what it saw while actor 0 adds another sibling.
+	putWithContext(t, clients[2], "cart/bob", "apple,pear", context)
+	put(t, true, clients[0], "cart/bob", "fig")
+	waitForSync(t, localSyncDeadline)
+	for _, client := range clients {
+		getSiblings(t, client, "cart/bob", []string{"apple,pear", "fig"})
+	}
+
+	// Deleting supersedes all siblings the replica has.
+	del(t, true, clients[1], "cart/bob", true)
+	waitForSync(t, localSyncDeadline)
+	for _, client := range clients {
+		getSiblings(t, client, "cart/bob", []string{})
+		get(t, true, client, "cart/bob", "", false)
+	}
+}
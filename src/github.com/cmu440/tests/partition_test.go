@@ -0,0 +1,172 @@
+// Key-value store tests for partitioned mode.
+
+package tests
+
+import (
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/cmu440/actor"
+	"github.com/cmu440/kvserver"
+	"github.com/cmu440/staff"
+)
+
+// Starts a server with count query actors holding each key on replicas of
+// them, joining the servers in descs, and returns a client per actor.
+func startPartitionedServer(t *testing.T, name string, count int, descs []string, replicas int) ([]clientWr, serverWr) {
+	config := kvserver.DefaultConfig()
+	config.Replicas = replicas
+
+	port := newPort()
+	server, desc, err := kvserver.NewServerWithConfig(port, count, append([]string{}, descs...), config)
+	if err != nil {
+		t.Fatalf("Failed to start server on ports %d-%d: %s", port, port+count, err)
+	}
+	clients := make([]clientWr, count)
+	for j := 0; j < count; j++ {
+		address := fmt.Sprintf("localhost:%d", port+1+j)
+		clients[j] = newClient(address, fmt.Sprintf("%s, actor %d", name, j))
+	}
+	return clients, serverWr{server, desc, actor.LastActorSystem()}
+}
+
+// Starts serverCount servers with queryActorsPerServer query actors each,
+// holding each key on replicas query actors, and returns a client per actor.
+func setupTestPartitioned(t *testing.T, serverCount, queryActorsPerServer, replicas int) ([]clientWr, []serverWr) {
+	staff.SetArtiLatencyMs(remoteServerLatencyMs)
+	servers := make([]serverWr, serverCount)
+	descsSoFar := []string{}
+	clients := []clientWr{}
+	for i := 0; i < serverCount; i++ {
+		serverClients, server := startPartitionedServer(t, fmt.Sprintf("server %d", i), queryActorsPerServer, descsSoFar, replicas)
+		servers[i] = server
+		descsSoFar = append(descsSoFar, server.desc)
+		clients = append(clients, serverClients...)
+	}
+	time.Sleep(time.Duration(4*remoteServerLatencyMs) * time.Millisecond)
+	return clients, servers
+}
+
+func TestPartitionedReplicated(t *testing.T) {
+	fmt.Printf("=== %s: %s\n", t.Name(), "3 servers x 2 actors, 2 replicas: every actor serves every key")
+
+	clients, servers := setupTestPartitioned(t, 3, 2, 2)
+	defer teardownTestRemoteSync(clients, servers)
+
+	expected := make(map[string]string)
+	for i, client := range clients {
+		key := fmt.Sprintf("loc/%d", i)
+		put(t, true, client, key, fmt.Sprintf("value%d", i))
+		expected[key] = fmt.Sprintf("value%d", i)
+	}
+	clients[0].c.Increment("balance/alice", 3)
+	clients[5].c.Increment("balance/alice", 4)
+	waitForSync(t, remoteSyncDeadline)
+
+	for _, client := range clients {
+		for key, value := range expected {
+			get(t, true, client, key, value, true)
+		}
+		get(t, true, client, "balance/alice", "7", true)
+		list(t, true, client, "loc/", expected)
+	}
+
+	del(t, true, clients[3], "loc/1", true)
+	delete(expected, "loc/1")
+	waitForSync(t, remoteSyncDeadline)
+	for _, client := range clients {
+		get(t, true, client, "loc/1", "", false)
+		list(t, true, client, "loc/", expected)
+	}
+}
+
+func TestPartitionedForwarding(t *testing.T) {
+	fmt.Printf("=== %s: %s\n", t.Name(), "1 replica, all syncs lost: requests are forwarded to the key's owner")
+
+	clients, server := startPartitionedServer(t, "server", 3, []string{}, 1)
+	defer teardownTestLocalSync(clients, server)
+	server.system.SetDropFilter(func(ref *actor.ActorRef, message any) bool {
+		_, isSync := message.(kvserver.SynMsg)
+		return isSync
+	})
+
+	expected := make(map[string]string)
+	for i := 0; i < 12; i++ {
+		key := fmt.Sprintf("loc/%d", i)
+		put(t, true, clients[i%3], key, fmt.Sprintf("value%d", i))
+		expected[key] = fmt.Sprintf("value%d", i)
+	}
+	for _, client := range clients {
+		for key, value := range expected {
+			get(t, true, client, key, value, true)
+		}
+		list(t, true, client, "loc/", expected)
+	}
+}
+
+func TestPartitionedRebalance(t *testing.T) {
+	fmt.Printf("=== %s: %s\n", t.Name(), "1 replica: keys move to a server that joins later")
+
+	clients, servers := setupTestPartitioned(t, 2, 2, 1)
+	expected := make(map[string]string)
+	for i := 0; i < 12; i++ {
+		key := fmt.Sprintf("loc/%d", i)
+		put(t, false, clients[i%len(clients)], key, fmt.Sprintf("value%d", i))
+		expected[key] = fmt.Sprintf("value%d", i)
+	}
+	waitForSync(t, remoteSyncDeadline)
+
+	newClients, newServer := startPartitionedServer(t, "server 2", 2, []string{servers[0].desc, servers[1].desc}, 1)
+	clients = append(clients, newClients...)
+	servers = append(servers, newServer)
+	defer teardownTestRemoteSync(clients, servers)
+	waitForSync(t, remoteSyncDeadline)
+
+	for _, client := range clients {
+		for key, value := range expected {
+			get(t, false, client, key, value, true)
+		}
+		list(t, true, client, "loc/", expected)
+	}
+}
+
+func TestPartitionedLostForward(t *testing.T) {
+	fmt.Printf("=== %s: %s\n", t.Name(), "1 replica, all forwards lost: forwarded requests fail instead of hanging")
+
+	config := kvserver.DefaultConfig()
+	config.Replicas = 1
+	config.RequestTimeout = 300 * time.Millisecond
+	port := newPort()
+	server, desc, err := kvserver.NewServerWithConfig(port, 3, []string{}, config)
+	if err != nil {
+		t.Fatalf("Failed to start server on ports %d-%d: %s", port, port+3, err)
+	}
+	clients := []clientWr{newClient(fmt.Sprintf("localhost:%d", port+1), "actor 0")}
+	wr := serverWr{server, desc, actor.LastActorSystem()}
+	defer teardownTestLocalSync(clients, wr)
+	wr.system.SetDropFilter(func(ref *actor.ActorRef, message any) bool {
+		_, isForward := message.(kvserver.Forward)
+		return isForward
+	})
+
+	// Some of the keys are owned by other actors than actor 0.
+	failed := map[string]int{}
+	for i := 0; i < 9; i++ {
+		key := fmt.Sprintf("loc/%d", i)
+		if _, err := clients[0].c.Delete(key); err != nil {
+			failed["Delete"]++
+		}
+		if _, err := clients[0].c.PutIfAbsent(key, "fence"); err != nil {
+			failed["PutIfAbsent"]++
+		}
+		if _, _, err := clients[0].c.Increment("balance/"+key, 1); err != nil {
+			failed["Increment"]++
+		}
+	}
+	for _, method := range []string{"Delete", "PutIfAbsent", "Increment"} {
+		if failed[method] == 0 {
+			t.Fatalf("[ERROR] No %s failed, expected those forwarded to fail", method)
+		}
+	}
+}
@@ -0,0 +1,164 @@
+// Client tests for connection pooling.
+
+package tests
+
+import (
+	"fmt"
+	"net"
+	"net/rpc"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/cmu440/kvclient"
+	"github.com/cmu440/kvcommon"
+)
+
+// RPC server that counts its connections. Get returns the key as the value,
+// holding a Get of "slow" until release is closed.
+type poolServer struct {
+	ln      net.Listener
+	release chan struct{}
+	// Number of connections accepted, and of those still open.
+	accepted atomic.Int32
+	open     atomic.Int32
+	mux      sync.Mutex
+	conns    []net.Conn
+}
+
+func (server *poolServer) Get(args kvcommon.GetArgs, reply *kvcommon.GetReply) error {
+	if args.Key == "slow" {
+		<-server.release
+	}
+	*reply = kvcommon.GetReply{Value: args.Key, Ok: true}
+	return nil
+}
+
+func newPoolServer(t *testing.T) *poolServer {
+	server := &poolServer{release: make(chan struct{})}
+	rpcServer := rpc.NewServer()
+	if err := rpcServer.RegisterName("QueryReceiver", server); err != nil {
+		t.Fatalf("Error while starting RPC server: %s", err)
+	}
+	ln, err := net.Listen("tcp", fmt.Sprintf("localhost:%d", newPort()))
+	if err != nil {
+		t.Fatalf("Error while starting RPC server: %s", err)
+	}
+	server.ln = ln
+	go func() {
+		for {
+			conn, err := ln.Accept()
+			if err != nil {
+				return
+			}
+			server.accepted.Add(1)
+			server.open.Add(1)
+			server.mux.Lock()
+			server.conns = append(server.conns, conn)
+			server.mux.Unlock()
+			go func() {
+				rpcServer.ServeConn(conn)
+				server.open.Add(-1)
+			}()
+		}
+	}()
+	return server
+}
+
+// Closes all connections of server from its side.
+func (server *poolServer) dropConns() {
+	server.mux.Lock()
+	defer server.mux.Unlock()
+	for _, conn := range server.conns {
+		conn.Close()
+	}
+}
+
+// Checks that server has accepted and still has open the given numbers of
+// connections, waiting for connections closing.
+func expectConns(t *testing.T, server *poolServer, accepted int32, open int32) {
+	for start := time.Now(); time.Since(start) < localSyncDeadline; time.Sleep(10 * time.Millisecond) {
+		if server.accepted.Load() == accepted && server.open.Load() == open {
+			return
+		}
+	}
+	t.Fatalf("[ERROR] Server has accepted %d and open %d connections, expected %d and %d",
+		server.accepted.Load(), server.open.Load(), accepted, open)
+}
+
+// Calls Get on client, checking that it succeeds.
+func poolGet(t *testing.T, client *kvclient.Client, key string) {
+	value, ok, err := client.Get(key)
+	if err != nil || !ok || value != key {
+		t.Errorf("[ERROR] Get(%q) gave (%q, %t, %v), expected (%q, true, nil)", key, value, ok, err, key)
+	}
+}
+
+func TestPoolReusesConnection(t *testing.T) {
+	fmt.Printf("=== %s: %s\n", t.Name(), "Concurrent and sequential calls share one pipelined connection")
+
+	server := newPoolServer(t)
+	defer server.ln.Close()
+	client := kvclient.NewClient(fixedAddressRouter{server.ln.Addr().String()})
+	defer client.Close()
+
+	// A call held by the server does not hold up the others.
+	slow := make(chan struct{})
+	go func() {
+		poolGet(t, client, "slow")
+		close(slow)
+	}()
+	var wg sync.WaitGroup
+	for i := 0; i < 50; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			poolGet(t, client, fmt.Sprintf("key%d", i))
+		}(i)
+	}
+	wg.Wait()
+	close(server.release)
+	<-slow
+	for i := 0; i < 20; i++ {
+		poolGet(t, client, fmt.Sprintf("key%d", i))
+	}
+	expectConns(t, server, 1, 1)
+}
+
+func TestPoolIdleAndClose(t *testing.T) {
+	fmt.Printf("=== %s: %s\n", t.Name(), "Idle connections are closed and redialed, and Close closes them all")
+
+	server := newPoolServer(t)
+	defer server.ln.Close()
+	client := kvclient.NewClient(fixedAddressRouter{server.ln.Addr().String()})
+	client.SetIdleTimeout(100 * time.Millisecond)
+
+	poolGet(t, client, "alice")
+	expectConns(t, server, 1, 0)
+	poolGet(t, client, "bob")
+	expectConns(t, server, 2, 1)
+
+	client.Close()
+	expectConns(t, server, 2, 0)
+	if _, _, err := client.Get("carol"); err != kvclient.ErrClosed {
+		t.Fatalf("[ERROR] Get after Close returned error %v, expected %v", err, kvclient.ErrClosed)
+	}
+}
+
+func TestPoolRedialsBrokenConnection(t *testing.T) {
+	fmt.Printf("=== %s: %s\n", t.Name(), "A call after the server closed the connection dials a new one")
+
+	server := newPoolServer(t)
+	defer server.ln.Close()
+	client := kvclient.NewClient(fixedAddressRouter{server.ln.Addr().String()})
+	defer client.Close()
+
+	poolGet(t, client, "alice")
+	server.dropConns()
+	expectConns(t, server, 1, 0)
+	// Let the client see the connection closed: a call sent before then fails, as it may have reached the server.
+	time.Sleep(100 * time.Millisecond)
+	poolGet(t, client, "bob")
+	expectConns(t, server, 2, 1)
+}
@@ -0,0 +1,69 @@
+// Key-value store tests for Put acknowledgements and write tokens.
+
+package tests
+
+import (
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/cmu440/kvclient"
+)
+
+func TestPutThenGetSameActor(t *testing.T) {
+	fmt.Printf("=== %s: %s\n", t.Name(), "A Get right after a Put on the same actor sees it")
+
+	clients, server := setupTestLocalSync(t, 2)
+	defer teardownTestLocalSync(clients, server)
+
+	for i := 0; i < 50; i++ {
+		value := fmt.Sprintf("value%d", i)
+		put(t, false, clients[i%2], "loc/alice", value)
+		if !get(t, false, clients[i%2], "loc/alice", value, true) {
+			break
+		}
+	}
+}
+
+func TestGetAfterWaitsForWrite(t *testing.T) {
+	fmt.Printf("=== %s: %s\n", t.Name(), "GetAfter on another server waits until the write reaches it")
+
+	clients, servers := setupTestRemoteSync(t, 2, 1)
+	defer teardownTestRemoteSync(clients, servers)
+
+	for i := 0; i < 3; i++ {
+		value := fmt.Sprintf("value%d", i)
+		version, err := clients[0].c.PutWithVersion("loc/alice", value)
+		if err != nil {
+			t.Fatalf("[ERROR] PutWithVersion failed: %s", err)
+		}
+		got, ok, err := clients[1].c.GetAfter("loc/alice", version)
+		if err != nil || !ok || got != value {
+			t.Fatalf("[ERROR] GetAfter gave (%q, %t, %v), but expected (%q, true, nil)", got, ok, err, value)
+		}
+	}
+	// The zero Version does not wait.
+	if _, _, err := clients[1].c.GetAfter("loc/bob", kvclient.Version{}); err != nil {
+		t.Fatalf("[ERROR] GetAfter with the zero Version failed: %s", err)
+	}
+}
+
+func TestGetAfterTimeout(t *testing.T) {
+	fmt.Printf("=== %s: %s\n", t.Name(), "GetAfter fails if the write never reaches the actor")
+
+	clients, server, _ := setupTestConsistency(t, 2, time.Second)
+	defer teardownTestLocalSync(clients, server)
+
+	version, err := clients[0].c.PutWithVersion("loc/alice", "fence")
+	if err != nil {
+		t.Fatalf("[ERROR] PutWithVersion failed: %s", err)
+	}
+	start := time.Now()
+	if _, _, err := clients[1].c.GetAfter("loc/alice", version); err == nil {
+		t.Fatalf("[ERROR] GetAfter succeeded on an actor that lost the write")
+	}
+	if time.Since(start) < 500*time.Millisecond {
+		t.Fatalf("[ERROR] GetAfter failed after %s without waiting for the write", time.Since(start))
+	}
+	get(t, true, clients[0], "loc/alice", "fence", true)
+}
@@ -0,0 +1,180 @@
+// Client tests for retries, failover and circuit breaking.
+
+package tests
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/cmu440/kvclient"
+	"github.com/cmu440/kvcommon"
+)
+
+// Returns the address of a port that nothing listens on.
+func downAddress() string {
+	return fmt.Sprintf("localhost:%d", newPort())
+}
+
+// Answers the next Get call to server with value.
+func answerGet(t *testing.T, server *simServer, value string) {
+	go func() {
+		call := <-server.callCh
+		if call.method != "Get" {
+			t.Errorf("Unexpected call: %s", call.method)
+		}
+		call.replyCh <- kvcommon.GetReply{Value: value, Ok: true}
+	}()
+}
+
+// Checks that Get of key on client gives value.
+func expectGet(t *testing.T, client *kvclient.Client, key string, value string) {
+	got, ok, err := client.Get(key)
+	if err != nil || !ok || got != value {
+		t.Fatalf("[ERROR] Get(%q) gave (%q, %t, %v), expected (%q, true, nil)", key, got, ok, err, value)
+	}
+}
+
+// Checks that err is a NetworkError from addr, with cause target if not nil.
+func expectNetworkError(t *testing.T, err error, addr string, target error) {
+	var netErr *kvclient.NetworkError
+	if !errors.As(err, &netErr) || netErr.Addr != addr || netErr.Sent {
+		t.Fatalf("[ERROR] Got error %v, expected an unsent NetworkError from %s", err, addr)
+	}
+	if target != nil && !errors.Is(err, target) {
+		t.Fatalf("[ERROR] Got error %v, expected %v", err, target)
+	}
+}
+
+func TestRetryFailover(t *testing.T) {
+	fmt.Printf("=== %s: %s\n", t.Name(), "Calls fail over from a down address, but server errors are not retried")
+
+	down := downAddress()
+	server := newSimServer(t, fmt.Sprintf("localhost:%d", newPort()))
+	defer server.ln.Close()
+	router := &dynamicAddressRouter{addresses: []string{down, server.ln.Addr().String()}}
+	client := kvclient.NewClient(router)
+	defer client.Close()
+
+	answerGet(t, server, "bar")
+	expectGet(t, client, "foo", "bar")
+	if router.Idx != 0 {
+		t.Fatalf("[ERROR] Get used %d addresses, expected 2", router.Idx)
+	}
+
+	// simServer has no Delete method.
+	router.Idx = 1
+	_, err := client.Delete("foo")
+	var serverErr *kvclient.ServerError
+	if !errors.As(err, &serverErr) || serverErr.Addr != server.ln.Addr().String() {
+		t.Fatalf("[ERROR] Delete returned error %v, expected a ServerError", err)
+	}
+	if router.Idx != 0 {
+		t.Fatalf("[ERROR] Delete was retried after a server error")
+	}
+}
+
+func TestRetryBackoff(t *testing.T) {
+	fmt.Printf("=== %s: %s\n", t.Name(), "Calls to down addresses are retried with backoff, then fail with a NetworkError")
+
+	addresses := []string{downAddress(), downAddress(), downAddress(), downAddress()}
+	router := &dynamicAddressRouter{addresses: addresses}
+	client := kvclient.NewClient(router)
+	defer client.Close()
+	policy := kvclient.RetryPolicy{Attempts: 3, Backoff: 50 * time.Millisecond, MaxBackoff: 80 * time.Millisecond}
+	client.SetRetryPolicy(policy)
+
+	start := time.Now()
+	_, _, err := client.Get("foo")
+	expectNetworkError(t, err, addresses[2], nil)
+	if elapsed := time.Since(start); elapsed < 130*time.Millisecond {
+		t.Fatalf("[ERROR] Get failed after %s, expected backoff of at least 130ms", elapsed)
+	}
+	if router.Idx != 3 {
+		t.Fatalf("[ERROR] Get made %d attempts, expected 3", router.Idx)
+	}
+}
+
+func TestRetryCircuitBreaker(t *testing.T) {
+	fmt.Printf("=== %s: %s\n", t.Name(), "An address that keeps failing is skipped until a probe after the cooldown")
+
+	addr := downAddress()
+	client := kvclient.NewClient(fixedAddressRouter{addr})
+	defer client.Close()
+	client.SetRetryPolicy(kvclient.RetryPolicy{Attempts: 1})
+	const cooldown = 200 * time.Millisecond
+	client.SetBreakerPolicy(kvclient.BreakerPolicy{Failures: 2, Cooldown: cooldown})
+
+	for i := 0; i < 2; i++ {
+		_, _, err := client.Get("foo")
+		expectNetworkError(t, err, addr, nil)
+		if errors.Is(err, kvclient.ErrCircuitOpen) {
+			t.Fatalf("[ERROR] Breaker opened after %d failures, expected 2", i)
+		}
+	}
+	_, _, err := client.Get("foo")
+	expectNetworkError(t, err, addr, kvclient.ErrCircuitOpen)
+
+	// The server comes up, but calls are not let through before the cooldown ends.
+	server := newSimServer(t, addr)
+	defer server.ln.Close()
+	_, _, err = client.Get("foo")
+	expectNetworkError(t, err, addr, kvclient.ErrCircuitOpen)
+
+	time.Sleep(cooldown)
+	answerGet(t, server, "bar")
+	expectGet(t, client, "foo", "bar")
+	answerGet(t, server, "baz")
+	expectGet(t, client, "foo", "baz")
+}
+
+func TestRetrySentWrites(t *testing.T) {
+	fmt.Printf("=== %s: %s\n", t.Name(), "Reads are retried after a request was sent, but writes are not")
+
+	// Reads each request, then closes the connection without replying.
+	ln, err := net.Listen("tcp", fmt.Sprintf("localhost:%d", newPort()))
+	if err != nil {
+		t.Fatalf("Error while starting server: %s", err)
+	}
+	defer ln.Close()
+	var accepted atomic.Int32
+	go func() {
+		for {
+			conn, err := ln.Accept()
+			if err != nil {
+				return
+			}
+			accepted.Add(1)
+			conn.Read(make([]byte, 1024))
+			conn.Close()
+		}
+	}()
+	client := kvclient.NewClient(fixedAddressRouter{ln.Addr().String()})
+	defer client.Close()
+	client.SetRetryPolicy(kvclient.RetryPolicy{Attempts: 3, Backoff: time.Millisecond, MaxBackoff: time.Millisecond})
+	client.SetBreakerPolicy(kvclient.BreakerPolicy{})
+
+	calls := []struct {
+		method   string
+		call     func() error
+		attempts int32
+	}{
+		{"Get", func() error { _, _, err := client.Get("foo"); return err }, 3},
+		{"Put", func() error { return client.Put("foo", "bar") }, 1},
+		{"ApplyBatch", func() error { return client.MultiPut(map[string]string{"foo": "bar"}) }, 1},
+		{"Increment", func() error { _, _, err := client.Increment("foo", 1); return err }, 1},
+	}
+	for _, call := range calls {
+		before := accepted.Load()
+		var netErr *kvclient.NetworkError
+		if err := call.call(); !errors.As(err, &netErr) || !netErr.Sent {
+			t.Fatalf("[ERROR] %s returned error %v, expected a sent NetworkError", call.method, err)
+		}
+		if attempts := accepted.Load() - before; attempts != call.attempts {
+			t.Fatalf("[ERROR] %s made %d attempts, expected %d", call.method, attempts, call.attempts)
+		}
+	}
+}
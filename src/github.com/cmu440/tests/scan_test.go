@@ -0,0 +1,138 @@
+// Key-value store tests for range scans.
+
+package tests
+
+import (
+	"fmt"
+	"math/rand"
+	"sort"
+	"testing"
+	"time"
+
+	"github.com/cmu440/actor"
+	"github.com/cmu440/kvserver"
+)
+
+// Checks that iterating over [start, end) in pages of pageSize gives
+// exactly the keys in expKeys, in order, with value "value-" + key.
+func checkIterate(t *testing.T, client clientWr, start string, end string, pageSize int, expKeys []string) {
+	it := client.c.Iterate(start, end, pageSize)
+	i := 0
+	for it.Next() {
+		if i >= len(expKeys) || it.Key() != expKeys[i] || it.Value() != "value-"+expKeys[i] {
+			t.Fatalf("[ERROR] (%s) Iterate entry %d is (%q, %q), expected key %q of %v", client.name, i, it.Key(),
+				it.Value(), expKeys[min(i, len(expKeys)-1)], expKeys)
+		}
+		i++
+	}
+	if err := it.Err(); err != nil {
+		t.Fatalf("[ERROR] (%s) Iterate failed: %s", client.name, err)
+	}
+	if i != len(expKeys) {
+		t.Fatalf("[ERROR] (%s) Iterate gave %d entries, expected %d", client.name, i, len(expKeys))
+	}
+}
+
+func TestScanPages(t *testing.T) {
+	fmt.Printf("=== %s: %s\n", t.Name(), "Scan returns sorted pages with a continuation key")
+
+	clients, server := setupTestLocalSync(t, 2)
+	defer teardownTestLocalSync(clients, server)
+
+	keys := []string{}
+	for i := 24; i >= 0; i-- {
+		put(t, false, clients[0], fmt.Sprintf("key/%02d", i), fmt.Sprintf("value-key/%02d", i))
+	}
+	for i := 0; i < 25; i++ {
+		if i != 7 {
+			keys = append(keys, fmt.Sprintf("key/%02d", i))
+		}
+	}
+	put(t, false, clients[0], "key0", "value-key0")
+	put(t, false, clients[0], "kex", "value-kex")
+	del(t, false, clients[0], "key/07", true)
+	waitForSync(t, localSyncDeadline)
+
+	entries, next, more, err := clients[1].c.Scan("key/", "key0", 10)
+	if err != nil || len(entries) != 10 || !more || next != "key/11" {
+		t.Fatalf("[ERROR] Scan gave %d entries, next %q, more %t, error %v; expected 10, \"key/11\", true, nil",
+			len(entries), next, more, err)
+	}
+	for i, entry := range entries {
+		if entry.Key != keys[i] {
+			t.Fatalf("[ERROR] Scan entry %d has key %q, expected %q", i, entry.Key, keys[i])
+		}
+	}
+	entries, _, more, err = clients[1].c.Scan("key/20", "key0", 10)
+	if err != nil || len(entries) != 5 || more {
+		t.Fatalf("[ERROR] Last Scan page gave %d entries, more %t, error %v; expected 5, false, nil", len(entries),
+			more, err)
+	}
+
+	for _, client := range clients {
+		checkIterate(t, client, "key/", "key0", 4, keys)
+		checkIterate(t, client, "key/", "", 100, append(keys, "key0"))
+		checkIterate(t, client, "key/05", "key/10", 1, keys[5:9])
+	}
+}
+
+func TestScanManyKeys(t *testing.T) {
+	fmt.Printf("=== %s: %s\n", t.Name(), "Keys written and collected in random order scan in order")
+
+	config := kvserver.DefaultConfig()
+	config.TombstoneGrace = time.Millisecond
+	port := newPort()
+	s, desc, err := kvserver.NewServerWithConfig(port, 1, []string{}, config)
+	if err != nil {
+		t.Fatalf("Failed to start server on ports %d-%d: %s", port, port+1, err)
+	}
+	server := serverWr{s, desc, actor.LastActorSystem()}
+	clients := []clientWr{newClient(fmt.Sprintf("localhost:%d", port+1), "actor 0")}
+	defer teardownTestLocalSync(clients, server)
+
+	const count = 600
+	present := make(map[int]bool)
+	for _, i := range rand.Perm(count) {
+		put(t, false, clients[0], fmt.Sprintf("key/%03d", i), fmt.Sprintf("value-key/%03d", i))
+		present[i] = true
+	}
+	// Delete a third, let their tombstones be collected, then write some of them again.
+	for _, i := range rand.Perm(count)[:count/3] {
+		del(t, false, clients[0], fmt.Sprintf("key/%03d", i), true)
+		delete(present, i)
+	}
+	waitForSync(t, localSyncDeadline)
+	for _, i := range rand.Perm(count)[:count/6] {
+		put(t, false, clients[0], fmt.Sprintf("key/%03d", i), fmt.Sprintf("value-key/%03d", i))
+		present[i] = true
+	}
+
+	keys := []string{}
+	for i := 0; i < count; i++ {
+		if present[i] {
+			keys = append(keys, fmt.Sprintf("key/%03d", i))
+		}
+	}
+	checkIterate(t, clients[0], "key/", "", 64, keys)
+	from, to := sort.SearchStrings(keys, "key/100"), sort.SearchStrings(keys, "key/200")
+	checkIterate(t, clients[0], "key/100", "key/200", 7, keys[from:to])
+}
+
+func TestScanPartitioned(t *testing.T) {
+	fmt.Printf("=== %s: %s\n", t.Name(), "3 servers, 1 replica: Scan gathers sorted pages from all query actors")
+
+	clients, servers := setupTestPartitioned(t, 3, 1, 1)
+	defer teardownTestRemoteSync(clients, servers)
+
+	keys := []string{}
+	for i := 0; i < 30; i++ {
+		key := fmt.Sprintf("key/%02d", i)
+		put(t, false, clients[i%len(clients)], key, "value-"+key)
+		keys = append(keys, key)
+	}
+	waitForSync(t, remoteSyncDeadline)
+
+	for _, client := range clients {
+		checkIterate(t, client, "key/", "", 7, keys)
+	}
+}
@@ -0,0 +1,145 @@
+// Key-value store tests for client sessions.
+
+package tests
+
+import (
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/cmu440/actor"
+	"github.com/cmu440/kvclient"
+	"github.com/cmu440/kvserver"
+	"github.com/cmu440/staff"
+)
+
+// Starts serverCount servers with one query actor each, and returns a
+// client per server and a client whose router alternates between them.
+func setupTestSession(t *testing.T, serverCount int) ([]clientWr, clientWr, []serverWr) {
+	staff.SetArtiLatencyMs(remoteServerLatencyMs)
+	servers := make([]serverWr, serverCount)
+	clients := make([]clientWr, serverCount)
+	descs := []string{}
+	router := &dynamicAddressRouter{}
+	for i := 0; i < serverCount; i++ {
+		port := newPort()
+		servers[i] = newServer(t, port, 1, descs)
+		descs = append(descs, servers[i].desc)
+		address := fmt.Sprintf("localhost:%d", port+1)
+		clients[i] = newClient(address, fmt.Sprintf("server %d", i))
+		router.addresses = append(router.addresses, address)
+	}
+	time.Sleep(time.Duration(4*remoteServerLatencyMs) * time.Millisecond)
+	return clients, clientWr{kvclient.NewClient(router), "all servers"}, servers
+}
+
+func sessionGet(t *testing.T, session *kvclient.Session, key string, expValue string, expOk bool) {
+	value, ok, err := session.Get(key)
+	if err != nil {
+		t.Fatalf("[ERROR] Session Get(%q) returned error: %s", key, err)
+	}
+	if value != expValue || ok != expOk {
+		t.Fatalf("[ERROR] Session Get(%q) gave (%q, %t), but expected (%q, %t)", key, value, ok, expValue, expOk)
+	}
+}
+
+func TestSessionReadYourWrites(t *testing.T) {
+	fmt.Printf("=== %s: %s\n", t.Name(), "A session reads its writes on the other server right away")
+
+	clients, client, servers := setupTestSession(t, 2)
+	defer teardownTestRemoteSync(append(clients, client), servers)
+
+	session := client.c.NewSession()
+	for i := 0; i < 4; i++ {
+		value := fmt.Sprintf("value%d", i)
+		if err := session.Put("loc/alice", value); err != nil {
+			t.Fatalf("[ERROR] Session Put failed: %s", err)
+		}
+		sessionGet(t, session, "loc/alice", value, true)
+	}
+}
+
+func TestSessionMonotonicReads(t *testing.T) {
+	fmt.Printf("=== %s: %s\n", t.Name(), "A session never reads an older value from a lagging server")
+
+	clients, client, servers := setupTestSession(t, 2)
+	defer teardownTestRemoteSync(append(clients, client), servers)
+
+	session := client.c.NewSession()
+	put(t, true, clients[0], "loc/alice", "fence")
+	waitForSync(t, remoteSyncDeadline)
+	for i := 0; i < 4; i++ {
+		value := fmt.Sprintf("value%d", i)
+		// The session reads from server 0 first, then from server 1, which
+		// has not heard of the write yet.
+		put(t, true, clients[0], "loc/alice", value)
+		sessionGet(t, session, "loc/alice", value, true)
+		sessionGet(t, session, "loc/alice", value, true)
+	}
+
+	// The session's write supersedes what it read.
+	if err := session.Put("loc/alice", "bridge"); err != nil {
+		t.Fatalf("[ERROR] Session Put failed: %s", err)
+	}
+	waitForSync(t, remoteSyncDeadline)
+	for _, client := range clients {
+		get(t, true, client, "loc/alice", "bridge", true)
+	}
+}
+
+func TestSessionRetriesLaggingReplica(t *testing.T) {
+	fmt.Printf("=== %s: %s\n", t.Name(), "A session retries on another replica if one never catches up")
+
+	config := kvserver.DefaultConfig()
+	config.AntiEntropyInterval = 0
+	port := newPort()
+	s, desc, err := kvserver.NewServerWithConfig(port, 2, []string{}, config)
+	if err != nil {
+		t.Fatalf("Failed to start server on ports %d-%d: %s", port, port+2, err)
+	}
+	server := serverWr{s, desc, actor.LastActorSystem()}
+	dropSyncTo(server, 1)
+	router := &dynamicAddressRouter{addresses: []string{
+		fmt.Sprintf("localhost:%d", port+1), fmt.Sprintf("localhost:%d", port+2),
+	}}
+	clients := []clientWr{newClient(fmt.Sprintf("localhost:%d", port+2), "actor 1"), {kvclient.NewClient(router), "both actors"}}
+	defer teardownTestLocalSync(clients, server)
+
+	session := clients[1].c.NewSession()
+	if err := session.Put("loc/alice", "fence"); err != nil {
+		t.Fatalf("[ERROR] Session Put failed: %s", err)
+	}
+	sessionGet(t, session, "loc/alice", "fence", true)
+	get(t, true, clients[0], "loc/alice", "", false)
+}
+
+func TestSessionReadAfterCollectedDelete(t *testing.T) {
+	fmt.Printf("=== %s: %s\n", t.Name(), "A session reads a key at once after its tombstone was collected")
+
+	config := kvserver.DefaultConfig()
+	config.TombstoneGrace = 100 * time.Millisecond
+	config.CatchUpTimeout = 10 * time.Second
+	port := newPort()
+	s, desc, err := kvserver.NewServerWithConfig(port, 1, []string{}, config)
+	if err != nil {
+		t.Fatalf("Failed to start server on ports %d-%d: %s", port, port+1, err)
+	}
+	server := serverWr{s, desc, actor.LastActorSystem()}
+	clients := []clientWr{newClient(fmt.Sprintf("localhost:%d", port+1), "actor 0")}
+	defer teardownTestLocalSync(clients, server)
+
+	session := clients[0].c.NewSession()
+	if err := session.Put("loc/alice", "fence"); err != nil {
+		t.Fatalf("[ERROR] Session Put failed: %s", err)
+	}
+	del(t, true, clients[0], "loc/alice", true)
+	// The session now holds the delete's token.
+	sessionGet(t, session, "loc/alice", "", false)
+
+	waitForSync(t, config.TombstoneGrace+localSyncDeadline)
+	start := time.Now()
+	sessionGet(t, session, "loc/alice", "", false)
+	if elapsed := time.Since(start); elapsed > localSyncDeadline {
+		t.Fatalf("[ERROR] Session Get took %s, expected it not to wait for the collected delete", elapsed)
+	}
+}
@@ -0,0 +1,100 @@
+// Key-value store tests for durable storage.
+
+package tests
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/cmu440/actor"
+	"github.com/cmu440/kvserver"
+)
+
+// Starts a server with queryActorCount query actors keeping their state in
+// dataDir, and returns a client per actor.
+func setupTestStorage(t *testing.T, queryActorCount int, dataDir string, fsync kvserver.FsyncPolicy, snapshotEvery int) ([]clientWr, serverWr) {
+	config := kvserver.DefaultConfig()
+	config.DataDir = dataDir
+	config.Fsync = fsync
+	config.SnapshotEvery = snapshotEvery
+
+	port := newPort()
+	server, desc, err := kvserver.NewServerWithConfig(port, queryActorCount, []string{}, config)
+	if err != nil {
+		t.Fatalf("Failed to start server on ports %d-%d: %s", port, port+queryActorCount, err)
+	}
+	clients := make([]clientWr, queryActorCount)
+	for i := 0; i < queryActorCount; i++ {
+		clients[i] = newClient(fmt.Sprintf("localhost:%d", port+1+i), fmt.Sprintf("actor %d", i))
+	}
+	return clients, serverWr{server, desc, actor.LastActorSystem()}
+}
+
+func TestStorageRecoversAfterRestart(t *testing.T) {
+	fmt.Printf("=== %s: %s\n", t.Name(), "A server restarted on the same data directory recovers its state")
+
+	dataDir := t.TempDir()
+	clients, server := setupTestStorage(t, 2, dataDir, kvserver.FsyncInterval, 10000)
+	put(t, true, clients[0], "loc/alice", "fence")
+	put(t, true, clients[0], "loc/bob", "bridge")
+	del(t, true, clients[0], "loc/bob", true)
+	clients[1].c.Increment("balance/alice", 7)
+	clients[0].c.SetAdd("party/alice", "bob")
+	waitForSync(t, localSyncDeadline)
+	teardownTestLocalSync(clients, server)
+
+	clients, server = setupTestStorage(t, 2, dataDir, kvserver.FsyncInterval, 10000)
+	defer teardownTestLocalSync(clients, server)
+	for _, client := range clients {
+		get(t, true, client, "loc/alice", "fence", true)
+		get(t, true, client, "loc/bob", "", false)
+		get(t, true, client, "balance/alice", "7", true)
+		get(t, true, client, "party/alice", `["bob"]`, true)
+	}
+
+	// New writes win over recovered ones.
+	put(t, true, clients[1], "loc/alice", "gate")
+	clients[0].c.Increment("balance/alice", 1)
+	waitForSync(t, localSyncDeadline)
+	for _, client := range clients {
+		get(t, true, client, "loc/alice", "gate", true)
+		get(t, true, client, "balance/alice", "8", true)
+	}
+}
+
+func TestStorageSnapshotAndTornLog(t *testing.T) {
+	fmt.Printf("=== %s: %s\n", t.Name(), "Recovery reads the snapshot and log, dropping a torn last record")
+
+	dataDir := t.TempDir()
+	clients, server := setupTestStorage(t, 1, dataDir, kvserver.FsyncAlways, 5)
+	for i := 0; i < 12; i++ {
+		put(t, true, clients[0], fmt.Sprintf("key%d", i), fmt.Sprintf("value%d", i))
+	}
+	teardownTestLocalSync(clients, server)
+
+	if _, err := os.Stat(filepath.Join(dataDir, "actor0", "snapshot")); err != nil {
+		t.Errorf("[ERROR] No snapshot written: %s", err)
+	}
+	// A record cut short by a crash.
+	wal, err := os.OpenFile(filepath.Join(dataDir, "actor0", "wal"), os.O_APPEND|os.O_WRONLY, 0644)
+	if err != nil {
+		t.Fatalf("[ERROR] Failed to open log: %s", err)
+	}
+	wal.Write([]byte{200, 0, 0, 0, 1, 2, 3, 4, 5})
+	wal.Close()
+
+	clients, server = setupTestStorage(t, 1, dataDir, kvserver.FsyncAlways, 5)
+	for i := 0; i < 12; i++ {
+		get(t, true, clients[0], fmt.Sprintf("key%d", i), fmt.Sprintf("value%d", i), true)
+	}
+	put(t, true, clients[0], "key12", "value12")
+	get(t, true, clients[0], "key12", "value12", true)
+	teardownTestLocalSync(clients, server)
+
+	clients, server = setupTestStorage(t, 1, dataDir, kvserver.FsyncAlways, 5)
+	defer teardownTestLocalSync(clients, server)
+	get(t, true, clients[0], "key12", "value12", true)
+	get(t, true, clients[0], "key0", "value0", true)
+}
@@ -0,0 +1,76 @@
+// Tests for the actor/testkit package.
+
+package tests
+
+import (
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/cmu440/actor/testkit"
+)
+
+func TestTestkitProbe(t *testing.T) {
+	fmt.Printf("=== %s: %s\n", t.Name(), "TestProbe records remote messages in order")
+
+	systems := testkit.NewSystems(t, 2)
+	probe := testkit.NewTestProbe(t, systems[1])
+
+	senderRef := systems[0].StartActor(newSendActor)
+	systems[0].Tell(senderRef, SendActorCmd{Target: probe.Ref(), Count: 3})
+
+	probe.ExpectMsg(1)
+	if m := probe.ExpectMsgType(0).(int); m != 2 {
+		t.Errorf("ExpectMsgType returned %d, expected 2", m)
+	}
+	probe.ExpectMsg(3)
+	probe.ExpectNoMsg(100 * time.Millisecond)
+}
+
+func TestTestkitFish(t *testing.T) {
+	fmt.Printf("=== %s: %s\n", t.Name(), "FishForMessage skips messages until one matches")
+
+	systems := testkit.NewSystems(t, 1)
+	probe := testkit.NewTestProbe(t, systems[0])
+
+	senderRef := systems[0].StartActor(newSendActor)
+	systems[0].Tell(senderRef, SendActorCmd{Target: probe.Ref(), Count: 5})
+
+	m := probe.FishForMessage(func(message any) bool {
+		return message.(int) >= 4
+	})
+	if m != 4 {
+		t.Errorf("FishForMessage returned %v, expected 4", m)
+	}
+	probe.ExpectMsg(5)
+}
+
+func TestTestkitActorRef(t *testing.T) {
+	fmt.Printf("=== %s: %s\n", t.Name(), "TestActorRef handles messages synchronously")
+
+	system := testkit.NewSystem(t)
+	probe := testkit.NewTestProbe(t, system)
+	ref := testkit.NewTestActorRef(t, system, newRecordActor)
+
+	ref.Receive(RecordName{"inline"})
+	if names := ref.Actor().(*recordActor).names; len(names) != 1 || names[0] != "inline" {
+		t.Fatalf("After Receive, actor recorded %v", names)
+	}
+
+	system.Tell(ref.Ref(), RecordName{"told"})
+	// Give the Tell time to arrive; it must wait for ProcessPending.
+	time.Sleep(20 * time.Millisecond)
+	if names := ref.Actor().(*recordActor).names; len(names) != 1 {
+		t.Fatalf("Told message was handled without ProcessPending: %v", names)
+	}
+	if n := ref.ProcessPending(); n != 1 {
+		t.Fatalf("ProcessPending handled %d messages, expected 1", n)
+	}
+
+	ref.Receive(RecordDump{probe.Ref()})
+	probe.ExpectMsg([]string{"inline", "told"})
+
+	if err := ref.ReceiveErr(make(chan int)); err == nil {
+		t.Errorf("ReceiveErr of a non-marshallable message returned nil error")
+	}
+}
@@ -0,0 +1,175 @@
+// Tests for message tracing across local and remote Tells.
+
+package tests
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/cmu440/actor"
+)
+
+const traceDeadline = time.Duration(2) * time.Second
+
+// actor.TraceExporter that keeps spans in memory.
+type memoryExporter struct {
+	mux   sync.Mutex
+	spans []actor.Span
+}
+
+func (exporter *memoryExporter) ExportSpan(span actor.Span) {
+	exporter.mux.Lock()
+	exporter.spans = append(exporter.spans, span)
+	exporter.mux.Unlock()
+}
+
+// Waits until a span with the given message type is exported, or fails the
+// test after traceDeadline.
+func (exporter *memoryExporter) waitForSpan(t *testing.T, messageType string) actor.Span {
+	deadline := time.Now().Add(traceDeadline)
+	for time.Now().Before(deadline) {
+		exporter.mux.Lock()
+		for _, span := range exporter.spans {
+			if span.MessageType == messageType {
+				exporter.mux.Unlock()
+				return span
+			}
+		}
+		exporter.mux.Unlock()
+		time.Sleep(10 * time.Millisecond)
+	}
+	t.Fatalf("No span for message type %s exported within %s", messageType, traceDeadline)
+	return actor.Span{}
+}
+
+// Sends SendActorCmd to a sender actor on systems[0], which sends one
+// message to a receiver actor on systems[1], and waits for the receiver's report.
+func runTraceChain(t *testing.T, systems []*actor.ActorSystem) *actor.TraceContext {
+	reportRef, reportCh := systems[1].NewChannelRef()
+	receiverRef := systems[1].StartActor(newReceiveActor)
+	systems[1].Tell(receiverRef, ReceiveActorInit{Count: 1, ReportRef: reportRef})
+
+	senderRef := systems[0].StartActor(newSendActor)
+	root := systems[0].TellTraced(senderRef, SendActorCmd{Target: receiverRef, Count: 1})
+	if root == nil {
+		t.Fatal("TellTraced returned nil trace context despite an exporter being set")
+	}
+
+	select {
+	case report := <-reportCh:
+		if errSt, ok := report.(string); ok {
+			t.Fatal(errSt)
+		}
+	case <-time.After(traceDeadline):
+		t.Fatalf("Did not receive report within %s", traceDeadline)
+	}
+	return root
+}
+
+func TestTracePropagation(t *testing.T) {
+	fmt.Printf("=== %s: %s\n", t.Name(), "Trace context propagates from a handled message to the Tells it issues, across systems")
+
+	systems := setupTestRemoteTell(t)
+	defer teardownTestRemoteTell(systems)
+
+	exporters := []*memoryExporter{{}, {}}
+	for i, system := range systems {
+		system.SetTraceExporter(exporters[i])
+	}
+
+	root := runTraceChain(t, systems)
+
+	sendSpan := exporters[0].waitForSpan(t, "tests.SendActorCmd")
+	if sendSpan.TraceID != root.TraceID || sendSpan.SpanID != root.SpanID || sendSpan.ParentID != "" {
+		t.Errorf("Root span %+v does not match trace context %+v", sendSpan, *root)
+	}
+
+	receiveSpan := exporters[1].waitForSpan(t, "int")
+	if receiveSpan.TraceID != root.TraceID {
+		t.Errorf("Remote span has TraceID %q, expected %q", receiveSpan.TraceID, root.TraceID)
+	}
+	if receiveSpan.ParentID != root.SpanID {
+		t.Errorf("Remote span has ParentID %q, expected %q", receiveSpan.ParentID, root.SpanID)
+	}
+	if receiveSpan.SpanID == root.SpanID {
+		t.Errorf("Remote span reuses its parent's SpanID %q", root.SpanID)
+	}
+}
+
+func TestTraceUntraced(t *testing.T) {
+	fmt.Printf("=== %s: %s\n", t.Name(), "Plain Tell and TellTraced without an exporter produce no spans")
+
+	systems := setupTestRemoteTell(t)
+	defer teardownTestRemoteTell(systems)
+
+	if trace := systems[0].TellTraced(systems[0].StartActor(newReceiveActor), ReceiveActorInit{}); trace != nil {
+		t.Errorf("TellTraced without an exporter returned %+v, expected nil", *trace)
+	}
+
+	exporter := &memoryExporter{}
+	systems[1].SetTraceExporter(exporter)
+	reportRef, reportCh := systems[1].NewChannelRef()
+	receiverRef := systems[1].StartActor(newReceiveActor)
+	systems[1].Tell(receiverRef, ReceiveActorInit{Count: 1, ReportRef: reportRef})
+	systems[1].Tell(receiverRef, 1)
+	<-reportCh
+
+	// Give the receiver time to finish handling.
+	time.Sleep(50 * time.Millisecond)
+	exporter.mux.Lock()
+	defer exporter.mux.Unlock()
+	if len(exporter.spans) != 0 {
+		t.Errorf("Untraced messages exported %d spans, e.g. %+v", len(exporter.spans), exporter.spans[0])
+	}
+}
+
+func TestTraceJSONLinesExporter(t *testing.T) {
+	fmt.Printf("=== %s: %s\n", t.Name(), "JSONLinesExporter writes one decodable span per line")
+
+	systems := setupTestRemoteTell(t)
+	defer teardownTestRemoteTell(systems)
+
+	path := filepath.Join(t.TempDir(), "spans.jsonl")
+	exporter, err := actor.NewJSONLinesExporter(path)
+	if err != nil {
+		t.Fatalf("NewJSONLinesExporter: %s", err)
+	}
+	watcher := &memoryExporter{}
+	systems[0].SetTraceExporter(exporter)
+	systems[1].SetTraceExporter(watcher)
+
+	root := runTraceChain(t, systems)
+	// The remote span is exported after the local one finished.
+	watcher.waitForSpan(t, "int")
+	systems[0].SetTraceExporter(nil)
+	if err := exporter.Close(); err != nil {
+		t.Fatalf("Close: %s", err)
+	}
+
+	file, err := os.Open(path)
+	if err != nil {
+		t.Fatalf("Open: %s", err)
+	}
+	defer file.Close()
+	scanner := bufio.NewScanner(file)
+	lines := 0
+	for scanner.Scan() {
+		var span actor.Span
+		if err := json.Unmarshal(scanner.Bytes(), &span); err != nil {
+			t.Fatalf("Line %d is not a span: %s", lines+1, err)
+		}
+		if span.TraceID != root.TraceID {
+			t.Errorf("Line %d has TraceID %q, expected %q", lines+1, span.TraceID, root.TraceID)
+		}
+		lines++
+	}
+	if lines != 1 {
+		t.Errorf("Expected 1 exported span, got %d", lines)
+	}
+}
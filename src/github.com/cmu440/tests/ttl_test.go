@@ -0,0 +1,91 @@
+// Key-value store tests for key TTLs.
+
+package tests
+
+import (
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/cmu440/kvclient"
+)
+
+// TTL of the values written by the tests.
+const testTTL = time.Duration(300) * time.Millisecond
+
+// Writes key with value on client, to expire after ttl.
+func putWithTTL(t *testing.T, client clientWr, key string, value string, ttl time.Duration) {
+	if err := client.c.PutWithTTL(key, value, ttl); err != nil {
+		t.Fatalf("[ERROR] (%s) PutWithTTL(%q, %q, %s) returned error: %s", client.name, key, value, ttl, err)
+	}
+}
+
+func TestTTLExpires(t *testing.T) {
+	fmt.Printf("=== %s: %s\n", t.Name(), "Values expire on every replica after their TTL, unless written again")
+
+	clients, server := setupTestLocalSync(t, 2)
+	defer teardownTestLocalSync(clients, server)
+
+	// Long enough to sync before expiring.
+	ttl := 2 * localSyncDeadline
+	putWithTTL(t, clients[0], "loc/alice", "fence", ttl)
+	putWithTTL(t, clients[0], "loc/bob", "bridge", ttl)
+	put(t, false, clients[1], "loc/carol", "river")
+	waitForSync(t, localSyncDeadline)
+	put(t, false, clients[1], "loc/bob", "river")
+	for _, client := range clients {
+		get(t, false, client, "loc/alice", "fence", true)
+	}
+
+	time.Sleep(ttl - localSyncDeadline)
+	waitForSync(t, localSyncDeadline)
+	for _, client := range clients {
+		get(t, false, client, "loc/alice", "", false)
+		list(t, false, client, "loc/", map[string]string{"loc/bob": "river", "loc/carol": "river"})
+	}
+
+	// An expired key can be written again.
+	put(t, false, clients[0], "loc/alice", "bridge")
+	waitForSync(t, localSyncDeadline)
+	get(t, false, clients[1], "loc/alice", "bridge", true)
+}
+
+func TestTTLNotResurrected(t *testing.T) {
+	fmt.Printf("=== %s: %s\n", t.Name(), "A replica that gets a value only after it expired does not revive it")
+
+	clients, server := setupTestAntiEntropy(t, 2, antiEntropyInterval)
+	defer teardownTestLocalSync(clients, server)
+
+	dropSyncTo(server, 1)
+	putWithTTL(t, clients[0], "loc/alice", "fence", testTTL)
+	put(t, false, clients[0], "loc/bob", "bridge")
+	waitForSync(t, testTTL+localSyncDeadline)
+	get(t, false, clients[0], "loc/alice", "", false)
+	get(t, false, clients[1], "loc/bob", "", false)
+
+	server.system.SetDropFilter(nil)
+	waitForSync(t, 3*antiEntropyInterval+localSyncDeadline)
+	for _, client := range clients {
+		list(t, false, client, "loc/", map[string]string{"loc/bob": "bridge"})
+	}
+}
+
+func TestTTLPeriodicExpiry(t *testing.T) {
+	fmt.Printf("=== %s: %s\n", t.Name(), "Values expire without being read, and watches see them deleted")
+
+	clients, server := setupTestLocalSync(t, 1)
+	defer teardownTestLocalSync(clients, server)
+
+	w := clients[0].c.Watch("loc/", kvclient.Version{})
+	defer w.Close()
+	// Let the watch reach the actor before the writes.
+	time.Sleep(100 * time.Millisecond)
+
+	putWithTTL(t, clients[0], "loc/alice", "fence", testTTL)
+	expectEvent(t, w, "loc/alice", "fence", false)
+	start := time.Now()
+	expectEvent(t, w, "loc/alice", "", true)
+	if elapsed := time.Since(start); elapsed > testTTL+localSyncDeadline/2 {
+		t.Fatalf("[ERROR] Value expired after %s, expected about %s", elapsed, testTTL)
+	}
+}
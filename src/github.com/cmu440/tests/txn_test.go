@@ -0,0 +1,313 @@
+// Key-value store tests for transactions.
+
+package tests
+
+import (
+	"fmt"
+	"math/rand"
+	"strconv"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/cmu440/actor"
+	"github.com/cmu440/kvclient"
+	"github.com/cmu440/kvserver"
+)
+
+// Moves amount coins from key from to key to in a transaction on client,
+// retrying until it commits.
+func transfer(client clientWr, from string, to string, amount int) error {
+	for attempt := 0; attempt < 100; attempt++ {
+		tx := client.c.Begin()
+		balances := []int{}
+		for _, key := range []string{from, to} {
+			value, _, err := tx.Get(key)
+			if err != nil {
+				return err
+			}
+			balance, _ := strconv.Atoi(value)
+			balances = append(balances, balance)
+		}
+		tx.Put(from, strconv.Itoa(balances[0]-amount))
+		tx.Put(to, strconv.Itoa(balances[1]+amount))
+		if ok, err := tx.Commit(); ok || err != nil {
+			return err
+		}
+		time.Sleep(time.Duration(rand.Intn(20)) * time.Millisecond)
+	}
+	return fmt.Errorf("(%s) transfer from %s to %s aborted 100 times", client.name, from, to)
+}
+
+// Checks the balances at keys in a transaction on client.
+func checkBalances(t *testing.T, client clientWr, keys []string, expBalances []int) {
+	tx := client.c.Begin()
+	for i, key := range keys {
+		value, ok, err := tx.Get(key)
+		if err != nil || !ok || value != strconv.Itoa(expBalances[i]) {
+			t.Fatalf("[ERROR] (%s) Get(%q) in transaction gave (%q, %t, %v), expected %d", client.name, key, value, ok,
+				err, expBalances[i])
+		}
+	}
+	if ok, err := tx.Commit(); !ok || err != nil {
+		t.Fatalf("[ERROR] (%s) read-only transaction gave (%t, %v), expected (true, nil)", client.name, ok, err)
+	}
+}
+
+func TestTxnConflictAborts(t *testing.T) {
+	fmt.Printf("=== %s: %s\n", t.Name(), "A transaction aborts if a key it read was committed since")
+
+	clients, server := setupTestLocalSync(t, 2)
+	defer teardownTestLocalSync(clients, server)
+
+	put(t, false, clients[0], "coins/alice", "10")
+	waitForSync(t, localSyncDeadline)
+
+	tx1 := clients[0].c.Begin()
+	tx2 := clients[1].c.Begin()
+	for _, tx := range []*kvclient.Txn{tx1, tx2} {
+		if value, ok, err := tx.Get("coins/alice"); value != "10" || !ok || err != nil {
+			t.Fatalf("[ERROR] Get in transaction gave (%q, %t, %v), expected (\"10\", true, nil)", value, ok, err)
+		}
+	}
+	tx2.Put("coins/alice", "5")
+	tx2.Delete("coins/bob")
+	if value, ok, _ := tx2.Get("coins/alice"); value != "5" || !ok {
+		t.Fatalf("[ERROR] Get in transaction gave (%q, %t), expected its own write (\"5\", true)", value, ok)
+	}
+	if ok, err := tx2.Commit(); !ok || err != nil {
+		t.Fatalf("[ERROR] First Commit gave (%t, %v), expected (true, nil)", ok, err)
+	}
+	tx1.Put("coins/alice", "7")
+	if ok, err := tx1.Commit(); ok || err != nil {
+		t.Fatalf("[ERROR] Conflicting Commit gave (%t, %v), expected (false, nil)", ok, err)
+	}
+	checkBalances(t, clients[0], []string{"coins/alice"}, []int{5})
+	waitForSync(t, localSyncDeadline)
+	get(t, false, clients[1], "coins/alice", "5", true)
+}
+
+func TestTxnConcurrentTransfers(t *testing.T) {
+	fmt.Printf("=== %s: %s\n", t.Name(), "4 actors: concurrent transfers keep the total")
+
+	clients, server := setupTestLocalSync(t, 4)
+	defer teardownTestLocalSync(clients, server)
+
+	keys := []string{"coins/alice", "coins/bob", "coins/carol"}
+	tx := clients[0].c.Begin()
+	for _, key := range keys {
+		tx.Put(key, "100")
+	}
+	if ok, err := tx.Commit(); !ok || err != nil {
+		t.Fatalf("[ERROR] Commit gave (%t, %v), expected (true, nil)", ok, err)
+	}
+
+	// Each client moves a coin around the circle of keys, four times; the
+	// transfers contend for the same keys.
+	var wg sync.WaitGroup
+	errs := make(chan error, len(clients))
+	for i, client := range clients {
+		wg.Add(1)
+		go func(i int, client clientWr) {
+			defer wg.Done()
+			for j := 0; j < 4*len(keys); j++ {
+				from, to := keys[(i+j)%len(keys)], keys[(i+j+1)%len(keys)]
+				if err := transfer(client, from, to, 1); err != nil {
+					errs <- err
+					return
+				}
+			}
+		}(i, client)
+	}
+	wg.Wait()
+	close(errs)
+	for err := range errs {
+		t.Fatalf("[ERROR] %s", err)
+	}
+	for _, client := range clients {
+		checkBalances(t, client, keys, []int{100, 100, 100})
+	}
+}
+
+func TestTxnPartitioned(t *testing.T) {
+	fmt.Printf("=== %s: %s\n", t.Name(), "3 servers, 1 replica: transactions commit across owners")
+
+	clients, servers := setupTestPartitioned(t, 3, 1, 1)
+	defer teardownTestRemoteSync(clients, servers)
+
+	keys := []string{}
+	expBalances := []int{}
+	tx := clients[0].c.Begin()
+	for i := 0; i < 3; i++ {
+		keys = append(keys, fmt.Sprintf("coins/player%d", i))
+		expBalances = append(expBalances, 10)
+		tx.Put(keys[i], "10")
+	}
+	if ok, err := tx.Commit(); !ok || err != nil {
+		t.Fatalf("[ERROR] Commit gave (%t, %v), expected (true, nil)", ok, err)
+	}
+	for i := range keys {
+		client := clients[i%len(clients)]
+		if err := transfer(client, keys[i], keys[(i+1)%len(keys)], i); err != nil {
+			t.Fatalf("[ERROR] %s", err)
+		}
+		expBalances[i] -= i
+		expBalances[(i+1)%len(keys)] += i
+	}
+	for _, client := range clients {
+		checkBalances(t, client, keys, expBalances)
+	}
+}
+
+func TestTxnLostDecision(t *testing.T) {
+	fmt.Printf("=== %s: %s\n", t.Name(), "Lost decisions and acknowledgements are resent, and applied once")
+
+	config := kvserver.DefaultConfig()
+	config.TxnTimeout = 200 * time.Millisecond
+	config.ConflictModes = map[string]kvserver.ConflictMode{"cart/": kvserver.MultiValue}
+	port := newPort()
+	s, desc, err := kvserver.NewServerWithConfig(port, 3, []string{}, config)
+	if err != nil {
+		t.Fatalf("Failed to start server on ports %d-%d: %s", port, port+3, err)
+	}
+	server := serverWr{s, desc, actor.LastActorSystem()}
+	clients := []clientWr{}
+	for i := 0; i < 3; i++ {
+		clients = append(clients, newClient(fmt.Sprintf("localhost:%d", port+1+i), fmt.Sprintf("actor %d", i)))
+	}
+	defer teardownTestLocalSync(clients, server)
+	// The first commit decision and the first acknowledgement get lost.
+	var mux sync.Mutex
+	dropped := map[string]bool{}
+	server.system.SetDropFilter(func(ref *actor.ActorRef, message any) bool {
+		var kind string
+		switch m := message.(type) {
+		case kvserver.MDecide:
+			if !m.Commit {
+				return false
+			}
+			kind = "decision"
+		case kvserver.DecideResult:
+			kind = "acknowledgement"
+		default:
+			return false
+		}
+		mux.Lock()
+		defer mux.Unlock()
+		drop := !dropped[kind]
+		dropped[kind] = true
+		return drop
+	})
+
+	// Keys at every home.
+	keys := []string{}
+	tx := clients[0].c.Begin()
+	for i := 0; i < 12; i++ {
+		key := fmt.Sprintf("cart/%d", i)
+		keys = append(keys, key)
+		tx.Put(key, "fence")
+	}
+	if ok, err := tx.Commit(); !ok || err != nil {
+		t.Fatalf("[ERROR] Commit gave (%t, %v), expected (true, nil)", ok, err)
+	}
+	waitForSync(t, localSyncDeadline)
+	for _, client := range clients {
+		for _, key := range keys {
+			values, _, err := client.c.GetSiblings(key)
+			if err != nil || len(values) != 1 || values[0] != "fence" {
+				t.Fatalf("[ERROR] (%s) GetSiblings(%q) gave (%q, %v), expected [\"fence\"]", client.name, key, values, err)
+			}
+		}
+	}
+}
+
+func TestTxnCompareAndSetContention(t *testing.T) {
+	fmt.Printf("=== %s: %s\n", t.Name(), "Compare-and-set deposits racing with transfers are never lost")
+
+	config := kvserver.DefaultConfig()
+	config.TxnTimeout = 200 * time.Millisecond
+	port := newPort()
+	s, desc, err := kvserver.NewServerWithConfig(port, 1, []string{}, config)
+	if err != nil {
+		t.Fatalf("Failed to start server on ports %d-%d: %s", port, port+1, err)
+	}
+	server := serverWr{s, desc, actor.LastActorSystem()}
+	clients := []clientWr{newClient(fmt.Sprintf("localhost:%d", port+1), "actor 0")}
+	defer teardownTestLocalSync(clients, server)
+
+	keys := []string{"coins/alice", "coins/bob"}
+	put(t, false, clients[0], keys[0], "100")
+	put(t, false, clients[0], keys[1], "100")
+	var deposits atomic.Int32
+	deposit := func() error {
+		value, _, err := clients[0].c.Get(keys[0])
+		if err != nil {
+			return err
+		}
+		balance, _ := strconv.Atoi(value)
+		ok, err := clients[0].c.CompareAndSet(keys[0], value, strconv.Itoa(balance+1))
+		if ok {
+			deposits.Add(1)
+		}
+		return err
+	}
+
+	// The first commit decision gets lost, so the keys stay locked until it is
+	// resent; a deposit in between must not be overwritten by the commit.
+	var dropped atomic.Bool
+	server.system.SetDropFilter(func(ref *actor.ActorRef, message any) bool {
+		m, ok := message.(kvserver.MDecide)
+		return ok && m.Commit && !dropped.Swap(true)
+	})
+	done := make(chan error)
+	go func() { done <- transfer(clients[0], keys[0], keys[1], 1) }()
+	for !dropped.Load() {
+		time.Sleep(time.Millisecond)
+	}
+	if err := deposit(); err != nil {
+		t.Fatalf("[ERROR] Deposit failed: %s", err)
+	}
+	if err := <-done; err != nil {
+		t.Fatalf("[ERROR] %s", err)
+	}
+
+	// Two goroutines move coins between the keys in transactions, while two
+	// others deposit coins into alice's balance.
+	var wg sync.WaitGroup
+	errs := make(chan error, 4)
+	for i := 0; i < 2; i++ {
+		wg.Add(2)
+		go func(i int) {
+			defer wg.Done()
+			for j := 0; j < 20; j++ {
+				if err := transfer(clients[0], keys[i], keys[1-i], 1); err != nil {
+					errs <- err
+					return
+				}
+			}
+		}(i)
+		go func() {
+			defer wg.Done()
+			for j := 0; j < 40; j++ {
+				if err := deposit(); err != nil {
+					errs <- err
+					return
+				}
+			}
+		}()
+	}
+	wg.Wait()
+	close(errs)
+	for err := range errs {
+		t.Fatalf("[ERROR] %s", err)
+	}
+	alice, _, _ := clients[0].c.Get(keys[0])
+	bob, _, _ := clients[0].c.Get(keys[1])
+	a, _ := strconv.Atoi(alice)
+	b, _ := strconv.Atoi(bob)
+	if a+b != 200+int(deposits.Load()) {
+		t.Fatalf("[ERROR] Balances %d and %d total %d, expected %d after %d deposits", a, b, a+b,
+			200+deposits.Load(), deposits.Load())
+	}
+}
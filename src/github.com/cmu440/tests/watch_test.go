@@ -0,0 +1,129 @@
+// Key-value store tests for watches.
+
+package tests
+
+import (
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/cmu440/kvclient"
+)
+
+// Returns the next event of w, failing if there is none within deadline.
+func nextEvent(t *testing.T, w *kvclient.Watcher, deadline time.Duration) kvclient.Event {
+	select {
+	case event, ok := <-w.Events():
+		if !ok {
+			t.Fatalf("[ERROR] Watch stopped: %v", w.Err())
+		}
+		return event
+	case <-time.After(deadline):
+		t.Fatalf("[ERROR] Watch gave no event within %s", deadline)
+	}
+	return kvclient.Event{}
+}
+
+// Checks that the next event of w changes key to value, or deletes key if
+// deleted.
+func expectEvent(t *testing.T, w *kvclient.Watcher, key string, value string, deleted bool) kvclient.Event {
+	event := nextEvent(t, w, localSyncDeadline)
+	if event.Key != key || event.Value != value || event.Deleted != deleted {
+		t.Fatalf("[ERROR] Watch gave event (%q, %q, deleted %t), expected (%q, %q, deleted %t)", event.Key,
+			event.Value, event.Deleted, key, value, deleted)
+	}
+	return event
+}
+
+func TestWatchLocalAndSynced(t *testing.T) {
+	fmt.Printf("=== %s: %s\n", t.Name(), "Watch streams local and synced changes of keys with a prefix")
+
+	clients, server := setupTestLocalSync(t, 2)
+	defer teardownTestLocalSync(clients, server)
+
+	put(t, false, clients[0], "loc/alice", "fence")
+	put(t, false, clients[0], "loc/gone", "fence")
+	del(t, false, clients[0], "loc/gone", true)
+	waitForSync(t, localSyncDeadline)
+
+	w := clients[1].c.Watch("loc/", kvclient.Version{})
+	defer w.Close()
+	expectEvent(t, w, "loc/alice", "fence", false)
+
+	put(t, false, clients[0], "loc/bob", "bridge")
+	synced := expectEvent(t, w, "loc/bob", "bridge", false)
+	put(t, false, clients[1], "balance/bob", "5")
+	put(t, false, clients[1], "loc/carol", "river")
+	local := expectEvent(t, w, "loc/carol", "river", false)
+	if synced.Version.Origin == local.Version.Origin {
+		t.Fatalf("[ERROR] Synced and local events have the same origin %q", local.Version.Origin)
+	}
+	del(t, false, clients[1], "loc/alice", true)
+	expectEvent(t, w, "loc/alice", "", true)
+
+	w.Close()
+	for range w.Events() {
+	}
+	if err := w.Err(); err != nil {
+		t.Fatalf("[ERROR] Closed watch has error %s", err)
+	}
+}
+
+func TestWatchResume(t *testing.T) {
+	fmt.Printf("=== %s: %s\n", t.Name(), "A watch resumes on another actor from the last version seen")
+
+	clients, server := setupTestLocalSync(t, 2)
+	defer teardownTestLocalSync(clients, server)
+
+	w := clients[0].c.Watch("loc/", kvclient.Version{})
+	put(t, false, clients[0], "loc/alice", "fence")
+	seen := expectEvent(t, w, "loc/alice", "fence", false)
+	w.Close()
+
+	put(t, false, clients[0], "loc/bob", "bridge")
+	put(t, false, clients[0], "loc/alice", "river")
+	waitForSync(t, localSyncDeadline)
+
+	w = clients[1].c.Watch("loc/", seen.Version)
+	defer w.Close()
+	expectEvent(t, w, "loc/bob", "bridge", false)
+	expectEvent(t, w, "loc/alice", "river", false)
+}
+
+func TestWatchSlowConsumer(t *testing.T) {
+	fmt.Printf("=== %s: %s\n", t.Name(), "A slow watcher gets coalesced changes, ending with the latest values")
+
+	clients, server := setupTestLocalSync(t, 1)
+	defer teardownTestLocalSync(clients, server)
+
+	w := clients[0].c.Watch("key/", kvclient.Version{})
+	defer w.Close()
+	// Let the watch reach the actor before the writes.
+	time.Sleep(100 * time.Millisecond)
+
+	const rounds, keys = 30, 100
+	for i := 0; i < rounds; i++ {
+		entries := make(map[string]string)
+		for j := 0; j < keys; j++ {
+			entries[fmt.Sprintf("key/%03d", j)] = fmt.Sprintf("round-%d", i)
+		}
+		if err := clients[0].c.MultiPut(entries); err != nil {
+			t.Fatalf("[ERROR] MultiPut failed: %s", err)
+		}
+	}
+
+	latest := make(map[string]string)
+	done, count := 0, 0
+	final := fmt.Sprintf("round-%d", rounds-1)
+	for done < keys {
+		event := nextEvent(t, w, localSyncDeadline)
+		count++
+		if latest[event.Key] != final && event.Value == final {
+			done++
+		}
+		latest[event.Key] = event.Value
+	}
+	if count >= rounds*keys {
+		t.Fatalf("[ERROR] Slow watcher got all %d changes, expected fewer", count)
+	}
+}